@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// Lease is a running task with owner and seconds left
+type Lease struct {
+	Group    string `json:",omitempty"`
+	ID       string
+	Client   string
+	TTL      int64
+	Token    int64
+	Progress string `json:",omitempty"`
+}
+
+// groups returns configured groups or one empty group for plain queue
+func groups() []string {
+	if !isTopic() {
+		return []string{""}
+	}
+	return cfg.Groups
+}
+
+func listLeases() (int, *[]Lease, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	result := []Lease{}
+	ttl := make(map[clientv3.LeaseID]int64) // stream tasks share a lease
+	for _, g := range groups() {
+		prefix := activePrefix(g)
+		resp, err := client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+		if err != nil {
+			return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to get leases")
+		}
+		progress, err := client.Get(ctx, progressPrefix(g), clientv3.WithPrefix())
+		if err != nil {
+			return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to get progress")
+		}
+		reported := make(map[string]string, len(progress.Kvs))
+		for _, ev := range progress.Kvs {
+			reported[string(ev.Key)[len(progressPrefix(g)):]] = string(ev.Value)
+		}
+		for _, ev := range resp.Kvs {
+			id := clientv3.LeaseID(ev.Lease)
+			if _, ok := ttl[id]; !ok {
+				l, err := client.TimeToLive(ctx, id)
+				if err != nil {
+					return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to get lease ttl")
+				}
+				ttl[id] = l.TTL
+			}
+			task := string(ev.Key)[len(prefix):]
+			result = append(result, Lease{Group: g, ID: task, Client: string(ev.Value), TTL: ttl[id], Token: ev.CreateRevision, Progress: reported[task]})
+		}
+	}
+	return http.StatusOK, &result, nil
+}
+
+// requeueDead moves task from dead letter queue to queue as new task with same metadata.
+// in topic mode task goes to its group only, other groups get it acked
+func requeueDead(taskID *string, group *string) (int, *KV, error) {
+	if code, err := checkGroup(group); err != nil {
+		return code, nil, err
+	}
+	g := groupName(group)
+	key, metaKey := deadPrefix(g)+*taskID, deadMetaPrefix(g)+*taskID
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	resp, err := client.Txn(ctx).Then(clientv3.OpGet(key), clientv3.OpGet(metaKey)).Commit()
+	if err != nil {
+		return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to get dead task")
+	}
+	dead := resp.Responses[0].GetResponseRange().Kvs
+	if len(dead) == 0 {
+		return http.StatusNotFound, nil, fmt.Errorf("no dead task %v", *taskID)
+	}
+	task := KV{ID: newTaskID(), Value: string(dead[0].Value)}
+	var meta *taskMeta
+	if kvs := resp.Responses[1].GetResponseRange().Kvs; len(kvs) > 0 {
+		meta = &taskMeta{}
+		if err = json.Unmarshal(kvs[0].Value, meta); err != nil {
+			return http.StatusInternalServerError, nil, errors.Wrap(err, "bad dead task metadata")
+		}
+		task.Trace, task.MessageGroup = meta.Trace, meta.Group
+	}
+	f := log.Fields{"task": *taskID, "new": task.ID}
+	code, err := putPlanned(ctx, 1, len(task.Value), "", func(plan *putPlan) (int, error) {
+		ops, err := queueTaskOps(plan.queue, task.ID, task.Value, meta)
+		if err != nil {
+			return http.StatusInternalServerError, errors.Wrap(err, "fail to requeue task")
+		}
+		ops = append(append(ops, plan.ops...), clientv3.OpDelete(key), clientv3.OpDelete(metaKey))
+		// spill queue has groups of its own
+		if g != "" && !plan.spilled {
+			for _, o := range groups() {
+				if o != g {
+					ops = append(ops, clientv3.OpPut(groupAckedPrefix(o)+task.ID, ackedInTxn))
+				}
+			}
+		}
+		txn, err := client.Txn(ctx).
+			If(append(plan.cmps, clientv3.Compare(clientv3.ModRevision(key), "=", dead[0].ModRevision))...).
+			Then(ops...).
+			Else(clientv3.OpGet(key)).
+			Commit()
+		if err != nil {
+			return http.StatusInternalServerError, errors.Wrap(err, "fail to requeue task")
+		}
+		if !txn.Succeeded {
+			if kvs := txn.Responses[0].GetResponseRange().Kvs; len(kvs) > 0 && kvs[0].ModRevision == dead[0].ModRevision {
+				return 0, errQueueChanged
+			}
+			return http.StatusConflict, fmt.Errorf("dead task %v changed", *taskID)
+		}
+		if plan.spilled {
+			task.Queue = plan.queue
+		}
+		plan.added(1, f)
+		return http.StatusOK, nil
+	})
+	if err != nil {
+		return code, nil, err
+	}
+	logger.WithFields(f).Info("dead task requeued")
+	// other groups pass requeued task as acked one
+	if g != "" && task.Queue == "" {
+		for _, o := range groups() {
+			if o == g {
+				continue
+			}
+			if err = advanceCursor(ctx, o); err != nil {
+				logger.WithFields(f).Warnf("fail to advance cursor: %v", err)
+			}
+		}
+	}
+	return http.StatusOK, &task, nil
+}
+
+func deleteDead(taskID *string, group *string) (int, error) {
+	if code, err := checkGroup(group); err != nil {
+		return code, err
+	}
+	g := groupName(group)
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	resp, err := client.Txn(ctx).Then(
+		clientv3.OpDelete(deadPrefix(g)+*taskID),
+		clientv3.OpDelete(deadMetaPrefix(g)+*taskID)).Commit()
+	cancel()
+	if err != nil {
+		return http.StatusInternalServerError, errors.Wrap(err, "fail to delete dead task")
+	}
+	if resp.Responses[0].GetResponseDeleteRange().Deleted == 0 {
+		return http.StatusNotFound, fmt.Errorf("no dead task %v", *taskID)
+	}
+	return http.StatusOK, nil
+}
+
+// deleteTask removes task with all per-group keys, client holding the task gets 404 on ack
+func deleteTask(taskID *string) (int, error) {
+	ops := dropTaskOps(*taskID)
+	for _, g := range groups() {
+		ops = append(ops, clientv3.OpDelete(activePrefix(g)+*taskID), clientv3.OpDelete(deadlinePrefix(g)+*taskID))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	resp, err := client.Txn(ctx).Then(ops...).Commit()
+	cancel()
+	if err != nil {
+		return http.StatusInternalServerError, errors.Wrap(err, "fail to delete task")
+	}
+	if resp.Responses[0].GetResponseDeleteRange().Deleted == 0 {
+		return http.StatusNotFound, fmt.Errorf("no task %v", *taskID)
+	}
+	logger.WithField("task", *taskID).Info("task deleted")
+	return http.StatusOK, nil
+}
+
+// purgeQueue removes all tasks and leases, dead letters and state are kept
+func purgeQueue() (int, error) {
+	ops := deleteTasksOps("", "\xff")
+	for _, g := range groups() {
+		ops = append(ops, clientv3.OpDelete(activePrefix(g), clientv3.WithPrefix()),
+			clientv3.OpDelete(progressPrefix(g), clientv3.WithPrefix()),
+			clientv3.OpDelete(attemptsKey(g, ""), clientv3.WithPrefix()),
+			clientv3.OpDelete(startedKey(g, ""), clientv3.WithPrefix()),
+			clientv3.OpDelete(deadlinePrefix(g), clientv3.WithPrefix()))
+		if g != "" {
+			ops = append(ops, clientv3.OpDelete(groupAckedPrefix(g), clientv3.WithPrefix()))
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	resp, err := client.Txn(ctx).Then(ops...).Commit()
+	cancel()
+	if err != nil {
+		return http.StatusInternalServerError, errors.Wrap(err, "fail to purge queue")
+	}
+	logger.WithField("count", resp.Responses[0].GetResponseDeleteRange().Deleted).Warn("queue purged")
+	return http.StatusOK, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// kill naks task leased by client until it is dead
+func kill(t *testing.T, clientID string, group string, id string) {
+	t.Helper()
+	var g *string
+	if group != "" {
+		g = &group
+	}
+	if code, err := nakTask(&clientID, &id, g, nil, nil); err != nil {
+		t.Fatalf("nak: %v %v", code, err)
+	}
+}
+
+// keyCount returns number of keys with prefix
+func keyCount(t *testing.T, prefix string) int64 {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	resp, err := client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Count
+}
+
+func TestRequeueDead(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	useSettings(t, settings{LogLevel: "info", Limit: 100, MaxAttempts: 1})
+	data, msgGroup, maxTime := "task", "g1", int64(60)
+	code, put, err := putTask(&data, nil, nil, nil, &msgGroup, nil, nil, &maxTime, nil, nil, nil)
+	assert.Nil(err, code)
+	kill(t, "w", "", lease(t, "w", "").ID)
+	assert.Empty(taskKeys(t))
+	assert.Equal(int64(1), keyCount(t, deadMetaPrefix("")))
+
+	code, task, err := requeueDead(&put.ID, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(msgGroup, task.MessageGroup)
+	assert.Equal([]string{task.ID}, taskKeys(t))
+	// metadata kept
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	meta, err := loadMeta(ctx, task.ID, task.ID)
+	assert.Nil(err)
+	assert.Equal(&taskMeta{Group: msgGroup, MaxTime: maxTime}, meta[task.ID])
+	assert.Zero(keyCount(t, deadPrefix("")))
+	assert.Zero(keyCount(t, deadMetaPrefix("")))
+
+	code, _, err = requeueDead(&put.ID, nil)
+	assert.NotNil(err)
+	assert.Equal(http.StatusNotFound, code)
+}
+
+func TestRequeueDeadTopic(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t, "a", "b")
+	useSettings(t, settings{LogLevel: "info", Limit: 100, MaxAttempts: 1})
+	id := addTask(t, "task")
+	ack(t, "w", "b", lease(t, "w", "b").ID)
+	kill(t, "w", "a", lease(t, "w", "a").ID)
+	assert.Empty(taskKeys(t))
+
+	a := "a"
+	code, task, err := requeueDead(&id, &a)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	// task for failed group only
+	timeout := int64(10)
+	b, w := "b", "w"
+	code, _, err = getTask(&w, &timeout, &b, nil, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusNoContent, code)
+	assert.Equal(task.ID, lease(t, "w", "a").ID)
+	ack(t, "w", "a", task.ID)
+	assert.Empty(taskKeys(t))
+	assert.Zero(keyCount(t, groupAckedPrefix("b")))
+}
+
+func TestDeleteTask(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t, "a")
+	data, maxTime, progress := "task", int64(60), "50"
+	code, put, err := putTask(&data, nil, nil, nil, nil, nil, nil, &maxTime, nil, nil, nil)
+	assert.Nil(err, code)
+	a, w := "a", "w"
+	lease(t, w, a)
+	code, err = renewTask(&w, &put.ID, &a, &progress, nil, nil)
+	assert.Nil(err, code)
+	keys := []string{activePrefix(a), deadlinePrefix(a), startedKey(a, ""), progressPrefix(a), metaPrefix()}
+	for _, k := range keys {
+		assert.Equal(int64(1), keyCount(t, k+put.ID), k)
+	}
+
+	code, err = deleteTask(&put.ID)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Empty(taskKeys(t))
+	for _, k := range keys {
+		assert.Zero(keyCount(t, k+put.ID), k)
+	}
+	code, err = deleteTask(&put.ID)
+	assert.NotNil(err)
+	assert.Equal(http.StatusNotFound, code)
+}
+
+func TestPurge(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	useSettings(t, settings{LogLevel: "info", Limit: 100, MaxAttempts: 1})
+	data, maxTime, progress, w := "task", int64(60), "50", "w"
+	_, dead, err := putTask(&data, nil, nil, nil, nil, nil, nil, &maxTime, nil, nil, nil)
+	assert.Nil(err)
+	kill(t, w, "", lease(t, w, "").ID)
+	_, put, err := putTask(&data, nil, nil, nil, nil, nil, nil, &maxTime, nil, nil, nil)
+	assert.Nil(err)
+	addTask(t, "pending")
+	lease(t, w, "")
+	code, err := renewTask(&w, &put.ID, nil, &progress, nil, nil)
+	assert.Nil(err, code)
+
+	// queue purged, dead tasks stay
+	code, err = purgeQueue()
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Empty(taskKeys(t))
+	for _, k := range []string{metaPrefix(), activePrefix(""), deadlinePrefix(""), startedKey("", ""), progressPrefix("")} {
+		assert.Zero(keyCount(t, k), k)
+	}
+	assert.Equal(int64(1), keyCount(t, deadPrefix("")))
+	assert.Equal(int64(1), keyCount(t, deadMetaPrefix("")))
+
+	code, err = deleteDead(&dead.ID, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Zero(keyCount(t, deadPrefix("")))
+	assert.Zero(keyCount(t, deadMetaPrefix("")))
+	code, err = deleteDead(&dead.ID, nil)
+	assert.NotNil(err)
+	assert.Equal(http.StatusNotFound, code)
+
+	addTask(t, "dead")
+	kill(t, w, "", lease(t, w, "").ID)
+	code, err = purgeDead(nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Zero(keyCount(t, deadPrefix("")))
+	assert.Zero(keyCount(t, deadMetaPrefix("")))
+}
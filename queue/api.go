@@ -1,14 +1,11 @@
-/*
------------------------------------------
-AUTOGENERATED INTERFACE FILE, DO NOT EDIT
------------------------------------------
-
+// Code generated by swagger/gen from urykhy1-queue-1.0.0-openapi.yaml. DO NOT EDIT.
 
+/*
 task queue
 
 This is a sample task queue server.
-
 */
+
 package main
 
 import (
@@ -20,26 +17,1863 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// openAPISpec is urykhy1-queue-1.0.0-openapi.yaml as json
+const openAPISpec = `{
+  "components": {
+    "schemas": {
+      "CronJob": {
+        "properties": {
+          "CatchUp": {
+            "enum": [
+              "skip",
+              "once",
+              "all"
+            ],
+            "type": "string"
+          },
+          "Data": {
+            "type": "string"
+          },
+          "MaxRenew": {
+            "format": "int64",
+            "type": "integer"
+          },
+          "MaxTime": {
+            "format": "int64",
+            "type": "integer"
+          },
+          "MessageGroup": {
+            "type": "string"
+          },
+          "Name": {
+            "type": "string"
+          },
+          "Require": {
+            "items": {
+              "type": "string"
+            },
+            "type": "array"
+          },
+          "Schedule": {
+            "type": "string"
+          }
+        },
+        "required": [
+          "Name",
+          "Schedule",
+          "Data",
+          "CatchUp"
+        ],
+        "type": "object"
+      },
+      "Event": {
+        "properties": {
+          "Client": {
+            "description": "client holding task, for leased, acked, naked, expired and dead",
+            "type": "string"
+          },
+          "Group": {
+            "description": "consumer group in topic mode",
+            "type": "string"
+          },
+          "Revision": {
+            "description": "etcd revision of change, events of one revision share it",
+            "format": "int64",
+            "type": "integer"
+          },
+          "Task": {
+            "description": "task id",
+            "type": "string"
+          },
+          "Time": {
+            "description": "time event seen, RFC3339",
+            "type": "string"
+          },
+          "Type": {
+            "enum": [
+              "put",
+              "leased",
+              "acked",
+              "naked",
+              "expired",
+              "dead"
+            ],
+            "type": "string"
+          }
+        },
+        "required": [
+          "Revision",
+          "Type",
+          "Task",
+          "Time"
+        ],
+        "type": "object"
+      },
+      "InternalKey": {
+        "properties": {
+          "Group": {
+            "type": "string"
+          },
+          "ID": {
+            "type": "string"
+          },
+          "Kind": {
+            "type": "string"
+          },
+          "Value": {
+            "type": "string"
+          }
+        },
+        "required": [
+          "Kind",
+          "Value"
+        ],
+        "type": "object"
+      },
+      "KV": {
+        "properties": {
+          "Duplicate": {
+            "description": "set if put skipped as duplicate, ID and Value are of task added before (Value empty if task done)",
+            "type": "boolean"
+          },
+          "ID": {
+            "description": "task id",
+            "type": "string"
+          },
+          "MessageGroup": {
+            "description": "message group from put",
+            "type": "string"
+          },
+          "Progress": {
+            "description": "progress reported by worker",
+            "type": "string"
+          },
+          "Queue": {
+            "description": "queue task put to, set if task spilled on overflow",
+            "type": "string"
+          },
+          "Token": {
+            "description": "fencing token, grows with every lease of task",
+            "format": "int64",
+            "type": "integer"
+          },
+          "Trace": {
+            "description": "W3C traceparent of producer",
+            "type": "string"
+          },
+          "Value": {
+            "description": "task value",
+            "type": "string"
+          }
+        },
+        "required": [
+          "ID",
+          "Value"
+        ],
+        "type": "object"
+      },
+      "Lease": {
+        "properties": {
+          "Client": {
+            "type": "string"
+          },
+          "Group": {
+            "type": "string"
+          },
+          "ID": {
+            "type": "string"
+          },
+          "Progress": {
+            "type": "string"
+          },
+          "TTL": {
+            "description": "seconds left",
+            "type": "integer"
+          },
+          "Token": {
+            "format": "int64",
+            "type": "integer"
+          }
+        },
+        "required": [
+          "ID",
+          "Client",
+          "TTL"
+        ],
+        "type": "object"
+      },
+      "Session": {
+        "properties": {
+          "ClientID": {
+            "type": "string"
+          },
+          "Hold": {
+            "description": "max tasks held in session",
+            "type": "integer"
+          },
+          "ID": {
+            "description": "session id, pass to get, keepalive and close",
+            "type": "string"
+          },
+          "TTL": {
+            "description": "keepalive timeout in seconds",
+            "type": "integer"
+          }
+        },
+        "required": [
+          "ID",
+          "ClientID",
+          "TTL",
+          "Hold"
+        ],
+        "type": "object"
+      },
+      "State": {
+        "properties": {
+          "Paused": {
+            "description": "task dispatch paused",
+            "type": "boolean"
+          },
+          "Revision": {
+            "description": "etcd revision of last state change",
+            "type": "integer"
+          },
+          "State": {
+            "description": "currect state",
+            "type": "string"
+          }
+        },
+        "required": [
+          "State",
+          "Revision"
+        ],
+        "type": "object"
+      },
+      "StateChange": {
+        "properties": {
+          "Revision": {
+            "description": "etcd revision of transition",
+            "type": "integer"
+          },
+          "State": {
+            "description": "state set by transition",
+            "type": "string"
+          },
+          "Tasks": {
+            "description": "task ids added with transition",
+            "items": {
+              "type": "string"
+            },
+            "type": "array"
+          }
+        },
+        "required": [
+          "State",
+          "Revision",
+          "Tasks"
+        ],
+        "type": "object"
+      },
+      "Stats": {
+        "properties": {
+          "Active": {
+            "type": "integer"
+          },
+          "Dead": {
+            "type": "integer"
+          },
+          "Group": {
+            "type": "string"
+          },
+          "Pending": {
+            "type": "integer"
+          }
+        },
+        "required": [
+          "Pending",
+          "Active",
+          "Dead"
+        ],
+        "type": "object"
+      },
+      "Worker": {
+        "properties": {
+          "ID": {
+            "description": "client id",
+            "type": "string"
+          },
+          "LastSeen": {
+            "description": "time of registration or last heartbeat, RFC3339",
+            "type": "string"
+          },
+          "Registered": {
+            "description": "false for client holding tasks without registration",
+            "type": "boolean"
+          },
+          "TTL": {
+            "description": "seconds left to next heartbeat",
+            "type": "integer"
+          },
+          "Tags": {
+            "items": {
+              "type": "string"
+            },
+            "type": "array"
+          },
+          "Tasks": {
+            "description": "held tasks, ` + "`" + `group:` + "`" + ` prefixed in topic mode",
+            "items": {
+              "type": "string"
+            },
+            "type": "array"
+          }
+        },
+        "required": [
+          "ID",
+          "Registered"
+        ],
+        "type": "object"
+      }
+    }
+  },
+  "info": {
+    "description": "This is a sample task queue server.\n",
+    "title": "task queue",
+    "version": "1.0.0"
+  },
+  "openapi": "3.0.3",
+  "paths": {
+    "/ack": {
+      "get": {
+        "operationId": "ackTask",
+        "parameters": [
+          {
+            "in": "query",
+            "name": "client_id",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "in": "query",
+            "name": "task_id",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "consumer group, required if queue configured as topic",
+            "in": "query",
+            "name": "group",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "data for follow-up tasks, added in same transaction with ack",
+            "in": "query",
+            "name": "next",
+            "schema": {
+              "items": {
+                "type": "string"
+              },
+              "type": "array"
+            }
+          },
+          {
+            "description": "task result, available via /result for result-ttl",
+            "in": "query",
+            "name": "result",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "fencing token from get, stale token rejected with 409",
+            "in": "query",
+            "name": "token",
+            "schema": {
+              "format": "int64",
+              "type": "integer"
+            }
+          },
+          {
+            "description": "W3C trace context, stored with follow-up tasks",
+            "in": "header",
+            "name": "traceparent",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "request id, set by server if not passed, added to logs of operation",
+            "in": "header",
+            "name": "X-Request-ID",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "content": {
+              "application/json": {
+                "schema": {
+                  "items": {
+                    "$ref": "#/components/schemas/KV"
+                  },
+                  "type": "array"
+                }
+              }
+            },
+            "description": "OK"
+          },
+          "404": {
+            "description": "Not found"
+          },
+          "409": {
+            "description": "stale token"
+          }
+        },
+        "summary": "mark task as done"
+      }
+    },
+    "/clients": {
+      "get": {
+        "operationId": "listWorkers",
+        "responses": {
+          "200": {
+            "content": {
+              "application/json": {
+                "schema": {
+                  "items": {
+                    "$ref": "#/components/schemas/Worker"
+                  },
+                  "type": "array"
+                }
+              }
+            },
+            "description": "OK"
+          }
+        },
+        "summary": "registered workers and clients holding tasks"
+      }
+    },
+    "/cron/delete": {
+      "get": {
+        "operationId": "deleteCron",
+        "parameters": [
+          {
+            "in": "query",
+            "name": "name",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK"
+          },
+          "404": {
+            "description": "Not found"
+          }
+        },
+        "summary": "delete recurring task"
+      }
+    },
+    "/cron/list": {
+      "get": {
+        "operationId": "listCron",
+        "responses": {
+          "200": {
+            "content": {
+              "application/json": {
+                "schema": {
+                  "items": {
+                    "$ref": "#/components/schemas/CronJob"
+                  },
+                  "type": "array"
+                }
+              }
+            },
+            "description": "OK"
+          }
+        },
+        "summary": "list recurring tasks"
+      }
+    },
+    "/cron/put": {
+      "get": {
+        "operationId": "putCron",
+        "parameters": [
+          {
+            "in": "query",
+            "name": "name",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "cron spec ` + "`" + `min hour day month weekday` + "`" + `, @hourly, @daily, @weekly, @monthly or ` + "`" + `@every 1h` + "`" + `",
+            "in": "query",
+            "name": "schedule",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "user data for added tasks",
+            "in": "query",
+            "name": "data",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "what to do with runs missed while queue was down, skip, once (default) or all",
+            "in": "query",
+            "name": "catch_up",
+            "schema": {
+              "enum": [
+                "skip",
+                "once",
+                "all"
+              ],
+              "type": "string"
+            }
+          },
+          {
+            "description": "message group of added tasks",
+            "in": "query",
+            "name": "message_group",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "worker tags ` + "`" + `key=value` + "`" + ` of added tasks",
+            "in": "query",
+            "name": "require",
+            "schema": {
+              "items": {
+                "type": "string"
+              },
+              "type": "array"
+            }
+          },
+          {
+            "description": "max_time of added tasks, see put",
+            "in": "query",
+            "name": "max_time",
+            "schema": {
+              "format": "int64",
+              "minimum": 1,
+              "type": "integer"
+            }
+          },
+          {
+            "description": "max_renew of added tasks, see put",
+            "in": "query",
+            "name": "max_renew",
+            "schema": {
+              "format": "int64",
+              "minimum": 1,
+              "type": "integer"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK"
+          },
+          "400": {
+            "description": "bad schedule"
+          }
+        },
+        "summary": "add or replace recurring task"
+      }
+    },
+    "/dead/delete": {
+      "delete": {
+        "operationId": "deleteDead",
+        "parameters": [
+          {
+            "in": "query",
+            "name": "task_id",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "consumer group, required if queue configured as topic",
+            "in": "query",
+            "name": "group",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK"
+          },
+          "404": {
+            "description": "Not found"
+          }
+        },
+        "summary": "remove task from dead letter queue"
+      }
+    },
+    "/dead/list": {
+      "get": {
+        "operationId": "listDead",
+        "parameters": [
+          {
+            "description": "consumer group, required if queue configured as topic",
+            "in": "query",
+            "name": "group",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "content": {
+              "application/json": {
+                "schema": {
+                  "items": {
+                    "$ref": "#/components/schemas/KV"
+                  },
+                  "type": "array"
+                }
+              }
+            },
+            "description": "OK"
+          }
+        },
+        "summary": "list tasks in dead letter queue"
+      }
+    },
+    "/dead/purge": {
+      "delete": {
+        "operationId": "purgeDead",
+        "parameters": [
+          {
+            "description": "consumer group, required if queue configured as topic",
+            "in": "query",
+            "name": "group",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK"
+          }
+        },
+        "summary": "remove all tasks from dead letter queue"
+      }
+    },
+    "/dead/requeue": {
+      "post": {
+        "operationId": "requeueDead",
+        "parameters": [
+          {
+            "in": "query",
+            "name": "task_id",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "consumer group, required if queue configured as topic",
+            "in": "query",
+            "name": "group",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/KV"
+                }
+              }
+            },
+            "description": "OK"
+          },
+          "404": {
+            "description": "Not found"
+          },
+          "409": {
+            "description": "Conflict"
+          }
+        },
+        "summary": "move task from dead letter queue to queue with new id"
+      }
+    },
+    "/delete": {
+      "delete": {
+        "operationId": "deleteTask",
+        "parameters": [
+          {
+            "in": "query",
+            "name": "task_id",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK"
+          },
+          "404": {
+            "description": "Not found"
+          }
+        },
+        "summary": "remove task from queue, even if running"
+      }
+    },
+    "/dump": {
+      "get": {
+        "operationId": "dump",
+        "responses": {
+          "200": {
+            "content": {
+              "application/json": {
+                "schema": {
+                  "items": {
+                    "$ref": "#/components/schemas/KV"
+                  },
+                  "type": "array"
+                }
+              }
+            },
+            "description": "OK"
+          }
+        },
+        "summary": "dump all tasks in queue"
+      }
+    },
+    "/events": {
+      "get": {
+        "operationId": "listEvents",
+        "parameters": [
+          {
+            "description": "events after revision, Revision of last seen event",
+            "in": "query",
+            "name": "since_revision",
+            "schema": {
+              "format": "int64",
+              "type": "integer"
+            }
+          },
+          {
+            "description": "max events returned, 100 by default, at most 1000",
+            "in": "query",
+            "name": "limit",
+            "schema": {
+              "format": "int64",
+              "minimum": 1,
+              "type": "integer"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "content": {
+              "application/json": {
+                "schema": {
+                  "items": {
+                    "$ref": "#/components/schemas/Event"
+                  },
+                  "type": "array"
+                }
+              }
+            },
+            "description": "OK"
+          },
+          "404": {
+            "description": "event feed disabled"
+          }
+        },
+        "summary": "task lifecycle events in revision order, kept for event-retention"
+      }
+    },
+    "/get": {
+      "get": {
+        "operationId": "getTask",
+        "parameters": [
+          {
+            "in": "query",
+            "name": "client_id",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "lease timeout in seconds, required without session",
+            "in": "query",
+            "name": "timeout",
+            "schema": {
+              "minimum": 1,
+              "type": "integer"
+            }
+          },
+          {
+            "description": "consumer group, required if queue configured as topic",
+            "in": "query",
+            "name": "group",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "session id, task held under session lease instead of own one",
+            "in": "query",
+            "name": "session",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "request id, set by server if not passed, added to logs of operation",
+            "in": "header",
+            "name": "X-Request-ID",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/KV"
+                }
+              }
+            },
+            "description": "OK"
+          },
+          "204": {
+            "description": "no task available"
+          },
+          "400": {
+            "description": "no timeout or session"
+          },
+          "404": {
+            "description": "session expired or opened by other client"
+          },
+          "429": {
+            "description": "rate limit or max active tasks reached",
+            "headers": {
+              "Retry-After": {
+                "description": "seconds to wait before next get",
+                "schema": {
+                  "type": "integer"
+                }
+              }
+            }
+          }
+        },
+        "summary": "get next task from queue"
+      }
+    },
+    "/heartbeat": {
+      "get": {
+        "operationId": "heartbeatWorker",
+        "parameters": [
+          {
+            "in": "query",
+            "name": "client_id",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK"
+          },
+          "404": {
+            "description": "not registered or expired"
+          }
+        },
+        "summary": "refresh worker registration"
+      }
+    },
+    "/keys": {
+      "get": {
+        "operationId": "listKeys",
+        "responses": {
+          "200": {
+            "content": {
+              "application/json": {
+                "schema": {
+                  "items": {
+                    "$ref": "#/components/schemas/InternalKey"
+                  },
+                  "type": "array"
+                }
+              }
+            },
+            "description": "OK"
+          }
+        },
+        "summary": "list queue keys in etcd, decoded"
+      }
+    },
+    "/leases": {
+      "get": {
+        "operationId": "listLeases",
+        "responses": {
+          "200": {
+            "content": {
+              "application/json": {
+                "schema": {
+                  "items": {
+                    "$ref": "#/components/schemas/Lease"
+                  },
+                  "type": "array"
+                }
+              }
+            },
+            "description": "OK"
+          }
+        },
+        "summary": "running tasks with owner and seconds left"
+      }
+    },
+    "/nak": {
+      "get": {
+        "operationId": "nakTask",
+        "parameters": [
+          {
+            "in": "query",
+            "name": "client_id",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "in": "query",
+            "name": "task_id",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "consumer group, required if queue configured as topic",
+            "in": "query",
+            "name": "group",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "fencing token from get, stale token rejected with 409",
+            "in": "query",
+            "name": "token",
+            "schema": {
+              "format": "int64",
+              "type": "integer"
+            }
+          },
+          {
+            "description": "request id, set by server if not passed, added to logs of operation",
+            "in": "header",
+            "name": "X-Request-ID",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK"
+          },
+          "404": {
+            "description": "Not found"
+          },
+          "409": {
+            "description": "stale token"
+          }
+        },
+        "summary": "release task for other clients, task moved to dead letter queue after max-attempts"
+      }
+    },
+    "/pause": {
+      "get": {
+        "operationId": "pauseQueue",
+        "responses": {
+          "200": {
+            "description": "OK"
+          }
+        },
+        "summary": "stop leasing tasks, put, renew and ack keep working"
+      }
+    },
+    "/purge": {
+      "delete": {
+        "operationId": "purgeQueue",
+        "responses": {
+          "200": {
+            "description": "OK"
+          }
+        },
+        "summary": "remove all tasks from queue, dead letters and state are kept"
+      }
+    },
+    "/put": {
+      "get": {
+        "operationId": "putTask",
+        "parameters": [
+          {
+            "description": "user data assotiated with task (filename and so on)",
+            "in": "query",
+            "name": "data",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "old state, used to add new task in CAS manner",
+            "in": "query",
+            "name": "old",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "new state, add task only if ` + "`" + `old` + "`" + ` matches current state",
+            "in": "query",
+            "name": "state",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "task ids, task available only after all parents acked",
+            "in": "query",
+            "name": "parents",
+            "schema": {
+              "items": {
+                "type": "string"
+              },
+              "type": "array"
+            }
+          },
+          {
+            "description": "message group, tasks of one group are leased one at a time in put order",
+            "in": "query",
+            "name": "message_group",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "worker tags ` + "`" + `key=value` + "`" + `, task leased only by registered worker having all of them",
+            "in": "query",
+            "name": "require",
+            "schema": {
+              "items": {
+                "type": "string"
+              },
+              "type": "array"
+            }
+          },
+          {
+            "description": "task skipped if added with same key within dedup-window, data hash used by default",
+            "in": "query",
+            "name": "dedup_key",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "seconds task may be processed since first get (all attempts), task released as failed attempt after it even if renewed and not given to get again",
+            "in": "query",
+            "name": "max_time",
+            "schema": {
+              "format": "int64",
+              "minimum": 1,
+              "type": "integer"
+            }
+          },
+          {
+            "description": "renews allowed over all gets, next renew refused and task released as failed attempt",
+            "in": "query",
+            "name": "max_renew",
+            "schema": {
+              "format": "int64",
+              "minimum": 1,
+              "type": "integer"
+            }
+          },
+          {
+            "description": "W3C trace context, stored with task and returned to worker",
+            "in": "header",
+            "name": "traceparent",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "request id, set by server if not passed, added to logs of operation",
+            "in": "header",
+            "name": "X-Request-ID",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/KV"
+                }
+              }
+            },
+            "description": "OK"
+          },
+          "409": {
+            "description": "Conflict"
+          },
+          "429": {
+            "description": "max-pending reached",
+            "headers": {
+              "Retry-After": {
+                "description": "seconds to wait before next put",
+                "schema": {
+                  "type": "integer"
+                }
+              }
+            }
+          },
+          "507": {
+            "description": "max-bytes reached"
+          }
+        },
+        "summary": "add task to queue"
+      },
+      "post": {
+        "operationId": "putTask",
+        "parameters": [
+          {
+            "description": "user data assotiated with task (filename and so on)",
+            "in": "query",
+            "name": "data",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "old state, used to add new task in CAS manner",
+            "in": "query",
+            "name": "old",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "new state, add task only if ` + "`" + `old` + "`" + ` matches current state",
+            "in": "query",
+            "name": "state",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "task ids, task available only after all parents acked",
+            "in": "query",
+            "name": "parents",
+            "schema": {
+              "items": {
+                "type": "string"
+              },
+              "type": "array"
+            }
+          },
+          {
+            "description": "message group, tasks of one group are leased one at a time in put order",
+            "in": "query",
+            "name": "message_group",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "worker tags ` + "`" + `key=value` + "`" + `, task leased only by registered worker having all of them",
+            "in": "query",
+            "name": "require",
+            "schema": {
+              "items": {
+                "type": "string"
+              },
+              "type": "array"
+            }
+          },
+          {
+            "description": "task skipped if added with same key within dedup-window, data hash used by default",
+            "in": "query",
+            "name": "dedup_key",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "seconds task may be processed since first get (all attempts), task released as failed attempt after it even if renewed and not given to get again",
+            "in": "query",
+            "name": "max_time",
+            "schema": {
+              "format": "int64",
+              "minimum": 1,
+              "type": "integer"
+            }
+          },
+          {
+            "description": "renews allowed over all gets, next renew refused and task released as failed attempt",
+            "in": "query",
+            "name": "max_renew",
+            "schema": {
+              "format": "int64",
+              "minimum": 1,
+              "type": "integer"
+            }
+          },
+          {
+            "description": "W3C trace context, stored with task and returned to worker",
+            "in": "header",
+            "name": "traceparent",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "request id, set by server if not passed, added to logs of operation",
+            "in": "header",
+            "name": "X-Request-ID",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/KV"
+                }
+              }
+            },
+            "description": "OK"
+          },
+          "409": {
+            "description": "Conflict"
+          },
+          "429": {
+            "description": "max-pending reached",
+            "headers": {
+              "Retry-After": {
+                "description": "seconds to wait before next put",
+                "schema": {
+                  "type": "integer"
+                }
+              }
+            }
+          },
+          "507": {
+            "description": "max-bytes reached"
+          }
+        },
+        "summary": "add task to queue"
+      }
+    },
+    "/register": {
+      "get": {
+        "operationId": "registerWorker",
+        "parameters": [
+          {
+            "in": "query",
+            "name": "client_id",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "heartbeat timeout in seconds",
+            "in": "query",
+            "name": "ttl",
+            "required": true,
+            "schema": {
+              "format": "int64",
+              "minimum": 1,
+              "type": "integer"
+            }
+          },
+          {
+            "description": "capability ` + "`" + `key=value` + "`" + `, like ` + "`" + `gpu=true` + "`" + ` or ` + "`" + `region=eu` + "`" + `",
+            "in": "query",
+            "name": "tag",
+            "schema": {
+              "items": {
+                "type": "string"
+              },
+              "type": "array"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Worker"
+                }
+              }
+            },
+            "description": "OK"
+          },
+          "400": {
+            "description": "bad tag"
+          }
+        },
+        "summary": "register worker with tags, registration expires if no heartbeat for ttl seconds"
+      }
+    },
+    "/renew": {
+      "get": {
+        "operationId": "renewTask",
+        "parameters": [
+          {
+            "in": "query",
+            "name": "client_id",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "in": "query",
+            "name": "task_id",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "consumer group, required if queue configured as topic",
+            "in": "query",
+            "name": "group",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "task progress, available in dump until task acked or lease expired",
+            "in": "query",
+            "name": "progress",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "fencing token from get, stale token rejected with 409",
+            "in": "query",
+            "name": "token",
+            "schema": {
+              "format": "int64",
+              "type": "integer"
+            }
+          },
+          {
+            "description": "request id, set by server if not passed, added to logs of operation",
+            "in": "header",
+            "name": "X-Request-ID",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK"
+          },
+          "404": {
+            "description": "Not found"
+          },
+          "409": {
+            "description": "Conflict"
+          },
+          "410": {
+            "description": "max_time or max_renew of task exceeded, task released"
+          }
+        },
+        "summary": "refresh lease on task"
+      }
+    },
+    "/result": {
+      "get": {
+        "operationId": "getResult",
+        "parameters": [
+          {
+            "in": "query",
+            "name": "task_id",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "consumer group, required if queue configured as topic",
+            "in": "query",
+            "name": "group",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/KV"
+                }
+              }
+            },
+            "description": "OK"
+          },
+          "404": {
+            "description": "Not found"
+          }
+        },
+        "summary": "get result of acked task"
+      }
+    },
+    "/resume": {
+      "get": {
+        "operationId": "resumeQueue",
+        "responses": {
+          "200": {
+            "description": "OK"
+          }
+        },
+        "summary": "resume leasing tasks after pause"
+      }
+    },
+    "/session/close": {
+      "get": {
+        "operationId": "closeSession",
+        "parameters": [
+          {
+            "description": "client opened session",
+            "in": "query",
+            "name": "client_id",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "in": "query",
+            "name": "session",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK"
+          },
+          "404": {
+            "description": "session expired or opened by other client"
+          }
+        },
+        "summary": "close session, all tasks held in session released"
+      }
+    },
+    "/session/keepalive": {
+      "get": {
+        "operationId": "keepaliveSession",
+        "parameters": [
+          {
+            "description": "client opened session",
+            "in": "query",
+            "name": "client_id",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "in": "query",
+            "name": "session",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK"
+          },
+          "404": {
+            "description": "session expired or opened by other client"
+          }
+        },
+        "summary": "refresh session, renews all tasks held in session"
+      }
+    },
+    "/session/open": {
+      "get": {
+        "operationId": "openSession",
+        "parameters": [
+          {
+            "in": "query",
+            "name": "client_id",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "description": "keepalive timeout in seconds",
+            "in": "query",
+            "name": "ttl",
+            "required": true,
+            "schema": {
+              "format": "int64",
+              "minimum": 1,
+              "type": "integer"
+            }
+          },
+          {
+            "description": "max tasks held in session, 100 by default",
+            "in": "query",
+            "name": "hold",
+            "schema": {
+              "format": "int64",
+              "minimum": 1,
+              "type": "integer"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Session"
+                }
+              }
+            },
+            "description": "OK"
+          }
+        },
+        "summary": "open session, tasks got with session held under its lease until session closed or expired"
+      }
+    },
+    "/state": {
+      "get": {
+        "operationId": "getState",
+        "responses": {
+          "200": {
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/State"
+                }
+              }
+            },
+            "description": "OK"
+          }
+        },
+        "summary": "get task state cookie"
+      }
+    },
+    "/state/history": {
+      "get": {
+        "operationId": "stateHistory",
+        "parameters": [
+          {
+            "description": "max transitions to return, default 10",
+            "in": "query",
+            "name": "limit",
+            "schema": {
+              "maximum": 100,
+              "minimum": 1,
+              "type": "integer"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "content": {
+              "application/json": {
+                "schema": {
+                  "items": {
+                    "$ref": "#/components/schemas/StateChange"
+                  },
+                  "type": "array"
+                }
+              }
+            },
+            "description": "OK"
+          },
+          "410": {
+            "description": "history compacted"
+          }
+        },
+        "summary": "last state transitions with tasks added in same transaction"
+      }
+    },
+    "/state/watch": {
+      "get": {
+        "operationId": "watchState",
+        "parameters": [
+          {
+            "description": "state revision known to client, return when state changed after it",
+            "in": "query",
+            "name": "revision",
+            "schema": {
+              "type": "integer"
+            }
+          },
+          {
+            "description": "seconds to wait, limited by server",
+            "in": "query",
+            "name": "timeout",
+            "schema": {
+              "type": "integer"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/State"
+                }
+              }
+            },
+            "description": "OK"
+          },
+          "204": {
+            "description": "state not changed"
+          }
+        },
+        "summary": "wait for state change"
+      }
+    },
+    "/stats": {
+      "get": {
+        "operationId": "stats",
+        "responses": {
+          "200": {
+            "content": {
+              "application/json": {
+                "schema": {
+                  "items": {
+                    "$ref": "#/components/schemas/Stats"
+                  },
+                  "type": "array"
+                }
+              }
+            },
+            "description": "OK"
+          }
+        },
+        "summary": "task counters, per group if queue configured as topic"
+      }
+    },
+    "/unregister": {
+      "get": {
+        "operationId": "unregisterWorker",
+        "parameters": [
+          {
+            "in": "query",
+            "name": "client_id",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "OK"
+          },
+          "404": {
+            "description": "not registered"
+          }
+        },
+        "summary": "remove worker registration, held tasks kept until acked or lease expired"
+      }
+    }
+  },
+  "servers": [
+    {
+      "url": "/api/v1"
+    }
+  ]
+}`
+
+// HeaderError is an error with headers to add to response
+type HeaderError interface {
+	error
+	Header() http.Header
+}
+
+// BodyError is an error with response body, sent as json
+type BodyError interface {
+	error
+	Body() interface{}
+}
+
+// Handler implements api operations
+type Handler interface {
+	// Dump dump all tasks in queue
+	Dump() (int, *[]KV, error)
+	// GetTask get next task from queue
+	GetTask(clientID *string, timeout *int64, group *string, session *string, xRequestID *string) (int, *KV, error)
+	// RenewTask refresh lease on task
+	RenewTask(clientID *string, taskID *string, group *string, progress *string, token *int64, xRequestID *string) (int, error)
+	// AckTask mark task as done
+	AckTask(clientID *string, taskID *string, group *string, next *[]string, result *string, token *int64, traceparent *string, xRequestID *string) (int, *[]KV, error)
+	// NakTask release task for other clients, task moved to dead letter queue after max-attempts
+	NakTask(clientID *string, taskID *string, group *string, token *int64, xRequestID *string) (int, error)
+	// GetResult get result of acked task
+	GetResult(taskID *string, group *string) (int, *KV, error)
+	// PutTask add task to queue
+	PutTask(data *string, old *string, state *string, parents *[]string, messageGroup *string, require *[]string, dedupKey *string, maxTime *int64, maxRenew *int64, traceparent *string, xRequestID *string) (int, *KV, error)
+	// GetState get task state cookie
+	GetState() (int, *State, error)
+	// WatchState wait for state change
+	WatchState(revision *int64, timeout *int64) (int, *State, error)
+	// StateHistory last state transitions with tasks added in same transaction
+	StateHistory(limit *int64) (int, *[]StateChange, error)
+	// PutCron add or replace recurring task
+	PutCron(name *string, schedule *string, data *string, catchUp *string, messageGroup *string, require *[]string, maxTime *int64, maxRenew *int64) (int, error)
+	// DeleteCron delete recurring task
+	DeleteCron(name *string) (int, error)
+	// ListCron list recurring tasks
+	ListCron() (int, *[]CronJob, error)
+	// Stats task counters, per group if queue configured as topic
+	Stats() (int, *[]Stats, error)
+	// ListDead list tasks in dead letter queue
+	ListDead(group *string) (int, *[]KV, error)
+	// PurgeDead remove all tasks from dead letter queue
+	PurgeDead(group *string) (int, error)
+	// ListKeys list queue keys in etcd, decoded
+	ListKeys() (int, *[]InternalKey, error)
+	// ListLeases running tasks with owner and seconds left
+	ListLeases() (int, *[]Lease, error)
+	// RegisterWorker register worker with tags, registration expires if no heartbeat for ttl seconds
+	RegisterWorker(clientID *string, ttl *int64, tag *[]string) (int, *Worker, error)
+	// HeartbeatWorker refresh worker registration
+	HeartbeatWorker(clientID *string) (int, error)
+	// UnregisterWorker remove worker registration, held tasks kept until acked or lease expired
+	UnregisterWorker(clientID *string) (int, error)
+	// OpenSession open session, tasks got with session held under its lease until session closed or expired
+	OpenSession(clientID *string, ttl *int64, hold *int64) (int, *Session, error)
+	// KeepaliveSession refresh session, renews all tasks held in session
+	KeepaliveSession(clientID *string, session *string) (int, error)
+	// CloseSession close session, all tasks held in session released
+	CloseSession(clientID *string, session *string) (int, error)
+	// ListEvents task lifecycle events in revision order, kept for event-retention
+	ListEvents(sinceRevision *int64, limit *int64) (int, *[]Event, error)
+	// ListWorkers registered workers and clients holding tasks
+	ListWorkers() (int, *[]Worker, error)
+	// RequeueDead move task from dead letter queue to queue with new id
+	RequeueDead(taskID *string, group *string) (int, *KV, error)
+	// DeleteDead remove task from dead letter queue
+	DeleteDead(taskID *string, group *string) (int, error)
+	// DeleteTask remove task from queue, even if running
+	DeleteTask(taskID *string) (int, error)
+	// PurgeQueue remove all tasks from queue, dead letters and state are kept
+	PurgeQueue() (int, error)
+	// PauseQueue stop leasing tasks, put, renew and ack keep working
+	PauseQueue() (int, error)
+	// ResumeQueue resume leasing tasks after pause
+	ResumeQueue() (int, error)
+}
+
+func writeAPIError(w http.ResponseWriter, l *log.Entry, code int, err error) {
+	if e, ok := err.(HeaderError); ok {
+		for k, v := range e.Header() {
+			w.Header()[k] = v
+		}
+	}
+	l.Error(err)
+	if e, ok := err.(BodyError); ok {
+		writeAPIResponse(w, l, code, e.Body())
+		return
+	}
+	w.WriteHeader(code)
+}
+
+func writeAPIResponse(w http.ResponseWriter, l *log.Entry, code int, resp interface{}) {
+	jresp, err := json.Marshal(resp)
+	if err != nil {
+		l.Error("fail to format result")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(jresp)
+}
+
 // CreateRouter creates swagger api router
-func CreateRouter(log *log.Logger) *mux.Router {
+func CreateRouter(log *log.Logger, h Handler) *mux.Router {
 	r := mux.NewRouter()
+	r.Path("/api/v1/openapi.json").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(openAPISpec))
+	})
+
 	r.Path("/api/v1/dump").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// dump all tasks in queue
-		code, resp, err := dump()
+		l := log.WithField("method", "/dump").WithField("request_id", r.Header.Get("X-Request-ID"))
+		code, resp, err := h.Dump()
 		if err != nil {
-			w.WriteHeader(code)
-			log.WithField("method", "/dump").Error(err)
+			writeAPIError(w, l, code, err)
 			return
 		}
 		if resp != nil {
-			jresp, err := json.Marshal(resp)
-			if err != nil {
-				log.WithField("method", "/dump").Error("fail to format result")
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			w.WriteHeader(code)
-			w.Write(jresp)
+			writeAPIResponse(w, l, code, resp)
 			return
 		}
 		w.WriteHeader(code)
@@ -47,51 +1881,54 @@ func CreateRouter(log *log.Logger) *mux.Router {
 
 	r.Path("/api/v1/get").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// get next task from queue
+		l := log.WithField("method", "/get").WithField("request_id", r.Header.Get("X-Request-ID"))
 		q := r.URL.Query()
-		var ClientID *string
-		{
-			_, ok := q["client_id"]
-			if ok {
-				ClientIDTmp := q.Get("client_id")
-				ClientID = &ClientIDTmp
-			} else {
-				log.WithField("method", "/get").Warn("no required param client_id")
+		var clientID *string
+		if v, ok := q["client_id"]; ok {
+			val := v[0]
+			clientID = &val
+		} else {
+			l.Warn("no required param client_id")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var timeout *int64
+		if v, ok := q["timeout"]; ok {
+			val, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				l.Warn("bad param timeout")
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-		}
-		var Timeout *int64
-		{
-			_, ok := q["timeout"]
-			if ok {
-				TimeoutTmp, err := strconv.ParseInt(q.Get("timeout"), 10, 64)
-				if err != nil {
-					log.WithField("method", "/get").Warn("bad param timeout")
-					w.WriteHeader(http.StatusBadRequest)
-					return
-				}
-				Timeout = &TimeoutTmp
-			} else {
-				log.WithField("method", "/get").Warn("no required param timeout")
+			if val < 1 {
+				l.Warn("bad param timeout")
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
+			timeout = &val
+		}
+		var group *string
+		if v, ok := q["group"]; ok {
+			val := v[0]
+			group = &val
 		}
-		code, resp, err := getTask(ClientID, Timeout)
+		var session *string
+		if v, ok := q["session"]; ok {
+			val := v[0]
+			session = &val
+		}
+		var xRequestID *string
+		if v, ok := r.Header["X-Request-Id"]; ok {
+			val := v[0]
+			xRequestID = &val
+		}
+		code, resp, err := h.GetTask(clientID, timeout, group, session, xRequestID)
 		if err != nil {
-			w.WriteHeader(code)
-			log.WithField("method", "/get").Error(err)
+			writeAPIError(w, l, code, err)
 			return
 		}
 		if resp != nil {
-			jresp, err := json.Marshal(resp)
-			if err != nil {
-				log.WithField("method", "/get").Error("fail to format result")
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			w.WriteHeader(code)
-			w.Write(jresp)
+			writeAPIResponse(w, l, code, resp)
 			return
 		}
 		w.WriteHeader(code)
@@ -99,35 +1936,54 @@ func CreateRouter(log *log.Logger) *mux.Router {
 
 	r.Path("/api/v1/renew").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// refresh lease on task
+		l := log.WithField("method", "/renew").WithField("request_id", r.Header.Get("X-Request-ID"))
 		q := r.URL.Query()
-		var ClientID *string
-		{
-			_, ok := q["client_id"]
-			if ok {
-				ClientIDTmp := q.Get("client_id")
-				ClientID = &ClientIDTmp
-			} else {
-				log.WithField("method", "/renew").Warn("no required param client_id")
-				w.WriteHeader(http.StatusBadRequest)
-				return
-			}
+		var clientID *string
+		if v, ok := q["client_id"]; ok {
+			val := v[0]
+			clientID = &val
+		} else {
+			l.Warn("no required param client_id")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var taskID *string
+		if v, ok := q["task_id"]; ok {
+			val := v[0]
+			taskID = &val
+		} else {
+			l.Warn("no required param task_id")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var group *string
+		if v, ok := q["group"]; ok {
+			val := v[0]
+			group = &val
 		}
-		var TaskID *string
-		{
-			_, ok := q["task_id"]
-			if ok {
-				TaskIDTmp := q.Get("task_id")
-				TaskID = &TaskIDTmp
-			} else {
-				log.WithField("method", "/renew").Warn("no required param task_id")
+		var progress *string
+		if v, ok := q["progress"]; ok {
+			val := v[0]
+			progress = &val
+		}
+		var token *int64
+		if v, ok := q["token"]; ok {
+			val, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				l.Warn("bad param token")
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
+			token = &val
 		}
-		code, err := renewTask(ClientID, TaskID)
+		var xRequestID *string
+		if v, ok := r.Header["X-Request-Id"]; ok {
+			val := v[0]
+			xRequestID = &val
+		}
+		code, err := h.RenewTask(clientID, taskID, group, progress, token, xRequestID)
 		if err != nil {
-			w.WriteHeader(code)
-			log.WithField("method", "/renew").Error(err)
+			writeAPIError(w, l, code, err)
 			return
 		}
 		w.WriteHeader(code)
@@ -135,35 +1991,148 @@ func CreateRouter(log *log.Logger) *mux.Router {
 
 	r.Path("/api/v1/ack").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// mark task as done
+		l := log.WithField("method", "/ack").WithField("request_id", r.Header.Get("X-Request-ID"))
 		q := r.URL.Query()
-		var ClientID *string
-		{
-			_, ok := q["client_id"]
-			if ok {
-				ClientIDTmp := q.Get("client_id")
-				ClientID = &ClientIDTmp
-			} else {
-				log.WithField("method", "/ack").Warn("no required param client_id")
+		var clientID *string
+		if v, ok := q["client_id"]; ok {
+			val := v[0]
+			clientID = &val
+		} else {
+			l.Warn("no required param client_id")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var taskID *string
+		if v, ok := q["task_id"]; ok {
+			val := v[0]
+			taskID = &val
+		} else {
+			l.Warn("no required param task_id")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var group *string
+		if v, ok := q["group"]; ok {
+			val := v[0]
+			group = &val
+		}
+		var next *[]string
+		if v, ok := q["next"]; ok {
+			val := v
+			next = &val
+		}
+		var result *string
+		if v, ok := q["result"]; ok {
+			val := v[0]
+			result = &val
+		}
+		var token *int64
+		if v, ok := q["token"]; ok {
+			val, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				l.Warn("bad param token")
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
+			token = &val
+		}
+		var traceparent *string
+		if v, ok := r.Header["Traceparent"]; ok {
+			val := v[0]
+			traceparent = &val
+		}
+		var xRequestID *string
+		if v, ok := r.Header["X-Request-Id"]; ok {
+			val := v[0]
+			xRequestID = &val
+		}
+		code, resp, err := h.AckTask(clientID, taskID, group, next, result, token, traceparent, xRequestID)
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		if resp != nil {
+			writeAPIResponse(w, l, code, resp)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/nak").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// release task for other clients, task moved to dead letter queue after max-attempts
+		l := log.WithField("method", "/nak").WithField("request_id", r.Header.Get("X-Request-ID"))
+		q := r.URL.Query()
+		var clientID *string
+		if v, ok := q["client_id"]; ok {
+			val := v[0]
+			clientID = &val
+		} else {
+			l.Warn("no required param client_id")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var taskID *string
+		if v, ok := q["task_id"]; ok {
+			val := v[0]
+			taskID = &val
+		} else {
+			l.Warn("no required param task_id")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var group *string
+		if v, ok := q["group"]; ok {
+			val := v[0]
+			group = &val
 		}
-		var TaskID *string
-		{
-			_, ok := q["task_id"]
-			if ok {
-				TaskIDTmp := q.Get("task_id")
-				TaskID = &TaskIDTmp
-			} else {
-				log.WithField("method", "/ack").Warn("no required param task_id")
+		var token *int64
+		if v, ok := q["token"]; ok {
+			val, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				l.Warn("bad param token")
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
+			token = &val
+		}
+		var xRequestID *string
+		if v, ok := r.Header["X-Request-Id"]; ok {
+			val := v[0]
+			xRequestID = &val
+		}
+		code, err := h.NakTask(clientID, taskID, group, token, xRequestID)
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/result").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// get result of acked task
+		l := log.WithField("method", "/result").WithField("request_id", r.Header.Get("X-Request-ID"))
+		q := r.URL.Query()
+		var taskID *string
+		if v, ok := q["task_id"]; ok {
+			val := v[0]
+			taskID = &val
+		} else {
+			l.Warn("no required param task_id")
+			w.WriteHeader(http.StatusBadRequest)
+			return
 		}
-		code, err := ackTask(ClientID, TaskID)
+		var group *string
+		if v, ok := q["group"]; ok {
+			val := v[0]
+			group = &val
+		}
+		code, resp, err := h.GetResult(taskID, group)
 		if err != nil {
-			w.WriteHeader(code)
-			log.WithField("method", "/ack").Error(err)
+			writeAPIError(w, l, code, err)
+			return
+		}
+		if resp != nil {
+			writeAPIResponse(w, l, code, resp)
 			return
 		}
 		w.WriteHeader(code)
@@ -171,82 +2140,194 @@ func CreateRouter(log *log.Logger) *mux.Router {
 
 	r.Path("/api/v1/put").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// add task to queue
+		l := log.WithField("method", "/put").WithField("request_id", r.Header.Get("X-Request-ID"))
 		q := r.URL.Query()
-		var Data *string
-		{
-			_, ok := q["data"]
-			if ok {
-				DataTmp := q.Get("data")
-				Data = &DataTmp
-			} else {
-				log.WithField("method", "/put").Warn("no required param data")
+		var data *string
+		if v, ok := q["data"]; ok {
+			val := v[0]
+			data = &val
+		} else {
+			l.Warn("no required param data")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var old *string
+		if v, ok := q["old"]; ok {
+			val := v[0]
+			old = &val
+		}
+		var state *string
+		if v, ok := q["state"]; ok {
+			val := v[0]
+			state = &val
+		}
+		var parents *[]string
+		if v, ok := q["parents"]; ok {
+			val := v
+			parents = &val
+		}
+		var messageGroup *string
+		if v, ok := q["message_group"]; ok {
+			val := v[0]
+			messageGroup = &val
+		}
+		var require *[]string
+		if v, ok := q["require"]; ok {
+			val := v
+			require = &val
+		}
+		var dedupKey *string
+		if v, ok := q["dedup_key"]; ok {
+			val := v[0]
+			dedupKey = &val
+		}
+		var maxTime *int64
+		if v, ok := q["max_time"]; ok {
+			val, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				l.Warn("bad param max_time")
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-		}
-		var Old *string
-		{
-			_, ok := q["old"]
-			if ok {
-				OldTmp := q.Get("old")
-				Old = &OldTmp
+			if val < 1 {
+				l.Warn("bad param max_time")
+				w.WriteHeader(http.StatusBadRequest)
+				return
 			}
+			maxTime = &val
 		}
-		var State *string
-		{
-			_, ok := q["state"]
-			if ok {
-				StateTmp := q.Get("state")
-				State = &StateTmp
+		var maxRenew *int64
+		if v, ok := q["max_renew"]; ok {
+			val, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				l.Warn("bad param max_renew")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if val < 1 {
+				l.Warn("bad param max_renew")
+				w.WriteHeader(http.StatusBadRequest)
+				return
 			}
+			maxRenew = &val
+		}
+		var traceparent *string
+		if v, ok := r.Header["Traceparent"]; ok {
+			val := v[0]
+			traceparent = &val
 		}
-		code, err := putTask(Data, Old, State)
+		var xRequestID *string
+		if v, ok := r.Header["X-Request-Id"]; ok {
+			val := v[0]
+			xRequestID = &val
+		}
+		code, resp, err := h.PutTask(data, old, state, parents, messageGroup, require, dedupKey, maxTime, maxRenew, traceparent, xRequestID)
 		if err != nil {
-			w.WriteHeader(code)
-			log.WithField("method", "/put").Error(err)
+			writeAPIError(w, l, code, err)
+			return
+		}
+		if resp != nil {
+			writeAPIResponse(w, l, code, resp)
 			return
 		}
 		w.WriteHeader(code)
 	})
+
 	r.Path("/api/v1/put").Methods("post").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// add task to queue
+		l := log.WithField("method", "/put").WithField("request_id", r.Header.Get("X-Request-ID"))
 		if err := r.ParseForm(); err != nil {
-			log.WithField("method", "/put").Warn("bad form: ", err)
+			l.Warn("bad form: ", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		q := r.Form
+		var data *string
+		if v, ok := q["data"]; ok {
+			val := v[0]
+			data = &val
+		} else {
+			l.Warn("no required param data")
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		var Data *string
-		{
-			_, ok := r.Form["data"]
-			if ok {
-				DataTmp := r.FormValue("data")
-				Data = &DataTmp
-			} else {
-				log.WithField("method", "/put").Warn("no required param data")
+		var old *string
+		if v, ok := q["old"]; ok {
+			val := v[0]
+			old = &val
+		}
+		var state *string
+		if v, ok := q["state"]; ok {
+			val := v[0]
+			state = &val
+		}
+		var parents *[]string
+		if v, ok := q["parents"]; ok {
+			val := v
+			parents = &val
+		}
+		var messageGroup *string
+		if v, ok := q["message_group"]; ok {
+			val := v[0]
+			messageGroup = &val
+		}
+		var require *[]string
+		if v, ok := q["require"]; ok {
+			val := v
+			require = &val
+		}
+		var dedupKey *string
+		if v, ok := q["dedup_key"]; ok {
+			val := v[0]
+			dedupKey = &val
+		}
+		var maxTime *int64
+		if v, ok := q["max_time"]; ok {
+			val, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				l.Warn("bad param max_time")
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-		}
-		var Old *string
-		{
-			_, ok := r.Form["old"]
-			if ok {
-				OldTmp := r.FormValue("old")
-				Old = &OldTmp
+			if val < 1 {
+				l.Warn("bad param max_time")
+				w.WriteHeader(http.StatusBadRequest)
+				return
 			}
+			maxTime = &val
 		}
-		var State *string
-		{
-			_, ok := r.Form["state"]
-			if ok {
-				StateTmp := r.FormValue("state")
-				State = &StateTmp
+		var maxRenew *int64
+		if v, ok := q["max_renew"]; ok {
+			val, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				l.Warn("bad param max_renew")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if val < 1 {
+				l.Warn("bad param max_renew")
+				w.WriteHeader(http.StatusBadRequest)
+				return
 			}
+			maxRenew = &val
+		}
+		var traceparent *string
+		if v, ok := r.Header["Traceparent"]; ok {
+			val := v[0]
+			traceparent = &val
+		}
+		var xRequestID *string
+		if v, ok := r.Header["X-Request-Id"]; ok {
+			val := v[0]
+			xRequestID = &val
 		}
-		code, err := putTask(Data, Old, State)
+		code, resp, err := h.PutTask(data, old, state, parents, messageGroup, require, dedupKey, maxTime, maxRenew, traceparent, xRequestID)
 		if err != nil {
-			w.WriteHeader(code)
-			log.WithField("method", "/put").Error(err)
+			writeAPIError(w, l, code, err)
+			return
+		}
+		if resp != nil {
+			writeAPIResponse(w, l, code, resp)
 			return
 		}
 		w.WriteHeader(code)
@@ -254,25 +2335,820 @@ func CreateRouter(log *log.Logger) *mux.Router {
 
 	r.Path("/api/v1/state").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// get task state cookie
-		code, resp, err := getState()
+		l := log.WithField("method", "/state").WithField("request_id", r.Header.Get("X-Request-ID"))
+		code, resp, err := h.GetState()
 		if err != nil {
-			w.WriteHeader(code)
-			log.WithField("method", "/state").Error(err)
+			writeAPIError(w, l, code, err)
 			return
 		}
 		if resp != nil {
-			jresp, err := json.Marshal(resp)
+			writeAPIResponse(w, l, code, resp)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/state/watch").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// wait for state change
+		l := log.WithField("method", "/state/watch").WithField("request_id", r.Header.Get("X-Request-ID"))
+		q := r.URL.Query()
+		var revision *int64
+		if v, ok := q["revision"]; ok {
+			val, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				l.Warn("bad param revision")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			revision = &val
+		}
+		var timeout *int64
+		if v, ok := q["timeout"]; ok {
+			val, err := strconv.ParseInt(v[0], 10, 64)
 			if err != nil {
-				log.WithField("method", "/state").Error("fail to format result")
-				w.WriteHeader(http.StatusInternalServerError)
+				l.Warn("bad param timeout")
+				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-			w.WriteHeader(code)
-			w.Write(jresp)
+			timeout = &val
+		}
+		code, resp, err := h.WatchState(revision, timeout)
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		if resp != nil {
+			writeAPIResponse(w, l, code, resp)
 			return
 		}
 		w.WriteHeader(code)
 	})
 
-	return r
+	r.Path("/api/v1/state/history").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// last state transitions with tasks added in same transaction
+		l := log.WithField("method", "/state/history").WithField("request_id", r.Header.Get("X-Request-ID"))
+		q := r.URL.Query()
+		var limit *int64
+		if v, ok := q["limit"]; ok {
+			val, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				l.Warn("bad param limit")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if val < 1 || val > 100 {
+				l.Warn("bad param limit")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			limit = &val
+		}
+		code, resp, err := h.StateHistory(limit)
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		if resp != nil {
+			writeAPIResponse(w, l, code, resp)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/cron/put").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// add or replace recurring task
+		l := log.WithField("method", "/cron/put").WithField("request_id", r.Header.Get("X-Request-ID"))
+		q := r.URL.Query()
+		var name *string
+		if v, ok := q["name"]; ok {
+			val := v[0]
+			name = &val
+		} else {
+			l.Warn("no required param name")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var schedule *string
+		if v, ok := q["schedule"]; ok {
+			val := v[0]
+			schedule = &val
+		} else {
+			l.Warn("no required param schedule")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var data *string
+		if v, ok := q["data"]; ok {
+			val := v[0]
+			data = &val
+		} else {
+			l.Warn("no required param data")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var catchUp *string
+		if v, ok := q["catch_up"]; ok {
+			val := v[0]
+			if val != "skip" && val != "once" && val != "all" {
+				l.Warn("bad param catch_up")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			catchUp = &val
+		}
+		var messageGroup *string
+		if v, ok := q["message_group"]; ok {
+			val := v[0]
+			messageGroup = &val
+		}
+		var require *[]string
+		if v, ok := q["require"]; ok {
+			val := v
+			require = &val
+		}
+		var maxTime *int64
+		if v, ok := q["max_time"]; ok {
+			val, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				l.Warn("bad param max_time")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if val < 1 {
+				l.Warn("bad param max_time")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			maxTime = &val
+		}
+		var maxRenew *int64
+		if v, ok := q["max_renew"]; ok {
+			val, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				l.Warn("bad param max_renew")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if val < 1 {
+				l.Warn("bad param max_renew")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			maxRenew = &val
+		}
+		code, err := h.PutCron(name, schedule, data, catchUp, messageGroup, require, maxTime, maxRenew)
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/cron/delete").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// delete recurring task
+		l := log.WithField("method", "/cron/delete").WithField("request_id", r.Header.Get("X-Request-ID"))
+		q := r.URL.Query()
+		var name *string
+		if v, ok := q["name"]; ok {
+			val := v[0]
+			name = &val
+		} else {
+			l.Warn("no required param name")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		code, err := h.DeleteCron(name)
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/cron/list").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// list recurring tasks
+		l := log.WithField("method", "/cron/list").WithField("request_id", r.Header.Get("X-Request-ID"))
+		code, resp, err := h.ListCron()
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		if resp != nil {
+			writeAPIResponse(w, l, code, resp)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/stats").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// task counters, per group if queue configured as topic
+		l := log.WithField("method", "/stats").WithField("request_id", r.Header.Get("X-Request-ID"))
+		code, resp, err := h.Stats()
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		if resp != nil {
+			writeAPIResponse(w, l, code, resp)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/dead/list").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// list tasks in dead letter queue
+		l := log.WithField("method", "/dead/list").WithField("request_id", r.Header.Get("X-Request-ID"))
+		q := r.URL.Query()
+		var group *string
+		if v, ok := q["group"]; ok {
+			val := v[0]
+			group = &val
+		}
+		code, resp, err := h.ListDead(group)
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		if resp != nil {
+			writeAPIResponse(w, l, code, resp)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/dead/purge").Methods("delete").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// remove all tasks from dead letter queue
+		l := log.WithField("method", "/dead/purge").WithField("request_id", r.Header.Get("X-Request-ID"))
+		if err := r.ParseForm(); err != nil {
+			l.Warn("bad form: ", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		q := r.Form
+		var group *string
+		if v, ok := q["group"]; ok {
+			val := v[0]
+			group = &val
+		}
+		code, err := h.PurgeDead(group)
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/keys").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// list queue keys in etcd, decoded
+		l := log.WithField("method", "/keys").WithField("request_id", r.Header.Get("X-Request-ID"))
+		code, resp, err := h.ListKeys()
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		if resp != nil {
+			writeAPIResponse(w, l, code, resp)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/leases").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// running tasks with owner and seconds left
+		l := log.WithField("method", "/leases").WithField("request_id", r.Header.Get("X-Request-ID"))
+		code, resp, err := h.ListLeases()
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		if resp != nil {
+			writeAPIResponse(w, l, code, resp)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/register").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// register worker with tags, registration expires if no heartbeat for ttl seconds
+		l := log.WithField("method", "/register").WithField("request_id", r.Header.Get("X-Request-ID"))
+		q := r.URL.Query()
+		var clientID *string
+		if v, ok := q["client_id"]; ok {
+			val := v[0]
+			clientID = &val
+		} else {
+			l.Warn("no required param client_id")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var ttl *int64
+		if v, ok := q["ttl"]; ok {
+			val, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				l.Warn("bad param ttl")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if val < 1 {
+				l.Warn("bad param ttl")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			ttl = &val
+		} else {
+			l.Warn("no required param ttl")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var tag *[]string
+		if v, ok := q["tag"]; ok {
+			val := v
+			tag = &val
+		}
+		code, resp, err := h.RegisterWorker(clientID, ttl, tag)
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		if resp != nil {
+			writeAPIResponse(w, l, code, resp)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/heartbeat").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// refresh worker registration
+		l := log.WithField("method", "/heartbeat").WithField("request_id", r.Header.Get("X-Request-ID"))
+		q := r.URL.Query()
+		var clientID *string
+		if v, ok := q["client_id"]; ok {
+			val := v[0]
+			clientID = &val
+		} else {
+			l.Warn("no required param client_id")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		code, err := h.HeartbeatWorker(clientID)
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/unregister").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// remove worker registration, held tasks kept until acked or lease expired
+		l := log.WithField("method", "/unregister").WithField("request_id", r.Header.Get("X-Request-ID"))
+		q := r.URL.Query()
+		var clientID *string
+		if v, ok := q["client_id"]; ok {
+			val := v[0]
+			clientID = &val
+		} else {
+			l.Warn("no required param client_id")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		code, err := h.UnregisterWorker(clientID)
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/session/open").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// open session, tasks got with session held under its lease until session closed or expired
+		l := log.WithField("method", "/session/open").WithField("request_id", r.Header.Get("X-Request-ID"))
+		q := r.URL.Query()
+		var clientID *string
+		if v, ok := q["client_id"]; ok {
+			val := v[0]
+			clientID = &val
+		} else {
+			l.Warn("no required param client_id")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var ttl *int64
+		if v, ok := q["ttl"]; ok {
+			val, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				l.Warn("bad param ttl")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if val < 1 {
+				l.Warn("bad param ttl")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			ttl = &val
+		} else {
+			l.Warn("no required param ttl")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var hold *int64
+		if v, ok := q["hold"]; ok {
+			val, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				l.Warn("bad param hold")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if val < 1 {
+				l.Warn("bad param hold")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			hold = &val
+		}
+		code, resp, err := h.OpenSession(clientID, ttl, hold)
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		if resp != nil {
+			writeAPIResponse(w, l, code, resp)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/session/keepalive").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// refresh session, renews all tasks held in session
+		l := log.WithField("method", "/session/keepalive").WithField("request_id", r.Header.Get("X-Request-ID"))
+		q := r.URL.Query()
+		var clientID *string
+		if v, ok := q["client_id"]; ok {
+			val := v[0]
+			clientID = &val
+		} else {
+			l.Warn("no required param client_id")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var session *string
+		if v, ok := q["session"]; ok {
+			val := v[0]
+			session = &val
+		} else {
+			l.Warn("no required param session")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		code, err := h.KeepaliveSession(clientID, session)
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/session/close").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// close session, all tasks held in session released
+		l := log.WithField("method", "/session/close").WithField("request_id", r.Header.Get("X-Request-ID"))
+		q := r.URL.Query()
+		var clientID *string
+		if v, ok := q["client_id"]; ok {
+			val := v[0]
+			clientID = &val
+		} else {
+			l.Warn("no required param client_id")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var session *string
+		if v, ok := q["session"]; ok {
+			val := v[0]
+			session = &val
+		} else {
+			l.Warn("no required param session")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		code, err := h.CloseSession(clientID, session)
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/events").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// task lifecycle events in revision order, kept for event-retention
+		l := log.WithField("method", "/events").WithField("request_id", r.Header.Get("X-Request-ID"))
+		q := r.URL.Query()
+		var sinceRevision *int64
+		if v, ok := q["since_revision"]; ok {
+			val, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				l.Warn("bad param since_revision")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			sinceRevision = &val
+		}
+		var limit *int64
+		if v, ok := q["limit"]; ok {
+			val, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				l.Warn("bad param limit")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if val < 1 {
+				l.Warn("bad param limit")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			limit = &val
+		}
+		code, resp, err := h.ListEvents(sinceRevision, limit)
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		if resp != nil {
+			writeAPIResponse(w, l, code, resp)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/clients").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// registered workers and clients holding tasks
+		l := log.WithField("method", "/clients").WithField("request_id", r.Header.Get("X-Request-ID"))
+		code, resp, err := h.ListWorkers()
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		if resp != nil {
+			writeAPIResponse(w, l, code, resp)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/dead/requeue").Methods("post").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// move task from dead letter queue to queue with new id
+		l := log.WithField("method", "/dead/requeue").WithField("request_id", r.Header.Get("X-Request-ID"))
+		if err := r.ParseForm(); err != nil {
+			l.Warn("bad form: ", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		q := r.Form
+		var taskID *string
+		if v, ok := q["task_id"]; ok {
+			val := v[0]
+			taskID = &val
+		} else {
+			l.Warn("no required param task_id")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var group *string
+		if v, ok := q["group"]; ok {
+			val := v[0]
+			group = &val
+		}
+		code, resp, err := h.RequeueDead(taskID, group)
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		if resp != nil {
+			writeAPIResponse(w, l, code, resp)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/dead/delete").Methods("delete").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// remove task from dead letter queue
+		l := log.WithField("method", "/dead/delete").WithField("request_id", r.Header.Get("X-Request-ID"))
+		if err := r.ParseForm(); err != nil {
+			l.Warn("bad form: ", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		q := r.Form
+		var taskID *string
+		if v, ok := q["task_id"]; ok {
+			val := v[0]
+			taskID = &val
+		} else {
+			l.Warn("no required param task_id")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var group *string
+		if v, ok := q["group"]; ok {
+			val := v[0]
+			group = &val
+		}
+		code, err := h.DeleteDead(taskID, group)
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/delete").Methods("delete").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// remove task from queue, even if running
+		l := log.WithField("method", "/delete").WithField("request_id", r.Header.Get("X-Request-ID"))
+		if err := r.ParseForm(); err != nil {
+			l.Warn("bad form: ", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		q := r.Form
+		var taskID *string
+		if v, ok := q["task_id"]; ok {
+			val := v[0]
+			taskID = &val
+		} else {
+			l.Warn("no required param task_id")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		code, err := h.DeleteTask(taskID)
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/purge").Methods("delete").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// remove all tasks from queue, dead letters and state are kept
+		l := log.WithField("method", "/purge").WithField("request_id", r.Header.Get("X-Request-ID"))
+		code, err := h.PurgeQueue()
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/pause").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// stop leasing tasks, put, renew and ack keep working
+		l := log.WithField("method", "/pause").WithField("request_id", r.Header.Get("X-Request-ID"))
+		code, err := h.PauseQueue()
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	r.Path("/api/v1/resume").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// resume leasing tasks after pause
+		l := log.WithField("method", "/resume").WithField("request_id", r.Header.Get("X-Request-ID"))
+		code, err := h.ResumeQueue()
+		if err != nil {
+			writeAPIError(w, l, code, err)
+			return
+		}
+		w.WriteHeader(code)
+	})
+
+	return r
+}
+
+// funcHandler implements Handler with package functions named by operationId
+type funcHandler struct{}
+
+func (funcHandler) Dump() (int, *[]KV, error) {
+	return dump()
+}
+
+func (funcHandler) GetTask(clientID *string, timeout *int64, group *string, session *string, xRequestID *string) (int, *KV, error) {
+	return getTask(clientID, timeout, group, session, xRequestID)
+}
+
+func (funcHandler) RenewTask(clientID *string, taskID *string, group *string, progress *string, token *int64, xRequestID *string) (int, error) {
+	return renewTask(clientID, taskID, group, progress, token, xRequestID)
+}
+
+func (funcHandler) AckTask(clientID *string, taskID *string, group *string, next *[]string, result *string, token *int64, traceparent *string, xRequestID *string) (int, *[]KV, error) {
+	return ackTask(clientID, taskID, group, next, result, token, traceparent, xRequestID)
+}
+
+func (funcHandler) NakTask(clientID *string, taskID *string, group *string, token *int64, xRequestID *string) (int, error) {
+	return nakTask(clientID, taskID, group, token, xRequestID)
+}
+
+func (funcHandler) GetResult(taskID *string, group *string) (int, *KV, error) {
+	return getResult(taskID, group)
+}
+
+func (funcHandler) PutTask(data *string, old *string, state *string, parents *[]string, messageGroup *string, require *[]string, dedupKey *string, maxTime *int64, maxRenew *int64, traceparent *string, xRequestID *string) (int, *KV, error) {
+	return putTask(data, old, state, parents, messageGroup, require, dedupKey, maxTime, maxRenew, traceparent, xRequestID)
+}
+
+func (funcHandler) GetState() (int, *State, error) {
+	return getState()
+}
+
+func (funcHandler) WatchState(revision *int64, timeout *int64) (int, *State, error) {
+	return watchState(revision, timeout)
+}
+
+func (funcHandler) StateHistory(limit *int64) (int, *[]StateChange, error) {
+	return stateHistory(limit)
+}
+
+func (funcHandler) PutCron(name *string, schedule *string, data *string, catchUp *string, messageGroup *string, require *[]string, maxTime *int64, maxRenew *int64) (int, error) {
+	return putCron(name, schedule, data, catchUp, messageGroup, require, maxTime, maxRenew)
+}
+
+func (funcHandler) DeleteCron(name *string) (int, error) {
+	return deleteCron(name)
+}
+
+func (funcHandler) ListCron() (int, *[]CronJob, error) {
+	return listCron()
+}
+
+func (funcHandler) Stats() (int, *[]Stats, error) {
+	return stats()
+}
+
+func (funcHandler) ListDead(group *string) (int, *[]KV, error) {
+	return listDead(group)
+}
+
+func (funcHandler) PurgeDead(group *string) (int, error) {
+	return purgeDead(group)
+}
+
+func (funcHandler) ListKeys() (int, *[]InternalKey, error) {
+	return listKeys()
+}
+
+func (funcHandler) ListLeases() (int, *[]Lease, error) {
+	return listLeases()
+}
+
+func (funcHandler) RegisterWorker(clientID *string, ttl *int64, tag *[]string) (int, *Worker, error) {
+	return registerWorker(clientID, ttl, tag)
+}
+
+func (funcHandler) HeartbeatWorker(clientID *string) (int, error) {
+	return heartbeatWorker(clientID)
+}
+
+func (funcHandler) UnregisterWorker(clientID *string) (int, error) {
+	return unregisterWorker(clientID)
+}
+
+func (funcHandler) OpenSession(clientID *string, ttl *int64, hold *int64) (int, *Session, error) {
+	return openSession(clientID, ttl, hold)
+}
+
+func (funcHandler) KeepaliveSession(clientID *string, session *string) (int, error) {
+	return keepaliveSession(clientID, session)
+}
+
+func (funcHandler) CloseSession(clientID *string, session *string) (int, error) {
+	return closeSession(clientID, session)
+}
+
+func (funcHandler) ListEvents(sinceRevision *int64, limit *int64) (int, *[]Event, error) {
+	return listEvents(sinceRevision, limit)
+}
+
+func (funcHandler) ListWorkers() (int, *[]Worker, error) {
+	return listWorkers()
+}
+
+func (funcHandler) RequeueDead(taskID *string, group *string) (int, *KV, error) {
+	return requeueDead(taskID, group)
+}
+
+func (funcHandler) DeleteDead(taskID *string, group *string) (int, error) {
+	return deleteDead(taskID, group)
+}
+
+func (funcHandler) DeleteTask(taskID *string) (int, error) {
+	return deleteTask(taskID)
+}
+
+func (funcHandler) PurgeQueue() (int, error) {
+	return purgeQueue()
+}
+
+func (funcHandler) PauseQueue() (int, error) {
+	return pauseQueue()
+}
+
+func (funcHandler) ResumeQueue() (int, error) {
+	return resumeQueue()
 }
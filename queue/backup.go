@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// export/import: queue snapshot as JSONL, one record per line.
+// export reads all keys at same revision, import puts them in batches.
+// revisions do not survive import: topic cursor exported with create revisions of tasks,
+// import marks tasks up to cursor and tasks with acked record as acked by group
+
+// Record is a line in export file: header, state, cursor, task, acked, dead, end
+type Record struct {
+	Kind         string
+	Group        string   `json:",omitempty"`
+	ID           string   `json:",omitempty"`
+	Value        string   `json:",omitempty"`
+	Parents      []string `json:",omitempty"`
+	Trace        string   `json:",omitempty"`
+	MessageGroup string   `json:",omitempty"`
+	Require      []string `json:",omitempty"`
+	MaxTime      int64    `json:",omitempty"`
+	MaxRenew     int64    `json:",omitempty"`
+	Revision     int64    `json:",omitempty"` // of export in header, of cursor, create revision of task
+}
+
+// ImportResult is counters of imported records, or keys already in queue if import refused
+type ImportResult struct {
+	State     bool
+	Tasks     int
+	Acked     int
+	Dead      int
+	Conflicts []string `json:",omitempty"`
+}
+
+// tasks per etcd request on export
+const exportPage = 1000
+
+// etcd default limit on operations in txn
+const maxTxnOps = 128
+
+// commitOps applies ops in txns of maxTxnOps, for cleanup that may be done in parts
+func commitOps(ctx context.Context, ops []clientv3.Op) error {
+	for len(ops) > 0 {
+		n := len(ops)
+		if n > maxTxnOps {
+			n = maxTxnOps
+		}
+		if _, err := client.Txn(ctx).Then(ops[:n]...).Commit(); err != nil {
+			return err
+		}
+		ops = ops[n:]
+	}
+	return nil
+}
+
+// AddBackupRoute registers export and import in router
+func AddBackupRoute(r *mux.Router) {
+	r.Path("/api/v1/export").Methods("get").HandlerFunc(exportQueue)
+	r.Path("/api/v1/import").Methods("post").HandlerFunc(importQueue)
+}
+
+// noDeadline lifts server timeouts for long export and import, as stream does for websocket.
+// writer supports deadlines since go 1.20, same lookup as http.ResponseController
+func noDeadline(w http.ResponseWriter) error {
+	type deadliner interface {
+		SetReadDeadline(time.Time) error
+		SetWriteDeadline(time.Time) error
+	}
+	for {
+		switch t := w.(type) {
+		case deadliner:
+			if err := t.SetReadDeadline(time.Time{}); err != nil {
+				return err
+			}
+			return t.SetWriteDeadline(time.Time{})
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = t.Unwrap()
+		default:
+			return fmt.Errorf("deadline not supported by %T", w)
+		}
+	}
+}
+
+func exportQueue(w http.ResponseWriter, r *http.Request) {
+	if err := noDeadline(w); err != nil {
+		logger.WithField("method", "/export").Warnf("large export may be cut by server timeout: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	state, err := client.Get(ctx, stateKey())
+	cancel()
+	if err != nil || len(state.Kvs) == 0 {
+		logger.WithField("method", "/export").Error("fail to get state")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rev := state.Header.Revision
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	enc.Encode(Record{Kind: "header", Value: cfg.Queue, Revision: rev})
+	enc.Encode(Record{Kind: "state", Value: string(state.Kvs[0].Value)})
+
+	// no way to report error after header sent, so file left without end record
+	if err = exportRecords(enc, rev); err != nil {
+		logger.WithField("method", "/export").Errorf("export failed: %v", err)
+		return
+	}
+	enc.Encode(Record{Kind: "end"})
+	logger.WithField("revision", rev).Info("queue exported")
+}
+
+// exportRecords writes cursors, tasks with acked markers and dead tasks as of revision
+func exportRecords(enc *json.Encoder, rev int64) error {
+	// acked markers of all groups read before tasks, written after task
+	acked := make(map[string][]string)
+	if isTopic() {
+		ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+		cursors, err := client.Get(ctx, cursorPrefix(), clientv3.WithPrefix(), clientv3.WithRev(rev))
+		cancel()
+		if err != nil {
+			return err
+		}
+		for _, ev := range cursors.Kvs {
+			g := string(ev.Key)[len(cursorPrefix()):]
+			if err = enc.Encode(Record{Kind: "cursor", Group: g, Revision: parseRevision(ev.Value)}); err != nil {
+				return err
+			}
+			prefix := groupAckedPrefix(g)
+			ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+			markers, err := client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly(), clientv3.WithRev(rev))
+			cancel()
+			if err != nil {
+				return err
+			}
+			for _, m := range markers.Kvs {
+				id := string(m.Key)[len(prefix):]
+				acked[id] = append(acked[id], g)
+			}
+		}
+	}
+
+	prefixLen := len(cfg.Queue) + 1 // to skip `:`
+	key := cfg.Queue + ":"
+	end := clientv3.GetPrefixRangeEnd(key)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+		resp, err := client.Get(ctx, key, clientv3.WithRange(end), clientv3.WithRev(rev),
+			clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend), clientv3.WithLimit(exportPage))
+		if err != nil {
+			cancel()
+			return err
+		}
+		if len(resp.Kvs) == 0 {
+			cancel()
+			break
+		}
+		first, last := string(resp.Kvs[0].Key)[prefixLen:], string(resp.Kvs[len(resp.Kvs)-1].Key)[prefixLen:]
+		meta, err := loadMeta(ctx, first, last, clientv3.WithRev(rev))
+		cancel()
+		if err != nil {
+			return err
+		}
+		for _, ev := range resp.Kvs {
+			t := Record{Kind: "task", ID: string(ev.Key)[prefixLen:], Value: string(ev.Value), Revision: ev.CreateRevision}
+			setMeta(&t, meta[t.ID])
+			if err = enc.Encode(t); err != nil {
+				return err
+			}
+			for _, g := range acked[t.ID] {
+				if err = enc.Encode(Record{Kind: "acked", Group: g, ID: t.ID}); err != nil {
+					return err
+				}
+			}
+		}
+		if !resp.More {
+			break
+		}
+		key = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+	}
+
+	for _, g := range groups() {
+		prefix := deadPrefix(g)
+		ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+		resp, err := client.Txn(ctx).Then(
+			clientv3.OpGet(prefix, clientv3.WithPrefix(), clientv3.WithRev(rev)),
+			clientv3.OpGet(deadMetaPrefix(g), clientv3.WithPrefix(), clientv3.WithRev(rev))).Commit()
+		cancel()
+		if err != nil {
+			return err
+		}
+		meta := make(map[string]*taskMeta)
+		for _, ev := range resp.Responses[1].GetResponseRange().Kvs {
+			var m taskMeta
+			if err = json.Unmarshal(ev.Value, &m); err != nil {
+				return err
+			}
+			meta[string(ev.Key)[len(deadMetaPrefix(g)):]] = &m
+		}
+		for _, ev := range resp.Responses[0].GetResponseRange().Kvs {
+			d := Record{Kind: "dead", Group: g, ID: string(ev.Key)[len(prefix):], Value: string(ev.Value)}
+			setMeta(&d, meta[d.ID])
+			if err = enc.Encode(d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setMeta copies task metadata to record
+func setMeta(rec *Record, m *taskMeta) {
+	if m == nil {
+		return
+	}
+	rec.Parents = m.Parents
+	rec.Trace = m.Trace
+	rec.MessageGroup = m.Group
+	rec.Require = m.Require
+	rec.MaxTime, rec.MaxRenew = m.MaxTime, m.MaxRenew
+}
+
+// readRecords parses export file, file must be complete
+func readRecords(r *http.Request) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("bad record %d: %v", len(records)+1, err)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(records) < 2 || records[0].Kind != "header" || records[len(records)-1].Kind != "end" {
+		return nil, fmt.Errorf("incomplete export file")
+	}
+	return records[1 : len(records)-1], nil
+}
+
+func knownGroup(group string) bool {
+	for _, g := range groups() {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// importBatch is ops of one import txn with conditions, tasks and bytes for capacity plan.
+// keys are created by batch and must not exist, state put over empty or same state only
+type importBatch struct {
+	ops   []clientv3.Op
+	cmps  []clientv3.Cmp
+	keys  []string
+	state *string
+	tasks int
+	bytes int
+}
+
+// importOps converts records to etcd ops, ids mapped to new ones if regenerate set.
+// task acked by group if it is up to group cursor or has acked record
+func importOps(records []Record, regenerate bool) ([]importBatch, *ImportResult, error) {
+	ids := make(map[string]string)
+	cursors := make(map[string]int64)
+	acked := make(map[string][]string)
+	for _, rec := range records {
+		switch rec.Kind {
+		case "task", "dead":
+			if regenerate {
+				ids[rec.ID] = newTaskID()
+			}
+		case "cursor", "acked":
+			if rec.Group == "" || !knownGroup(rec.Group) {
+				return nil, nil, fmt.Errorf("unknown group %v", rec.Group)
+			}
+			if rec.Kind == "cursor" {
+				cursors[rec.Group] = rec.Revision
+			} else {
+				acked[rec.ID] = append(acked[rec.ID], rec.Group)
+			}
+		}
+	}
+	newID := func(id string) string {
+		if n, ok := ids[id]; ok {
+			return n
+		}
+		return id
+	}
+	newMetaOf := func(rec Record) *taskMeta {
+		var parents []string
+		for _, p := range rec.Parents {
+			parents = append(parents, newID(p))
+		}
+		return newMeta(parents, rec.Trace, rec.MessageGroup, rec.Require, rec.MaxTime, rec.MaxRenew)
+	}
+
+	result := &ImportResult{}
+	var batches []importBatch
+	var b importBatch
+	for _, rec := range records {
+		var recOps []clientv3.Op
+		var key string
+		switch rec.Kind {
+		case "state":
+			recOps = []clientv3.Op{clientv3.OpPut(stateKey(), rec.Value)}
+			result.State = true
+		case "task":
+			var err error
+			if recOps, err = putTaskOps(newID(rec.ID), rec.Value, newMetaOf(rec)); err != nil {
+				return nil, nil, err
+			}
+			key = cfg.Queue + ":" + newID(rec.ID)
+			by := acked[rec.ID]
+			for g, cursor := range cursors {
+				if rec.Revision > 0 && rec.Revision <= cursor {
+					by = append(by, g)
+				}
+			}
+			for _, g := range by {
+				recOps = append(recOps, clientv3.OpPut(groupAckedPrefix(g)+newID(rec.ID), ackedInTxn))
+			}
+			result.Tasks++
+			result.Acked += len(by)
+		case "cursor", "acked":
+			continue
+		case "dead":
+			if !knownGroup(rec.Group) {
+				return nil, nil, fmt.Errorf("unknown group %v", rec.Group)
+			}
+			key = deadPrefix(rec.Group) + newID(rec.ID)
+			recOps = []clientv3.Op{clientv3.OpPut(key, rec.Value)}
+			if meta := newMetaOf(rec); meta != nil {
+				value, err := json.Marshal(meta)
+				if err != nil {
+					return nil, nil, err
+				}
+				recOps = append(recOps, clientv3.OpPut(deadMetaPrefix(rec.Group)+newID(rec.ID), string(value)))
+			}
+			result.Dead++
+		default:
+			return nil, nil, fmt.Errorf("unknown record kind %v", rec.Kind)
+		}
+		// one cmp left for capacity plan, one for state
+		if len(b.ops)+len(recOps)+len(b.cmps)+3 > maxTxnOps {
+			batches = append(batches, b)
+			b = importBatch{}
+		}
+		b.ops = append(b.ops, recOps...)
+		if key != "" {
+			b.cmps = append(b.cmps, clientv3.Compare(clientv3.CreateRevision(key), "=", 0))
+			b.keys = append(b.keys, key)
+		}
+		if rec.Kind == "state" {
+			state := rec.Value
+			b.state = &state
+		}
+		if rec.Kind == "task" {
+			b.tasks++
+			b.bytes += len(rec.Value)
+		}
+	}
+	if len(b.ops) > 0 {
+		batches = append(batches, b)
+	}
+	return batches, result, nil
+}
+
+// checkImport returns keys of batches already in queue, state if it differs from imported one.
+// state batch gets condition on state read
+func checkImport(ctx context.Context, batches []importBatch) ([]string, error) {
+	var conflicts []string
+	for i := range batches {
+		b := &batches[i]
+		ops := make([]clientv3.Op, 0, len(b.keys)+1)
+		for _, k := range b.keys {
+			ops = append(ops, clientv3.OpGet(k, clientv3.WithCountOnly()))
+		}
+		if b.state != nil {
+			ops = append(ops, clientv3.OpGet(stateKey()))
+		}
+		resp, err := client.Txn(ctx).Then(ops...).Commit()
+		if err != nil {
+			return nil, err
+		}
+		for j, k := range b.keys {
+			if resp.Responses[j].GetResponseRange().Count > 0 {
+				conflicts = append(conflicts, k)
+			}
+		}
+		if b.state == nil {
+			continue
+		}
+		state := resp.Responses[len(b.keys)].GetResponseRange().Kvs
+		if len(state) == 0 {
+			b.cmps = append(b.cmps, clientv3.Compare(clientv3.CreateRevision(stateKey()), "=", 0))
+			continue
+		}
+		if v := string(state[0].Value); v != "" && v != *b.state {
+			conflicts = append(conflicts, stateKey())
+		}
+		b.cmps = append(b.cmps, clientv3.Compare(clientv3.ModRevision(stateKey()), "=", state[0].ModRevision))
+	}
+	return conflicts, nil
+}
+
+func importQueue(w http.ResponseWriter, r *http.Request) {
+	f := logger.WithField("method", "/import")
+	if err := noDeadline(w); err != nil {
+		f.Warnf("large import may be cut by server timeout: %v", err)
+	}
+	regenerate := false
+	switch r.URL.Query().Get("ids") {
+	case "", "preserve":
+	case "regenerate":
+		regenerate = true
+	default:
+		f.Error("bad param ids")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	records, err := readRecords(r)
+	if err != nil {
+		f.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	batches, result, err := importOps(records, regenerate)
+	if err != nil {
+		f.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// import refused if any key exists, nothing written
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	conflicts, err := checkImport(ctx, batches)
+	cancel()
+	if err != nil {
+		f.Errorf("fail to check import: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(conflicts) > 0 {
+		f.WithField("conflicts", len(conflicts)).Error("import refused, keys already exist")
+		jresp, _ := json.Marshal(ImportResult{Conflicts: conflicts})
+		w.WriteHeader(http.StatusConflict)
+		w.Write(jresp)
+		return
+	}
+
+	// batches applied one by one, on conflict with concurrent put earlier batches stay imported.
+	// tasks not spilled or dropped to make room, import fails if queue full
+	for i, b := range batches {
+		ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+		code, err := putPlanned(ctx, b.tasks, b.bytes, "", func(plan *putPlan) (int, error) {
+			if plan.spilled || plan.dropped > 0 {
+				return http.StatusInsufficientStorage, fmt.Errorf("batch %d of %d: queue full", i+1, len(batches))
+			}
+			resp, err := client.Txn(ctx).If(append(b.cmps, plan.cmps...)...).Then(b.ops...).Commit()
+			if err != nil {
+				return http.StatusInternalServerError, fmt.Errorf("fail to import: %v", err)
+			}
+			if resp.Succeeded {
+				plan.added(b.tasks, log.Fields{})
+				return http.StatusOK, nil
+			}
+			// own conditions checked alone to tell conflict from new task in queue
+			if len(plan.cmps) > 0 {
+				if resp, err = client.Txn(ctx).If(b.cmps...).Commit(); err != nil {
+					return http.StatusInternalServerError, fmt.Errorf("fail to import: %v", err)
+				}
+				if resp.Succeeded {
+					return 0, errQueueChanged
+				}
+			}
+			return http.StatusConflict, fmt.Errorf("batch %d of %d: key created or state changed", i+1, len(batches))
+		})
+		cancel()
+		if err != nil {
+			f.Error(err)
+			w.WriteHeader(code)
+			return
+		}
+	}
+	logger.WithFields(log.Fields{"tasks": result.Tasks, "acked": result.Acked}).Info("queue imported")
+
+	// cursors pass tasks imported as acked, cleanup can be done by next ack if we fail here
+	if result.Acked > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+		for _, g := range groups() {
+			if err = advanceCursor(ctx, g); err != nil {
+				f.Warnf("fail to advance cursor: %v", err)
+			}
+		}
+		if err = removeConsumed(ctx); err != nil {
+			f.Warnf("fail to remove consumed tasks: %v", err)
+		}
+		cancel()
+	}
+
+	jresp, err := json.Marshal(result)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(jresp)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportOps(t *testing.T) {
+	assert := assert.New(t)
+
+	records := []Record{
+		{Kind: "state", Value: "A"},
+		{Kind: "task", ID: "1", Value: "parent"},
+		{Kind: "task", ID: "2", Value: "child", Parents: []string{"1", "0"}},
+	}
+	batches, result, err := importOps(records, false)
+	assert.Nil(err)
+	assert.Equal(&ImportResult{State: true, Tasks: 2}, result)
+	assert.Len(batches, 1)
+	assert.Len(batches[0].cmps, 2) // state condition added by checkImport
+	assert.Len(batches[0].ops, 4)  // state, 2 tasks, meta for child
+	assert.Equal(2, batches[0].tasks)
+	assert.Equal(len("parent")+len("child"), batches[0].bytes)
+	assert.Equal(cfg.Queue+":1", string(batches[0].ops[1].KeyBytes()))
+
+	// parent ids follow regenerated ids, unknown parent kept
+	batches, _, err = importOps(records, true)
+	assert.Nil(err)
+	parent := strings.TrimPrefix(string(batches[0].ops[1].KeyBytes()), cfg.Queue+":")
+	assert.NotEqual("1", parent)
+	assert.Equal(fmt.Sprintf(`{"Parents":["%s","0"]}`, parent), string(batches[0].ops[3].ValueBytes()))
+
+	// split to fit txn limits
+	records = nil
+	for i := 0; i < maxTxnOps; i++ {
+		records = append(records, Record{Kind: "task", ID: fmt.Sprint(i), Value: "x"})
+	}
+	batches, _, err = importOps(records, false)
+	assert.Nil(err)
+	assert.Len(batches, 3) // task is op and cmp
+	for _, b := range batches {
+		assert.Less(len(b.ops)+len(b.cmps), maxTxnOps)
+	}
+
+	_, _, err = importOps([]Record{{Kind: "dead", Group: "nope", ID: "1"}}, false)
+	assert.NotNil(err)
+	_, _, err = importOps([]Record{{Kind: "cursor", Revision: 1}}, false)
+	assert.NotNil(err)
+}
+
+func TestImportOpsAcked(t *testing.T) {
+	assert := assert.New(t)
+	saved := cfg
+	cfg = config{Queue: "test", Groups: []string{"a", "b"}}
+	defer func() { cfg = saved }()
+
+	records := []Record{
+		{Kind: "cursor", Group: "a", Revision: 10},
+		{Kind: "cursor", Group: "b", Revision: 5},
+		{Kind: "task", ID: "1", Value: "x", Revision: 8},
+		{Kind: "acked", Group: "b", ID: "1"},
+		{Kind: "task", ID: "2", Value: "y", Revision: 12},
+	}
+	batches, result, err := importOps(records, true)
+	assert.Nil(err)
+	assert.Equal(&ImportResult{Tasks: 2, Acked: 2}, result)
+	// task up to cursor of a and marked by b
+	id := strings.TrimPrefix(string(batches[0].ops[0].KeyBytes()), cfg.Queue+":")
+	var markers []string
+	for _, op := range batches[0].ops[1:] {
+		markers = append(markers, string(op.KeyBytes())+"="+string(op.ValueBytes()))
+	}
+	assert.ElementsMatch([]string{groupAckedPrefix("a") + id + "=" + ackedInTxn, groupAckedPrefix("b") + id + "=" + ackedInTxn,
+		string(batches[0].ops[3].KeyBytes()) + "=y"}, markers)
+}
+
+// export returns export of test queue
+func export(t *testing.T) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	exportQueue(w, httptest.NewRequest("GET", "/api/v1/export", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("export: %v", w.Code)
+	}
+	return w.Body.String()
+}
+
+// load imports file into test queue
+func load(body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	importQueue(w, httptest.NewRequest("POST", "/api/v1/import", strings.NewReader(body)))
+	return w
+}
+
+func TestExportImportTopic(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t, "a", "b")
+	useSettings(t, settings{LogLevel: "info", Limit: 100, MaxAttempts: 1})
+	data, maxTime := "0", int64(60)
+	_, task, err := putTask(&data, nil, nil, nil, nil, nil, nil, &maxTime, nil, nil, nil)
+	assert.Nil(err)
+	id0, id1, id2, id3 := task.ID, addTask(t, "1"), addTask(t, "2"), addTask(t, "3")
+	for range []string{id0, id1, id2} {
+		ack(t, "w", "a", lease(t, "w", "a").ID)
+	}
+	kill(t, "w", "b", lease(t, "w", "b").ID)
+	assert.Equal(id1, lease(t, "w1", "b").ID)
+	assert.Equal(id2, lease(t, "w2", "b").ID)
+	ack(t, "w3", "b", lease(t, "w3", "b").ID)
+	body := export(t)
+	assert.Contains(body, `"Kind":"cursor","Group":"a"`)
+	assert.Contains(body, `"Kind":"acked","Group":"b","ID":"`+id3+`"`)
+	assert.Contains(body, `"Kind":"dead","Group":"b","ID":"`+id0+`","Value":"0","MaxTime":60`)
+
+	// cursor and markers restored in terms of tasks
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	removeQueue(t)
+	assert.Nil(createQueue(ctx))
+	w := load(body)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(`{"State":true,"Tasks":3,"Acked":3,"Dead":1}`, w.Body.String())
+	assert.Equal([]string{id1, id2, id3}, taskKeys(t))
+	assert.Equal(id3, lease(t, "w", "a").ID)
+	assert.Equal(id1, lease(t, "w1", "b").ID)
+	assert.Equal(id2, lease(t, "w2", "b").ID)
+	timeout, w3, b := int64(10), "w3", "b"
+	code, _, err := getTask(&w3, &timeout, &b, nil, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusNoContent, code)
+	assert.Equal(int64(1), keyCount(t, deadMetaPrefix("b")+id0))
+
+	// nothing written if any key exists
+	_, err = client.Delete(ctx, cfg.Queue+":"+id3)
+	assert.Nil(err)
+	w = load(body)
+	assert.Equal(http.StatusConflict, w.Code)
+	assert.Contains(w.Body.String(), cfg.Queue+":"+id2)
+	assert.NotContains(w.Body.String(), cfg.Queue+":"+id3)
+	assert.Equal([]string{id1, id2}, taskKeys(t))
+}
+
+func TestImportState(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	data, old, state := "x", "", "A"
+	_, _, err := putTask(&data, &old, &state, nil, nil, nil, nil, nil, nil, nil, nil)
+	assert.Nil(err)
+	body := export(t)
+	removeQueue(t)
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	assert.Nil(createQueue(ctx))
+
+	// state is not overwritten
+	_, err = client.Put(ctx, stateKey(), "B")
+	assert.Nil(err)
+	w := load(body)
+	assert.Equal(http.StatusConflict, w.Code)
+	assert.JSONEq(`{"State":false,"Tasks":0,"Acked":0,"Dead":0,"Conflicts":["`+stateKey()+`"]}`, w.Body.String())
+	assert.Empty(taskKeys(t))
+
+	// same state imported
+	_, err = client.Put(ctx, stateKey(), "A")
+	assert.Nil(err)
+	w = load(body)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Len(taskKeys(t), 1)
+}
+
+func TestNoDeadline(t *testing.T) {
+	assert := assert.New(t)
+	// slow export behind access log outlives server write timeout
+	srv := httptest.NewUnstartedServer(accessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(noDeadline(w))
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("end"))
+	})))
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if !assert.Nil(err) {
+		return
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(err)
+	assert.Equal("end", string(body))
+
+	assert.NotNil(noDeadline(httptest.NewRecorder()))
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// capacity limits for put: queue size read at revision, put txn fails if task added
+// after that and put retried. every path adding tasks goes through putPlanned:
+// put, follow-ups of ack, cron, requeue of dead task and import.
+// cost of limits: max-pending alone is a count, max-bytes reads all values in queue.
+// prefix compare serializes puts of all replicas, concurrent puts get 409 after lockAttempts
+
+// overflow policies
+const (
+	overflowReject = "reject"      // 429 if max-pending reached, 507 if max-bytes
+	overflowDrop   = "drop-oldest" // delete oldest not leased tasks to make room
+	overflowSpill  = "spill"       // put to spill-queue, its limits not checked
+)
+
+// retry hint if queue full, we don't know when consumers catch up
+const capacityRetry = 5 * time.Second
+
+// puts of replica serialized if limited, so only other replicas make put txn fail
+var putMu sync.Mutex
+
+// limited returns true if put checks capacity
+func limited() bool {
+	s := current()
+	return s.MaxPending > 0 || s.MaxBytes > 0
+}
+
+// putPlan is queue to put task in, with conditions and ops for put txn
+type putPlan struct {
+	queue   string
+	spilled bool
+	dropped int
+	cmps    []clientv3.Cmp
+	ops     []clientv3.Op
+}
+
+// dropped tasks per put, rest of txn left for put ops
+const maxDropOps = maxTxnOps / 2
+
+// dropTaskOps returns ops to delete task with attributes, of all groups in topic mode
+func dropTaskOps(id string) []clientv3.Op {
+	ops := []clientv3.Op{
+		clientv3.OpDelete(cfg.Queue + ":" + id),
+		clientv3.OpDelete(metaKey(id)),
+	}
+	for _, g := range groups() {
+		ops = append(ops, clientv3.OpDelete(attemptsKey(g, id)), clientv3.OpDelete(startedKey(g, id)),
+			clientv3.OpDelete(progressPrefix(g)+id))
+		if g != "" {
+			ops = append(ops, clientv3.OpDelete(groupAckedPrefix(g)+id))
+		}
+	}
+	return ops
+}
+
+// planPut checks capacity limits for `n` tasks with `size` bytes of data,
+// `freed` is task deleted by same txn, not counted
+func planPut(ctx context.Context, n int, size int, freed string) (int, *putPlan, error) {
+	s := current()
+	plan := &putPlan{queue: cfg.Queue}
+	if s.MaxPending == 0 && s.MaxBytes == 0 {
+		return http.StatusOK, plan, nil
+	}
+
+	prefix := cfg.Queue + ":"
+	opts := []clientv3.OpOption{clientv3.WithPrefix()}
+	if s.MaxBytes == 0 {
+		opts = append(opts, clientv3.WithCountOnly())
+	}
+	resp, err := client.Get(ctx, prefix, opts...)
+	if err != nil {
+		return http.StatusInternalServerError, nil, fmt.Errorf("fail to get queue size")
+	}
+	rev := resp.Header.Revision
+	count, bytes := resp.Count, int64(0)
+	for _, ev := range resp.Kvs {
+		if string(ev.Key) != prefix+freed {
+			bytes += int64(len(ev.Value))
+		}
+	}
+	if freed != "" {
+		count--
+	}
+	full := func() bool {
+		return s.MaxPending > 0 && count+int64(n) > s.MaxPending || s.MaxBytes > 0 && bytes+int64(size) > s.MaxBytes
+	}
+	// new task by someone else after we counted
+	plan.cmps = append(plan.cmps, clientv3.Compare(clientv3.ModRevision(prefix), "<", rev+1).WithPrefix())
+	if !full() {
+		return http.StatusOK, plan, nil
+	}
+
+	switch {
+	case s.Overflow == overflowSpill:
+		plan.queue = s.SpillQueue
+		plan.spilled = true
+		plan.cmps = nil
+		return http.StatusOK, plan, nil
+	case s.Overflow == overflowDrop && (s.MaxBytes == 0 || int64(size) <= s.MaxBytes):
+		// task leased by any group is not dropped
+		active := make(map[string]struct{})
+		for _, g := range groups() {
+			leased, err := client.Get(ctx, activePrefix(g), clientv3.WithPrefix(), clientv3.WithKeysOnly(), clientv3.WithRev(rev))
+			if err != nil {
+				return http.StatusInternalServerError, nil, fmt.Errorf("fail to get active tasks")
+			}
+			for _, ev := range leased.Kvs {
+				active[string(ev.Key)[len(activePrefix(g)):]] = struct{}{}
+			}
+		}
+		kvs := resp.Kvs
+		if s.MaxBytes == 0 {
+			// oldest tasks enough to make room
+			oldest, err := client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly(), clientv3.WithRev(rev),
+				clientv3.WithLimit(count-s.MaxPending+int64(n)+int64(len(active))))
+			if err != nil {
+				return http.StatusInternalServerError, nil, fmt.Errorf("fail to get tasks")
+			}
+			kvs = oldest.Kvs
+		}
+		for _, ev := range kvs {
+			if !full() {
+				break
+			}
+			id := string(ev.Key)[len(prefix):]
+			if _, ok := active[id]; ok {
+				continue
+			}
+			ops := dropTaskOps(id)
+			if len(plan.ops)+len(ops) > maxDropOps {
+				break
+			}
+			for _, g := range groups() {
+				// leased after we read
+				plan.cmps = append(plan.cmps, clientv3.Compare(clientv3.CreateRevision(activePrefix(g)+id), "=", 0))
+			}
+			plan.ops = append(plan.ops, ops...)
+			plan.dropped++
+			count--
+			bytes -= int64(len(ev.Value))
+		}
+		if !full() {
+			return http.StatusOK, plan, nil
+		}
+	}
+	if s.MaxPending > 0 && count+int64(n) > s.MaxPending {
+		return http.StatusTooManyRequests, nil, retryError{fmt.Errorf("max pending tasks reached"), capacityRetry}
+	}
+	return http.StatusInsufficientStorage, nil, fmt.Errorf("max bytes reached")
+}
+
+// errQueueChanged is returned by put txn failed on plan cmps only, put planned again
+var errQueueChanged = errors.New("queue changed")
+
+// putPlanned runs put txn of `n` tasks with `size` bytes within capacity limits.
+// txn built for every plan, retried while it returns errQueueChanged
+func putPlanned(ctx context.Context, n int, size int, freed string, txn func(plan *putPlan) (int, error)) (int, error) {
+	if n == 0 {
+		return txn(&putPlan{queue: cfg.Queue})
+	}
+	if limited() {
+		putMu.Lock()
+		defer putMu.Unlock()
+	}
+	for i := 0; i < lockAttempts; i++ {
+		code, plan, err := planPut(ctx, n, size, freed)
+		if err != nil {
+			return code, err
+		}
+		if code, err = txn(plan); err != errQueueChanged {
+			return code, err
+		}
+	}
+	return http.StatusConflict, fmt.Errorf("fail to add task, queue changed")
+}
+
+// added counts `n` tasks put by plan, with spilled and dropped ones
+func (p *putPlan) added(n int, f log.Fields) {
+	counter("put").Add(n)
+	if p.spilled {
+		counter("spilled").Add(n)
+		f["queue"] = p.queue
+	}
+	if p.dropped > 0 {
+		counter("dropped").Add(p.dropped)
+		f["dropped"] = p.dropped
+	}
+}
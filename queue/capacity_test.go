@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func putData(data string) (int, *KV) {
+	code, task, _ := putTask(&data, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	return code, task
+}
+
+func TestCapacityReject(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	useSettings(t, settings{LogLevel: "info", Limit: 100, MaxPending: 2})
+	addTask(t, "1")
+	addTask(t, "2")
+	code, _ := putData("3")
+	assert.Equal(http.StatusTooManyRequests, code)
+
+	useSettings(t, settings{LogLevel: "info", Limit: 100, MaxBytes: 3})
+	code, _ = putData("3")
+	assert.Equal(http.StatusOK, code)
+	code, _ = putData("4")
+	assert.Equal(http.StatusInsufficientStorage, code)
+}
+
+func TestCapacityDrop(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	useSettings(t, settings{LogLevel: "info", Limit: 100, MaxPending: 2, Overflow: overflowDrop})
+	id1, _ := addTask(t, "1"), addTask(t, "2")
+	lease(t, "w", "")
+
+	// leased task kept, next oldest dropped
+	code, task := putData("3")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal([]string{id1, task.ID}, taskKeys(t))
+
+	// nothing to drop if all leased
+	lease(t, "w2", "")
+	code, _ = putData("4")
+	assert.Equal(http.StatusTooManyRequests, code)
+}
+
+func TestCapacityDropTopic(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t, "a", "b")
+	useSettings(t, settings{LogLevel: "info", Limit: 100, MaxPending: 2, Overflow: overflowDrop})
+	id1, _ := addTask(t, "1"), addTask(t, "2")
+
+	// leased by one group only, still not dropped
+	assert.Equal(id1, lease(t, "w", "b").ID)
+	code, task := putData("3")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal([]string{id1, task.ID}, taskKeys(t))
+
+	code, plan, err := planPut(context.Background(), 1, 1, "")
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(1, plan.dropped)
+	// dropped task not leased by any group
+	assert.Len(plan.cmps, 3)
+}
+
+func TestCapacitySpill(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	spill := cfg.Queue + "-spill"
+	useSettings(t, settings{LogLevel: "info", Limit: 100, MaxPending: 1, Overflow: overflowSpill, SpillQueue: spill})
+	t.Cleanup(func() {
+		client.Delete(context.Background(), spill+":", clientv3.WithPrefix())
+	})
+	id := addTask(t, "1")
+	code, task := putData("2")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(spill, task.Queue)
+	assert.Equal([]string{id}, taskKeys(t))
+}
+
+func TestCapacityDropLimit(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	useSettings(t, settings{LogLevel: "info", Limit: 100, MaxBytes: 100, Overflow: overflowDrop})
+	for i := 0; i < 100; i++ {
+		addTask(t, "x")
+	}
+	// room for big task needs too many deletes for one txn
+	code, _, err := planPut(context.Background(), 1, 100, "")
+	assert.Equal(http.StatusInsufficientStorage, code)
+	assert.NotNil(err)
+	code, plan, err := planPut(context.Background(), 1, 10, "")
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(10, plan.dropped)
+
+	code, task := putData(strings.Repeat("y", 10))
+	assert.Equal(http.StatusOK, code)
+	assert.Len(taskKeys(t), 91)
+	assert.Equal(task.ID, taskKeys(t)[90])
+}
+
+func TestCapacityAckNext(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	useSettings(t, settings{LogLevel: "info", Limit: 100, MaxPending: 2})
+	addTask(t, "1")
+	addTask(t, "2")
+
+	// acked task makes room for follow-up
+	client, task := "w", lease(t, "w", "")
+	code, next, err := ackTask(&client, &task.ID, nil, &[]string{"a"}, nil, nil, nil, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Len(*next, 1)
+
+	// too many follow-ups, task not acked
+	task = lease(t, "w", "")
+	code, _, err = ackTask(&client, &task.ID, nil, &[]string{"b", "c"}, nil, nil, nil, nil)
+	assert.NotNil(err)
+	assert.Equal(http.StatusTooManyRequests, code)
+	assert.Contains(taskKeys(t), task.ID)
+	ack(t, "w", "", task.ID)
+}
+
+func TestCapacityAckNextTopic(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t, "a")
+	useSettings(t, settings{LogLevel: "info", Limit: 100, MaxPending: 1})
+	// acked task stays in queue until all groups ack it
+	id := addTask(t, "1")
+	client, group := "w", "a"
+	assert.Equal(id, lease(t, "w", "a").ID)
+	code, _, err := ackTask(&client, &id, &group, &[]string{"next"}, nil, nil, nil, nil)
+	assert.NotNil(err)
+	assert.Equal(http.StatusTooManyRequests, code)
+	assert.Equal([]string{id}, taskKeys(t))
+}
+
+func TestCapacityRequeue(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	useSettings(t, settings{LogLevel: "info", Limit: 100, MaxPending: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	_, err := client.Put(ctx, deadPrefix("")+"1", "dead")
+	assert.Nil(err)
+	addTask(t, "1")
+
+	id := "1"
+	code, _, err := requeueDead(&id, nil)
+	assert.NotNil(err)
+	assert.Equal(http.StatusTooManyRequests, code)
+	resp, err := client.Get(ctx, deadPrefix("")+"1", clientv3.WithCountOnly())
+	assert.Nil(err)
+	assert.Equal(int64(1), resp.Count)
+}
+
+func TestCapacityCron(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	useSettings(t, settings{LogLevel: "info", Limit: 100, MaxPending: 2})
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	now := time.Now()
+	_, err := client.Put(ctx, cronRunKey("job"), strconv.FormatInt(now.Add(-3*time.Minute).UnixNano(), 10))
+	assert.Nil(err)
+	runKey := func() string {
+		resp, err := client.Get(ctx, cronRunKey("job"))
+		assert.Nil(err)
+		return string(resp.Kvs[0].Value)
+	}
+
+	// runs added while queue has room, rest added by next run of job
+	job := &CronJob{Name: "job", Schedule: "@every 1m", Data: "cron", CatchUp: catchUpAll}
+	assert.NotNil(runJob(ctx, job, now))
+	assert.Len(taskKeys(t), 2)
+	assert.Equal(strconv.FormatInt(now.Add(-time.Minute).UnixNano(), 10), runKey())
+
+	ack(t, "w", "", lease(t, "w", "").ID)
+	assert.Nil(runJob(ctx, job, now))
+	assert.Len(taskKeys(t), 2)
+	assert.Equal(strconv.FormatInt(now.UnixNano(), 10), runKey())
+}
+
+func TestCapacityImport(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	useSettings(t, settings{LogLevel: "info", Limit: 100, MaxPending: 2, Overflow: overflowDrop})
+	id := addTask(t, "old")
+	body := `{"Kind":"header"}
+{"Kind":"task","ID":"1","Value":"a"}
+{"Kind":"task","ID":"2","Value":"b"}
+{"Kind":"end"}
+`
+	// import does not drop tasks to make room
+	w := httptest.NewRecorder()
+	importQueue(w, httptest.NewRequest("POST", "/api/v1/import", strings.NewReader(body)))
+	assert.Equal(http.StatusInsufficientStorage, w.Code)
+	assert.Equal([]string{id}, taskKeys(t))
+
+	useSettings(t, settings{LogLevel: "info", Limit: 100, MaxPending: 3})
+	w = httptest.NewRecorder()
+	importQueue(w, httptest.NewRequest("POST", "/api/v1/import", strings.NewReader(body)))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Len(taskKeys(t), 3)
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/client/v3"
+	"gopkg.in/yaml.v2"
+)
+
+// config file read at start, settings re-read on SIGHUP.
+// if etcd-config set, settings from etcd key __config:<queue> override file ones,
+// key is watched so all replicas change together
+
+// settings can be changed without restart
+type settings struct {
+	LogLevel string `yaml:"log-level"`
+	Limit    int64  `yaml:"client-limit"`
+
+	Retention time.Duration `yaml:"retention"`
+
+	Rate      float64 `yaml:"rate-limit"`
+	Burst     float64 `yaml:"rate-burst"`
+	MaxActive int     `yaml:"max-active"`
+
+	Cron []CronJob `yaml:"cron"`
+
+	ResultTTL time.Duration `yaml:"result-ttl"`
+
+	MaxAttempts int `yaml:"max-attempts"`
+
+	MaxPending int64  `yaml:"max-pending"`
+	MaxBytes   int64  `yaml:"max-bytes"`
+	Overflow   string `yaml:"overflow"`
+	SpillQueue string `yaml:"spill-queue"`
+
+	DedupWindow time.Duration `yaml:"dedup-window"`
+
+	EventRetention time.Duration `yaml:"event-retention"` // event feed disabled if 0
+	Webhooks       []Webhook     `yaml:"webhooks"`
+}
+
+type config struct {
+	Queue      string   `yaml:"queue"`
+	Etcd       string   `yaml:"etcd"`
+	Addr       string   `yaml:"addr"`
+	GRPCAddr   string   `yaml:"grpc-addr"` // grpc api disabled if empty
+	Groups     []string `yaml:"groups"`
+	EtcdConfig bool     `yaml:"etcd-config"`
+
+	Settings settings `yaml:",inline"`
+}
+
+// settings in use and settings from file, to restore if etcd key deleted
+var live struct {
+	sync.RWMutex
+	current settings
+	file    settings
+}
+
+// current returns settings in use
+func current() settings {
+	live.RLock()
+	defer live.RUnlock()
+	return live.current
+}
+
+func configKey() string {
+	return "__config:" + cfg.Queue
+}
+
+func (s *settings) validate() error {
+	if _, err := log.ParseLevel(s.LogLevel); err != nil {
+		return errors.Wrap(err, "log-level")
+	}
+	for name, negative := range map[string]bool{
+		"client-limit":    s.Limit < 0,
+		"retention":       s.Retention < 0,
+		"rate-limit":      s.Rate < 0,
+		"rate-burst":      s.Burst < 0,
+		"max-active":      s.MaxActive < 0,
+		"result-ttl":      s.ResultTTL < 0,
+		"max-attempts":    s.MaxAttempts < 0,
+		"max-pending":     s.MaxPending < 0,
+		"max-bytes":       s.MaxBytes < 0,
+		"dedup-window":    s.DedupWindow < 0,
+		"event-retention": s.EventRetention < 0,
+	} {
+		if negative {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	switch s.Overflow {
+	case "", overflowReject, overflowDrop:
+	case overflowSpill:
+		if s.SpillQueue == "" || strings.Contains(s.SpillQueue, ":") {
+			return fmt.Errorf("overflow: spill needs spill-queue name without `:`")
+		}
+	default:
+		return fmt.Errorf("overflow: unknown policy %q", s.Overflow)
+	}
+	names := make(map[string]bool)
+	for i := range s.Cron {
+		if err := checkCronJob(&s.Cron[i]); err != nil {
+			return errors.Wrap(err, "cron")
+		}
+		if names[s.Cron[i].Name] {
+			return fmt.Errorf("cron: duplicate job %v", s.Cron[i].Name)
+		}
+		names[s.Cron[i].Name] = true
+	}
+	if len(s.Webhooks) > 0 && s.EventRetention == 0 {
+		return fmt.Errorf("webhooks: needs event-retention")
+	}
+	urls := make(map[string]bool)
+	for i := range s.Webhooks {
+		if err := s.Webhooks[i].validate(); err != nil {
+			return errors.Wrap(err, "webhooks")
+		}
+		if urls[s.Webhooks[i].URL] {
+			return fmt.Errorf("webhooks: duplicate url %v", s.Webhooks[i].URL)
+		}
+		urls[s.Webhooks[i].URL] = true
+	}
+	return nil
+}
+
+func (c *config) validate() error {
+	switch {
+	case c.Queue == "":
+		return errors.New("queue name required")
+	case strings.Contains(c.Queue, ":"):
+		return errors.New("queue name must not contain `:`")
+	case c.Etcd == "":
+		return errors.New("etcd address required")
+	case c.Addr == "":
+		return errors.New("addr required")
+	}
+	seen := make(map[string]bool)
+	for _, g := range c.Groups {
+		if g == "" || strings.Contains(g, ":") {
+			return fmt.Errorf("bad group name %q", g)
+		}
+		if seen[g] {
+			return fmt.Errorf("duplicate group %v", g)
+		}
+		seen[g] = true
+	}
+	return c.Settings.validate()
+}
+
+// loadConfig reads and validates config file, unknown keys are errors
+func loadConfig(filename string) (*config, error) {
+	f, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var c config
+	if err = yaml.UnmarshalStrict(f, &c); err != nil {
+		return nil, errors.Wrapf(err, "bad config %v", filename)
+	}
+	if err = c.validate(); err != nil {
+		return nil, errors.Wrapf(err, "bad config %v", filename)
+	}
+	return &c, nil
+}
+
+// overlay returns base settings with fields set in yaml replaced
+func overlay(base settings, data []byte) (settings, error) {
+	s := base
+	s.Cron = append([]CronJob(nil), base.Cron...)
+	if err := yaml.UnmarshalStrict(data, &s); err != nil {
+		return base, err
+	}
+	if err := s.validate(); err != nil {
+		return base, err
+	}
+	return s, nil
+}
+
+// applySettings makes settings current, cron jobs saved if changed
+func applySettings(s settings, source string) {
+	level, _ := log.ParseLevel(s.LogLevel) // validated
+	logger.SetLevel(level)
+
+	live.Lock()
+	old := live.current
+	live.current = s
+	live.Unlock()
+
+	if !reflect.DeepEqual(old.Cron, s.Cron) && client != nil {
+		if err := addCronJobs(); err != nil {
+			logger.Errorf("fail to save cron jobs: %v", err)
+		}
+	}
+	logger.WithField("source", source).WithField("settings", fmt.Sprintf("%+v", s)).Info("settings applied")
+}
+
+// etcdSettings returns file settings with etcd ones on top, revision of key read returned
+func etcdSettings(file settings) (settings, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	resp, err := client.Get(ctx, configKey())
+	cancel()
+	if err != nil {
+		return file, 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return file, resp.Header.Revision, nil
+	}
+	s, err := overlay(file, resp.Kvs[0].Value)
+	if err != nil {
+		return file, resp.Header.Revision, errors.Wrapf(err, "bad config in etcd %v", configKey())
+	}
+	return s, resp.Header.Revision, nil
+}
+
+// initSettings keeps file settings and applies etcd ones if enabled
+func initSettings(file settings) {
+	live.Lock()
+	live.file = file
+	live.Unlock()
+	if !cfg.EtcdConfig {
+		return
+	}
+	s, rev, err := etcdSettings(file)
+	if err != nil {
+		logger.Error(err)
+	} else if !reflect.DeepEqual(s, file) {
+		applySettings(s, "etcd")
+	}
+	go watchSettings(context.Background(), rev+1)
+}
+
+// config watch restarted after delay doubled on every failure in a row
+var (
+	configRetry    = time.Second
+	configRetryMax = time.Minute
+)
+
+// watchSettings applies settings from etcd key, file settings restored if key deleted.
+// if watch fails or history compacted, key re-read and watched from current revision
+func watchSettings(ctx context.Context, rev int64) {
+	wait := configRetry
+	for {
+		if followSettings(ctx, rev) {
+			wait = configRetry
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		logger.WithField("retry", wait).Warn("config watch stopped, restarting")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		if wait *= 2; wait > configRetryMax {
+			wait = configRetryMax
+		}
+
+		// changes missed while not watching
+		live.RLock()
+		file := live.file
+		live.RUnlock()
+		s, last, err := etcdSettings(file)
+		if last == 0 { // key not read
+			logger.Errorf("fail to re-read config: %v", err)
+			continue
+		}
+		if err != nil {
+			logger.Error(err)
+		} else if !reflect.DeepEqual(s, current()) {
+			applySettings(s, "etcd")
+		}
+		rev = last + 1
+		logger.WithField("revision", rev).Info("config watch restarted")
+	}
+}
+
+// followSettings applies changes of settings key until watch fails,
+// returns true if some changes were received
+func followSettings(ctx context.Context, rev int64) bool {
+	received := false
+	for wr := range client.Watch(ctx, configKey(), clientv3.WithRev(rev)) {
+		if err := wr.Err(); err != nil {
+			logger.Errorf("config watch failed: %v", err)
+			return received
+		}
+		received = true
+		for _, ev := range wr.Events {
+			live.RLock()
+			file := live.file
+			live.RUnlock()
+			if ev.Type == clientv3.EventTypeDelete {
+				applySettings(file, "file")
+				continue
+			}
+			s, err := overlay(file, ev.Kv.Value)
+			if err != nil {
+				logger.Errorf("bad config in etcd %v, not applied: %v", configKey(), err)
+				continue
+			}
+			applySettings(s, "etcd")
+		}
+	}
+	return received
+}
+
+// reloadLoop re-reads config file on SIGHUP, bad config is not applied
+func reloadLoop(filename string) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		c, err := loadConfig(filename)
+		if err != nil {
+			logger.Errorf("config not reloaded: %v", err)
+			continue
+		}
+		if c.Queue != cfg.Queue || c.Etcd != cfg.Etcd || c.Addr != cfg.Addr || c.GRPCAddr != cfg.GRPCAddr ||
+			!reflect.DeepEqual(c.Groups, cfg.Groups) || c.EtcdConfig != cfg.EtcdConfig {
+			logger.Warn("queue, etcd, addr, grpc-addr, groups and etcd-config changes need restart, ignored")
+		}
+		live.Lock()
+		live.file = c.Settings
+		live.Unlock()
+
+		s := c.Settings
+		if cfg.EtcdConfig {
+			if s, _, err = etcdSettings(c.Settings); err != nil {
+				logger.Error(err)
+			}
+		}
+		applySettings(s, filename)
+	}
+}
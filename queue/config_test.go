@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigValidate(t *testing.T) {
+	assert := assert.New(t)
+	c := config{Queue: "q", Etcd: "localhost:2379", Addr: ":2080", Settings: settings{LogLevel: "info"}}
+	assert.Nil(c.validate())
+
+	bad := c
+	bad.Queue = "a:b"
+	assert.NotNil(bad.validate())
+	bad = c
+	bad.Groups = []string{"a", "a"}
+	assert.EqualError(bad.validate(), "duplicate group a")
+	bad = c
+	bad.Settings.LogLevel = "loud"
+	assert.NotNil(bad.validate())
+	bad = c
+	bad.Settings.Rate = -1
+	assert.EqualError(bad.validate(), "rate-limit must not be negative")
+	bad = c
+	bad.Settings.Cron = []CronJob{{Name: "a", Schedule: "@every 1m"}, {Name: "a", Schedule: "@daily"}}
+	assert.EqualError(bad.validate(), "cron: duplicate job a")
+	bad = c
+	bad.Settings.Overflow = "drop"
+	assert.EqualError(bad.validate(), `overflow: unknown policy "drop"`)
+	bad.Settings.Overflow = overflowSpill
+	assert.NotNil(bad.validate()) // no spill-queue
+	bad.Settings.SpillQueue = "q-spill"
+	assert.Nil(bad.validate())
+	bad = c
+	bad.Settings.Webhooks = []Webhook{{URL: "http://localhost:8000/hook"}}
+	assert.EqualError(bad.validate(), "webhooks: needs event-retention")
+	bad.Settings.EventRetention = time.Hour
+	assert.Nil(bad.validate())
+	bad.Settings.Webhooks[0].Events = []string{"acked", "gone"}
+	assert.NotNil(bad.validate())
+	bad.Settings.Webhooks = []Webhook{{URL: "localhost:8000"}}
+	assert.NotNil(bad.validate())
+}
+
+func TestOverlay(t *testing.T) {
+	assert := assert.New(t)
+	base := settings{LogLevel: "info", Rate: 10, Cron: []CronJob{{Name: "a", Schedule: "@daily"}}}
+
+	s, err := overlay(base, []byte("rate-limit: 5\nresult-ttl: 1h\n"))
+	assert.Nil(err)
+	assert.Equal(5.0, s.Rate)
+	assert.Equal(time.Hour, s.ResultTTL)
+	assert.Equal("info", s.LogLevel)
+	assert.Equal("once", s.Cron[0].CatchUp)
+	assert.Equal("", base.Cron[0].CatchUp) // base not changed
+
+	_, err = overlay(base, []byte("queue: other\n"))
+	assert.NotNil(err) // only settings allowed
+	_, err = overlay(base, []byte("max-active: -1\n"))
+	assert.NotNil(err)
+}
+
+// eventually waits for condition checked every 10ms
+func eventually(cond func() bool) bool {
+	for i := 0; i < 300; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestWatchSettingsCompacted(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	file := settings{LogLevel: "info", Limit: 100}
+	live.Lock()
+	saved := live.file
+	live.file = file
+	live.Unlock()
+	savedRetry := configRetry
+	configRetry = 10 * time.Millisecond
+	t.Cleanup(func() {
+		live.Lock()
+		live.file = saved
+		live.Unlock()
+		configRetry = savedRetry
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	first, err := client.Put(ctx, configKey(), "rate-limit: 5")
+	assert.Nil(err)
+	last, err := client.Put(ctx, configKey(), "rate-limit: 7")
+	assert.Nil(err)
+	_, err = client.Compact(ctx, last.Header.Revision)
+	assert.Nil(err)
+
+	// watch from compacted revision restarted from current settings
+	wctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watchSettings(wctx, first.Header.Revision)
+		close(done)
+	}()
+	defer func() {
+		stop()
+		<-done
+	}()
+	assert.True(eventually(func() bool { return current().Rate == 7 }))
+
+	// changes after restart applied
+	_, err = client.Put(ctx, configKey(), "rate-limit: 9")
+	assert.Nil(err)
+	assert.True(eventually(func() bool { return current().Rate == 9 }))
+	_, err = client.Delete(ctx, configKey())
+	assert.Nil(err)
+	assert.True(eventually(func() bool { return reflect.DeepEqual(current(), file) }))
+}
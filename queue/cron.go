@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jasonlvhit/gocron"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// recurring tasks: leader checks schedules every second and adds tasks,
+// last run time updated in same txn, so run added once even if leader changed
+
+const (
+	catchUpSkip = "skip" // drop missed runs
+	catchUpOnce = "once" // add one task for all missed runs
+	catchUpAll  = "all"  // add task for every missed run
+)
+
+// max tasks to add for missed runs
+const maxCatchUp = 100
+
+// run with `skip` policy added only if not late more than this
+const cronGrace = time.Minute
+
+// CronJob is a task added to queue by schedule, with attributes of put
+type CronJob struct {
+	Name     string `yaml:"name"`
+	Schedule string `yaml:"schedule"` // `min hour day month weekday` or `@every 1h`
+	Data     string `yaml:"data"`
+	CatchUp  string `yaml:"catch-up"` // skip, once or all
+
+	MessageGroup string   `yaml:"message-group" json:",omitempty"`
+	Require      []string `yaml:"require" json:",omitempty"`
+	MaxTime      int64    `yaml:"max-time" json:",omitempty"`
+	MaxRenew     int64    `yaml:"max-renew" json:",omitempty"`
+}
+
+func cronPrefix() string {
+	return "__cron:" + cfg.Queue + ":"
+}
+
+func cronRunKey(name string) string {
+	return "__cronrun:" + cfg.Queue + ":" + name
+}
+
+// schedule is parsed cron spec. gocron runs only intervals and daily `At` times,
+// so it just ticks runCron and cron specs parsed here
+type schedule struct {
+	every                         time.Duration
+	minute, hour, dom, month, dow uint64
+	anyDom, anyDow                bool
+}
+
+var scheduleAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+func parseSchedule(spec string) (*schedule, error) {
+	if strings.HasPrefix(spec, "@every ") {
+		every, err := time.ParseDuration(strings.TrimPrefix(spec, "@every "))
+		if err != nil || every < time.Second {
+			return nil, fmt.Errorf("bad interval in %q", spec)
+		}
+		return &schedule{every: every}, nil
+	}
+	if alias, ok := scheduleAliases[spec]; ok {
+		spec = alias
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields in %q", spec)
+	}
+
+	var s schedule
+	var err error
+	if s.minute, err = parseField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 // 7 is sunday too
+	}
+	s.anyDom = fields[2] == "*"
+	s.anyDow = fields[4] == "*"
+	return &s, nil
+}
+
+// parseField parses `*`, `*/n`, `a`, `a-b`, `a-b/n` or comma separated list of them to bitset
+func parseField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("bad step in %q", field)
+			}
+			part = part[:i]
+		}
+		from, to := min, max
+		if part != "*" {
+			var err error
+			bounds := strings.SplitN(part, "-", 2)
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("bad value in %q", field)
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("bad value in %q", field)
+				}
+			} else if step > 1 {
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("value out of range in %q", field)
+		}
+		for i := from; i <= to; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func (s *schedule) dayMatch(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.anyDom || s.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// wallClock returns wall clock of t as UTC time, to match schedule without DST shifts
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+// next returns first run after t, zero time if no such run in 5 years.
+// schedule matched on wall clock of t location: run in hour skipped by DST
+// done at the jump, run in hour repeated by DST done once
+func (s *schedule) next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every)
+	}
+	loc := t.Location()
+	w := wallClock(t).Add(time.Minute)
+	limit := w.AddDate(5, 0, 0)
+	for w.Before(limit) {
+		if s.month&(1<<uint(w.Month())) == 0 {
+			w = time.Date(w.Year(), w.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.dayMatch(w) {
+			w = time.Date(w.Year(), w.Month(), w.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.hour&(1<<uint(w.Hour())) == 0 {
+			w = time.Date(w.Year(), w.Month(), w.Day(), w.Hour()+1, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.minute&(1<<uint(w.Minute())) == 0 {
+			w = w.Add(time.Minute)
+			continue
+		}
+		r := time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), 0, 0, loc)
+		if !wallClock(r).Equal(w) {
+			// no such wall time, first minute after the jump
+			for !wallClock(r).After(w) {
+				r = r.Add(time.Minute)
+			}
+		}
+		// repeated wall time is before t
+		if r.After(t) {
+			return r
+		}
+		w = w.Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+// dueRuns returns runs to add after last run and new last run time
+func dueRuns(s *schedule, last time.Time, now time.Time, catchUp string) ([]time.Time, time.Time) {
+	var due []time.Time
+	if s.every > 0 {
+		// skip runs we don't need without iterating
+		if n := int64(now.Sub(last) / s.every); n > maxCatchUp {
+			last = last.Add(time.Duration(n-maxCatchUp) * s.every)
+		}
+	}
+	for t := s.next(last); !t.IsZero() && !t.After(now); t = s.next(t) {
+		due = append(due, t)
+		if len(due) > maxCatchUp {
+			due = due[1:]
+		}
+	}
+	if len(due) == 0 {
+		return nil, last
+	}
+	latest := due[len(due)-1]
+	switch catchUp {
+	case catchUpAll:
+		return due, latest
+	case catchUpSkip:
+		if now.Sub(latest) > cronGrace {
+			return nil, latest
+		}
+	}
+	return due[len(due)-1:], latest
+}
+
+func checkCronJob(job *CronJob) error {
+	if job.Name == "" {
+		return errors.New("empty cron job name")
+	}
+	if _, err := parseSchedule(job.Schedule); err != nil {
+		return errors.Wrapf(err, "bad schedule for %v", job.Name)
+	}
+	switch job.CatchUp {
+	case "":
+		job.CatchUp = catchUpOnce
+	case catchUpSkip, catchUpOnce, catchUpAll:
+	default:
+		return fmt.Errorf("bad catch-up policy for %v: %v", job.Name, job.CatchUp)
+	}
+	if job.MaxTime < 0 || job.MaxRenew < 0 {
+		return fmt.Errorf("negative max-time or max-renew for %v", job.Name)
+	}
+	var err error
+	if job.Require, err = checkTags(&job.Require); err != nil {
+		return errors.Wrapf(err, "bad require for %v", job.Name)
+	}
+	return nil
+}
+
+func saveCronJob(ctx context.Context, job *CronJob) error {
+	j, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = client.Put(ctx, cronPrefix()+job.Name, string(j))
+	return err
+}
+
+// addCronJobs saves jobs from config, jobs added via api are kept
+func addCronJobs() error {
+	jobs := current().Cron
+	for i := range jobs {
+		job := &jobs[i]
+		if err := checkCronJob(job); err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+		err := saveCronJob(ctx, job)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startCron() {
+	s := gocron.NewScheduler()
+	s.Every(1).Second().Do(runCron)
+	s.Start()
+}
+
+func runCron() {
+	if !isLeader() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	resp, err := client.Get(ctx, cronPrefix(), clientv3.WithPrefix())
+	if err != nil {
+		logger.Warnf("fail to get cron jobs: %v", err)
+		return
+	}
+	for _, ev := range resp.Kvs {
+		var job CronJob
+		if err := json.Unmarshal(ev.Value, &job); err != nil {
+			logger.WithField("job", string(ev.Key)).Warnf("bad cron job: %v", err)
+			continue
+		}
+		if err := runJob(ctx, &job, time.Now()); err != nil {
+			logger.WithField("job", job.Name).Warnf("fail to run cron job: %v", err)
+		}
+	}
+}
+
+// runJob adds task per due run through put, run key moved to run time in same txn.
+// task dedup key is job name with run time, so run added once within dedup-window
+func runJob(ctx context.Context, job *CronJob, now time.Time) error {
+	s, err := parseSchedule(job.Schedule)
+	if err != nil {
+		return err
+	}
+	key := cronRunKey(job.Name)
+	resp, err := client.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if len(resp.Kvs) == 0 {
+		// new job, first run after now
+		_, err = client.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+			Then(clientv3.OpPut(key, strconv.FormatInt(now.UnixNano(), 10))).
+			Commit()
+		return err
+	}
+	last, err := strconv.ParseInt(string(resp.Kvs[0].Value), 10, 64)
+	if err != nil {
+		return err
+	}
+
+	due, latest := dueRuns(s, time.Unix(0, last), now, job.CatchUp)
+	if latest.UnixNano() == last {
+		return nil
+	}
+	prev := string(resp.Kvs[0].Value)
+	if len(due) == 0 {
+		// missed runs skipped
+		_, err = client.Txn(ctx).
+			If(clientv3.Compare(clientv3.Value(key), "=", prev)).
+			Then(clientv3.OpPut(key, strconv.FormatInt(latest.UnixNano(), 10))).
+			Commit()
+		return err
+	}
+
+	meta := newMeta(nil, "", job.MessageGroup, job.Require, job.MaxTime, job.MaxRenew)
+	created := make([]string, 0, len(due))
+	f := log.Fields{"job": job.Name}
+	defer func() {
+		if len(created) > 0 {
+			f["tasks"] = created
+			logger.WithFields(f).Debug("cron tasks added")
+		}
+	}()
+	for _, run := range due {
+		at := strconv.FormatInt(run.UnixNano(), 10)
+		var dedup *string
+		if current().DedupWindow > 0 {
+			id := "cron:" + job.Name + ":" + at
+			dedup = &id
+		}
+		task := KV{ID: newTaskID(), Value: job.Data, MessageGroup: job.MessageGroup}
+		moved := false
+		_, added, err := enqueueTask(ctx, &task, meta, dedup,
+			[]clientv3.Cmp{clientv3.Compare(clientv3.Value(key), "=", prev)},
+			[]clientv3.Op{clientv3.OpPut(key, at)},
+			func() (int, error) {
+				resp, err := client.Get(ctx, key)
+				if err != nil {
+					return http.StatusInternalServerError, err
+				}
+				if len(resp.Kvs) == 0 || string(resp.Kvs[0].Value) != prev {
+					moved = true
+					return http.StatusConflict, fmt.Errorf("run moved by other replica")
+				}
+				return http.StatusOK, nil
+			}, log.Fields{"job": job.Name, "task": task.ID})
+		if moved {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if added.Duplicate {
+			// run added before, only run key moved
+			if _, err = client.Txn(ctx).
+				If(clientv3.Compare(clientv3.Value(key), "=", prev)).
+				Then(clientv3.OpPut(key, at)).
+				Commit(); err != nil {
+				return err
+			}
+		} else {
+			created = append(created, added.ID)
+		}
+		prev = at
+	}
+	return nil
+}
+
+func putCron(name *string, schedule *string, data *string, catchUp *string, messageGroup *string, require *[]string, maxTime *int64, maxRenew *int64) (int, error) {
+	job := CronJob{Name: *name, Schedule: *schedule, Data: *data, MessageGroup: groupName(messageGroup)}
+	if catchUp != nil {
+		job.CatchUp = *catchUp
+	}
+	if require != nil {
+		job.Require = *require
+	}
+	if maxTime != nil {
+		job.MaxTime = *maxTime
+	}
+	if maxRenew != nil {
+		job.MaxRenew = *maxRenew
+	}
+	if err := checkCronJob(&job); err != nil {
+		return http.StatusBadRequest, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	err := saveCronJob(ctx, &job)
+	cancel()
+	if err != nil {
+		return http.StatusInternalServerError, errors.Wrap(err, "fail to save cron job")
+	}
+	return http.StatusOK, nil
+}
+
+func deleteCron(name *string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	resp, err := client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(cronPrefix()+*name), ">", 0)).
+		Then(clientv3.OpDelete(cronPrefix()+*name), clientv3.OpDelete(cronRunKey(*name))).
+		Commit()
+	cancel()
+	if err != nil {
+		return http.StatusInternalServerError, errors.Wrap(err, "fail to delete cron job")
+	}
+	if !resp.Succeeded {
+		return http.StatusNotFound, fmt.Errorf("no cron job %v", *name)
+	}
+	return http.StatusOK, nil
+}
+
+func listCron() (int, *[]CronJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	resp, err := client.Get(ctx, cronPrefix(), clientv3.WithPrefix())
+	cancel()
+	if err != nil {
+		return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to get cron jobs")
+	}
+	result := make([]CronJob, 0, len(resp.Kvs))
+	for _, ev := range resp.Kvs {
+		var job CronJob
+		if err := json.Unmarshal(ev.Value, &job); err != nil {
+			return http.StatusInternalServerError, nil, errors.Wrap(err, "bad cron job")
+		}
+		result = append(result, job)
+	}
+	return http.StatusOK, &result, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSchedule(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, spec := range []string{"* * * * *", "*/5 1-3,6 1 */2 1-5", "@daily", "@every 30s", "0 0 * * 7"} {
+		_, err := parseSchedule(spec)
+		assert.NoError(err, spec)
+	}
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "@every 1ms", "@yearly"} {
+		_, err := parseSchedule(spec)
+		assert.Error(err, spec)
+	}
+}
+
+func TestParseField(t *testing.T) {
+	assert := assert.New(t)
+	bits := func(values ...int) uint64 {
+		var b uint64
+		for _, v := range values {
+			b |= 1 << uint(v)
+		}
+		return b
+	}
+	for field, want := range map[string]uint64{
+		"*/15":     bits(0, 15, 30, 45),
+		"10-20/5":  bits(10, 15, 20),
+		"5/20":     bits(5, 25, 45),
+		"1,3-4":    bits(1, 3, 4),
+		"59":       bits(59),
+		"0-2,*/30": bits(0, 1, 2, 30),
+	} {
+		got, err := parseField(field, 0, 59)
+		assert.NoError(err, field)
+		assert.Equal(want, got, field)
+	}
+	for _, field := range []string{"1-", "a", "3-1", "*/", "-1", "1-60", "1,,2"} {
+		_, err := parseField(field, 0, 59)
+		assert.Error(err, field)
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	assert := assert.New(t)
+	at := func(s string) time.Time {
+		t, _ := time.Parse("2006-01-02 15:04", s)
+		return t
+	}
+	next := func(spec string, from string) time.Time {
+		s, err := parseSchedule(spec)
+		assert.NoError(err)
+		return s.next(at(from))
+	}
+
+	assert.Equal(at("2020-12-01 10:05"), next("*/5 * * * *", "2020-12-01 10:03"))
+	assert.Equal(at("2020-12-01 10:10"), next("*/5 * * * *", "2020-12-01 10:05"))
+	assert.Equal(at("2020-12-02 00:00"), next("@daily", "2020-12-01 10:03"))
+	assert.Equal(at("2021-01-01 03:00"), next("0 3 1 * *", "2020-12-01 10:03"))
+	assert.Equal(at("2020-12-07 09:30"), next("30 9 * * 1", "2020-12-01 10:03")) // next monday
+	assert.Equal(at("2020-12-06 00:00"), next("0 0 15 * 0", "2020-12-01 10:03")) // 15th or sunday
+	assert.Equal(at("2024-02-29 00:00"), next("0 0 29 2 *", "2020-12-01 10:03")) // leap day
+	assert.Equal(at("2020-12-01 10:33"), next("@every 30m", "2020-12-01 10:03"))
+	assert.True(next("0 0 30 2 *", "2020-12-01 10:03").IsZero())
+}
+
+func TestDueRuns(t *testing.T) {
+	assert := assert.New(t)
+	s, _ := parseSchedule("@hourly")
+	last := time.Date(2020, 12, 1, 10, 0, 0, 0, time.UTC)
+
+	due, latest := dueRuns(s, last, last.Add(30*time.Minute), catchUpAll)
+	assert.Empty(due)
+	assert.Equal(last, latest)
+
+	now := last.Add(3*time.Hour + 30*time.Second)
+	due, latest = dueRuns(s, last, now, catchUpAll)
+	assert.Len(due, 3)
+	assert.Equal(last.Add(3*time.Hour), latest)
+
+	due, latest = dueRuns(s, last, now, catchUpOnce)
+	assert.Equal([]time.Time{last.Add(3 * time.Hour)}, due)
+	assert.Equal(last.Add(3*time.Hour), latest)
+
+	due, _ = dueRuns(s, last, now, catchUpSkip)
+	assert.Len(due, 1) // last run is not late
+
+	due, latest = dueRuns(s, last, now.Add(10*time.Minute), catchUpSkip)
+	assert.Empty(due)
+	assert.Equal(last.Add(3*time.Hour), latest)
+
+	every, _ := parseSchedule("@every 1s")
+	due, latest = dueRuns(every, last, last.Add(24*time.Hour), catchUpAll)
+	assert.Len(due, maxCatchUp)
+	assert.Equal(last.Add(24*time.Hour), latest)
+}
+
+func TestScheduleDST(t *testing.T) {
+	assert := assert.New(t)
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no tz data: %v", err)
+	}
+	at := func(s string, zone string) time.Time {
+		t, _ := time.ParseInLocation("2006-01-02 15:04 MST", s+" "+zone, loc)
+		return t
+	}
+	runs := func(spec string, from time.Time, n int) []time.Time {
+		s, err := parseSchedule(spec)
+		assert.NoError(err)
+		var result []time.Time
+		for t := from; len(result) < n; {
+			t = s.next(t)
+			result = append(result, t)
+		}
+		return result
+	}
+	eq := func(want []time.Time, got []time.Time) {
+		assert.Len(got, len(want))
+		for i := range want {
+			assert.True(want[i].Equal(got[i]), "%v != %v", want[i], got[i])
+		}
+	}
+
+	// 2:00 EST jumps to 3:00 EDT on 2021-03-14, skipped runs done at the jump once
+	eq([]time.Time{at("2021-03-14 03:00", "EDT"), at("2021-03-15 02:30", "EDT")},
+		runs("30 2 * * *", at("2021-03-13 03:00", "EST"), 2))
+	eq([]time.Time{at("2021-03-14 01:30", "EST"), at("2021-03-14 03:00", "EDT"), at("2021-03-14 03:30", "EDT")},
+		runs("*/30 * * * *", at("2021-03-14 01:10", "EST"), 3))
+
+	// 2:00 EDT goes back to 1:00 EST on 2021-11-07, repeated hour runs once
+	eq([]time.Time{at("2021-11-07 01:30", "EDT"), at("2021-11-08 01:30", "EST")},
+		runs("30 1 * * *", at("2021-11-06 12:00", "EDT"), 2))
+	eq([]time.Time{at("2021-11-07 01:00", "EDT"), at("2021-11-07 02:00", "EST")},
+		runs("0 * * * *", at("2021-11-07 00:10", "EDT"), 2))
+	// from repeated hour, runs before it in wall clock not repeated
+	eq([]time.Time{at("2021-11-07 02:00", "EST")}, runs("*/30 * * * *", at("2021-11-07 01:10", "EST"), 1))
+}
+
+func TestRunJob(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	useSettings(t, settings{LogLevel: "info", Limit: 100, DedupWindow: time.Minute})
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	now := time.Now()
+	start := strconv.FormatInt(now.Add(-3*time.Minute).UnixNano(), 10)
+	_, err := client.Put(ctx, cronRunKey("job"), start)
+	assert.Nil(err)
+
+	// task per missed run, with attributes of job
+	job := &CronJob{Name: "job", Schedule: "@every 1m", Data: "cron", CatchUp: catchUpAll, Require: []string{"gpu=1"}, MaxTime: 60}
+	assert.Nil(checkCronJob(job))
+	assert.Nil(runJob(ctx, job, now))
+	ids := taskKeys(t)
+	assert.Len(ids, 3)
+	meta, err := loadMeta(ctx, ids[0], ids[2])
+	assert.Nil(err)
+	assert.Len(meta, 3)
+	assert.Equal(&taskMeta{Require: []string{"gpu=1"}, MaxTime: 60}, meta[ids[0]])
+	resp, err := client.Get(ctx, cronRunKey("job"))
+	assert.Nil(err)
+	assert.Equal(strconv.FormatInt(now.UnixNano(), 10), string(resp.Kvs[0].Value))
+
+	// runs done, nothing added again
+	assert.Nil(runJob(ctx, job, now))
+	assert.Len(taskKeys(t), 3)
+
+	// run key moved back, runs are duplicates within dedup-window
+	_, err = client.Put(ctx, cronRunKey("job"), start)
+	assert.Nil(err)
+	assert.Nil(runJob(ctx, job, now))
+	assert.Equal(ids, taskKeys(t))
+	resp, err = client.Get(ctx, cronRunKey("job"))
+	assert.Nil(err)
+	assert.Equal(strconv.FormatInt(now.UnixNano(), 10), string(resp.Kvs[0].Value))
+}
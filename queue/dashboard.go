@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/gorilla/mux"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// dashboard: html page with counters, leases and dead tasks, actions call api via fetch
+
+// rows shown in tasks and dead tables
+const dashboardRows = 50
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>queue {{.Queue}}</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; margin: 20px; }
+table { border-collapse: collapse; margin-bottom: 20px; }
+th, td { border: 1px solid #ccc; padding: 3px 8px; text-align: left; }
+th { background: #eee; }
+button { font-size: 12px; }
+</style>
+<script>
+function act(path, method) {
+	if (!confirm(path)) { return; }
+	fetch("/api/v1/" + path, {method: method || "GET"}).then(function(r) {
+		if (!r.ok) { alert(path + ": " + r.status); }
+		location.reload();
+	});
+}
+</script>
+</head>
+<body>
+<h2>queue {{.Queue}}{{if .Leader}} (leader){{end}}{{if .Paused}} (paused){{end}}</h2>
+{{if .Paused}}<button onclick="act('resume')">resume dispatch</button>{{else}}<button onclick="act('pause')">pause dispatch</button>{{end}}
+
+<h3>counters</h3>
+<table>
+<tr><th>group</th><th>pending</th><th>active</th><th>dead</th><th></th></tr>
+{{range .Stats}}<tr><td>{{.Group}}</td><td>{{.Pending}}</td><td>{{.Active}}</td><td>{{.Dead}}</td>
+<td><button onclick="act('dead/purge{{if .Group}}?group={{.Group}}{{end}}', 'DELETE')">purge dead</button></td></tr>
+{{end}}</table>
+<button onclick="act('purge', 'DELETE')">purge queue</button>
+
+<h3>throughput, tasks/s over last minute (this replica)</h3>
+<table>
+<tr>{{range $k, $v := .Rates}}<th>{{$k}}</th>{{end}}</tr>
+<tr>{{range $k, $v := .Rates}}<td>{{printf "%.2f" $v}}</td>{{end}}</tr>
+</table>
+
+<h3>leases</h3>
+<table>
+<tr><th>group</th><th>task</th><th>client</th><th>ttl, s</th><th>token</th><th>progress</th></tr>
+{{range .Leases}}<tr><td>{{.Group}}</td><td>{{.ID}}</td><td>{{.Client}}</td><td>{{.TTL}}</td><td>{{.Token}}</td><td>{{.Progress}}</td></tr>
+{{end}}</table>
+
+<h3>tasks (first {{.Rows}})</h3>
+<table>
+<tr><th>task</th><th>value</th><th></th></tr>
+{{range .Tasks}}<tr><td>{{.ID}}</td><td>{{.Value}}</td>
+<td><button onclick="act('delete?task_id={{.ID}}', 'DELETE')">delete</button></td></tr>
+{{end}}</table>
+
+<h3>dead (first {{.Rows}})</h3>
+<table>
+<tr><th>group</th><th>task</th><th>value</th><th></th></tr>
+{{range .Dead}}<tr><td>{{.Group}}</td><td>{{.ID}}</td><td>{{.Value}}</td>
+<td><button onclick="act('dead/requeue?task_id={{.ID}}{{if .Group}}&group={{.Group}}{{end}}', 'POST')">requeue</button>
+<button onclick="act('dead/delete?task_id={{.ID}}{{if .Group}}&group={{.Group}}{{end}}', 'DELETE')">delete</button></td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type deadTask struct {
+	Group string
+	KV
+}
+
+type dashboardData struct {
+	Queue  string
+	Leader bool
+	Paused bool
+	Rows   int
+	Stats  []Stats
+	Rates  map[string]float64
+	Leases []Lease
+	Tasks  []KV
+	Dead   []deadTask
+}
+
+// AddDashboardRoute registers dashboard and metrics in router
+func AddDashboardRoute(r *mux.Router) {
+	r.Path("/").Methods("get").Handler(http.RedirectHandler("/ui", http.StatusFound))
+	r.Path("/ui").Methods("get").HandlerFunc(dashboard)
+	r.Path("/metrics").Methods("get").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metrics.WritePrometheus(w, true)
+	})
+}
+
+func loadDashboard() (*dashboardData, error) {
+	d := &dashboardData{Queue: cfg.Queue, Leader: isLeader(), Paused: isPaused(), Rows: dashboardRows, Rates: throughput()}
+	_, stats, err := stats()
+	if err != nil {
+		return nil, err
+	}
+	d.Stats = *stats
+	_, leases, err := listLeases()
+	if err != nil {
+		return nil, err
+	}
+	d.Leases = *leases
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	start, opts := tasksAfter(nil, 0)
+	resp, err := client.Get(ctx, start, append(opts, clientv3.WithLimit(dashboardRows))...)
+	if err != nil {
+		return nil, err
+	}
+	prefixLen := len(cfg.Queue) + 1 // to skip `:`
+	for _, ev := range resp.Kvs {
+		d.Tasks = append(d.Tasks, KV{ID: string(ev.Key)[prefixLen:], Value: string(ev.Value)})
+	}
+
+	for _, g := range groups() {
+		prefix := deadPrefix(g)
+		resp, err := client.Get(ctx, prefix, clientv3.WithPrefix(),
+			clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend), clientv3.WithLimit(dashboardRows))
+		if err != nil {
+			return nil, err
+		}
+		for _, ev := range resp.Kvs {
+			d.Dead = append(d.Dead, deadTask{Group: g, KV: KV{ID: string(ev.Key)[len(prefix):], Value: string(ev.Value)}})
+		}
+	}
+	return d, nil
+}
+
+func dashboard(w http.ResponseWriter, r *http.Request) {
+	d, err := loadDashboard()
+	if err != nil {
+		logger.WithField("method", "/ui").Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err = dashboardTemplate.Execute(w, d); err != nil {
+		logger.WithField("method", "/ui").Error(err)
+	}
+}
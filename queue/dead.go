@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// nak releases task for other clients and counts attempts,
+// task moved to dead letter queue after max-attempts naks
+
+func attemptsKey(group string, task string) string {
+	if group == "" {
+		return "__attempts:" + cfg.Queue + ":" + task
+	}
+	return "__attempts:" + cfg.Queue + ":" + group + ":" + task
+}
+
+func deadPrefix(group string) string {
+	if group == "" {
+		return "__dead:" + cfg.Queue + ":"
+	}
+	return "__dead:" + cfg.Queue + ":" + group + ":"
+}
+
+// deadMetaPrefix keeps metadata of dead task, restored by requeue
+func deadMetaPrefix(group string) string {
+	if group == "" {
+		return "__deadmeta:" + cfg.Queue + ":"
+	}
+	return "__deadmeta:" + cfg.Queue + ":" + group + ":"
+}
+
+func nakTask(clientID *string, taskID *string, group *string, token *int64, requestID *string) (int, error) {
+	if code, err := checkGroup(group); err != nil {
+		return code, err
+	}
+	f := withRequest(log.Fields{"client": *clientID, "task": *taskID}, requestID)
+	if group != nil {
+		f["group"] = *group
+	}
+	return releaseTask(*clientID, *taskID, groupName(group), token, f)
+}
+
+// releaseTask releases task as failed attempt, task moved to dead letter queue after max-attempts
+func releaseTask(clientID string, taskID string, g string, token *int64, f log.Fields) (int, error) {
+	key := activePrefix(g) + taskID
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	resp, err := client.Txn(ctx).Then(
+		clientv3.OpGet(attemptsKey(g, taskID)),
+		clientv3.OpGet(cfg.Queue+":"+taskID),
+		clientv3.OpGet(metaKey(taskID))).Commit()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("fail to get task attempts")
+	}
+	attempts := 0
+	cmp := clientv3.Compare(clientv3.CreateRevision(attemptsKey(g, taskID)), "=", 0)
+	if kvs := resp.Responses[0].GetResponseRange().Kvs; len(kvs) > 0 {
+		if attempts, err = strconv.Atoi(string(kvs[0].Value)); err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("bad attempts %s", kvs[0].Value)
+		}
+		cmp = clientv3.Compare(clientv3.ModRevision(attemptsKey(g, taskID)), "=", kvs[0].ModRevision)
+	}
+	attempts++
+	f["attempts"] = attempts
+
+	ops := releaseOps(g, taskID)
+	max := current().MaxAttempts
+	dead := max > 0 && attempts >= max
+	if dead {
+		data := resp.Responses[1].GetResponseRange().Kvs
+		if len(data) == 0 {
+			return http.StatusNotFound, fmt.Errorf("task %v not found", taskID)
+		}
+		var meta []byte
+		if kvs := resp.Responses[2].GetResponseRange().Kvs; len(kvs) > 0 {
+			meta = kvs[0].Value
+		}
+		ops = append(ops, deadOps(g, taskID, data[0], meta)...)
+	} else {
+		ops = append(ops, clientv3.OpPut(attemptsKey(g, taskID), strconv.Itoa(attempts)))
+	}
+
+	nak, err := client.Txn(ctx).
+		If(append(ownerCmps(key, clientID, token), cmp)...).
+		Then(ops...).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("fail to nak task %v", taskID)
+	}
+	if !nak.Succeeded {
+		return notOwned(nak.Responses[0].GetResponseRange().Kvs, taskID, token)
+	}
+	counter("naked").Inc()
+	if !dead {
+		logger.WithFields(f).Debug("task released")
+		return http.StatusOK, nil
+	}
+	logger.WithFields(f).Info("task moved to dead letter queue")
+	passDead(ctx, g, f)
+	return http.StatusOK, nil
+}
+
+// deadOps returns ops to move task with metadata to dead letter queue of group,
+// in topic mode task stays in queue acked by group
+func deadOps(g string, taskID string, task *mvccpb.KeyValue, meta []byte) []clientv3.Op {
+	ops := []clientv3.Op{clientv3.OpDelete(attemptsKey(g, taskID)), clientv3.OpDelete(startedKey(g, taskID)),
+		clientv3.OpPut(deadPrefix(g)+taskID, string(task.Value))}
+	if meta != nil {
+		ops = append(ops, clientv3.OpPut(deadMetaPrefix(g)+taskID, string(meta)))
+	}
+	if g == "" {
+		return append(ops, clientv3.OpDelete(cfg.Queue+":"+taskID), clientv3.OpDelete(metaKey(taskID)))
+	}
+	return append(ops, clientv3.OpPut(groupAckedPrefix(g)+taskID, strconv.FormatInt(task.CreateRevision, 10)))
+}
+
+// passDead counts dead task, cursor moved over it in topic mode
+func passDead(ctx context.Context, g string, f log.Fields) {
+	counter("dead").Inc()
+	if g == "" {
+		return
+	}
+	if err := advanceCursor(ctx, g); err != nil {
+		logger.WithFields(f).Warnf("fail to advance cursor: %v", err)
+	} else if err = removeConsumed(ctx); err != nil {
+		logger.WithFields(f).Warnf("fail to remove consumed tasks: %v", err)
+	}
+}
+
+func listDead(group *string) (int, *[]KV, error) {
+	if code, err := checkGroup(group); err != nil {
+		return code, nil, err
+	}
+	prefix := deadPrefix(groupName(group))
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	resp, err := client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	cancel()
+	if err != nil {
+		return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to get dead tasks")
+	}
+	result := make([]KV, 0, len(resp.Kvs))
+	for _, ev := range resp.Kvs {
+		result = append(result, KV{ID: string(ev.Key)[len(prefix):], Value: string(ev.Value)})
+	}
+	return http.StatusOK, &result, nil
+}
+
+func purgeDead(group *string) (int, error) {
+	if code, err := checkGroup(group); err != nil {
+		return code, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	resp, err := client.Txn(ctx).Then(
+		clientv3.OpDelete(deadPrefix(groupName(group)), clientv3.WithPrefix()),
+		clientv3.OpDelete(deadMetaPrefix(groupName(group)), clientv3.WithPrefix())).Commit()
+	cancel()
+	if err != nil {
+		return http.StatusInternalServerError, errors.Wrap(err, "fail to purge dead tasks")
+	}
+	logger.WithField("count", resp.Responses[0].GetResponseDeleteRange().Deleted).Info("dead tasks purged")
+	return http.StatusOK, nil
+}
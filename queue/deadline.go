@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// deadlines: task put with max_time or max_renew gets deadline key in lock txn, with lease of task.
+// max_time counted from first lease and renews counted over all leases, both kept in started key
+// until task acked or dead. renew beyond max_renew or after max_time refused and task released
+// as failed attempt (nak), by leader even if worker keeps lease alive. max-attempts decides if it is dead.
+// task whose max_time passed is not leased again, it is moved to dead letter queue by get
+
+// deadline is value of deadline key, limits with start of task for leader
+type deadline struct {
+	Started  time.Time // first lease of task
+	MaxTime  int64     `json:",omitempty"` // seconds
+	MaxRenew int64     `json:",omitempty"`
+	Renews   int64     `json:"-"` // kept in started key
+}
+
+// started is value of started key
+type started struct {
+	Started time.Time
+	Renews  int64 `json:",omitempty"`
+}
+
+// how often leader looks for overdue tasks
+const deadlinePoll = time.Second
+
+func deadlinePrefix(group string) string {
+	if group == "" {
+		return "__deadline:" + cfg.Queue + ":"
+	}
+	return "__deadline:" + cfg.Queue + ":" + group + ":"
+}
+
+func startedKey(group string, task string) string {
+	if group == "" {
+		return "__started:" + cfg.Queue + ":" + task
+	}
+	return "__started:" + cfg.Queue + ":" + group + ":" + task
+}
+
+// newDeadline returns deadline for task, nil if task have no limits
+func newDeadline(m *taskMeta) *deadline {
+	if m == nil || (m.MaxTime == 0 && m.MaxRenew == 0) {
+		return nil
+	}
+	return &deadline{MaxTime: m.MaxTime, MaxRenew: m.MaxRenew}
+}
+
+// expired returns true if task runs longer than max_time since first lease, false if not leased yet
+func (d *deadline) expired(now time.Time) bool {
+	return d.MaxTime > 0 && !d.Started.IsZero() && now.Sub(d.Started) > time.Duration(d.MaxTime)*time.Second
+}
+
+// exceeded returns reason if task must be released
+func (d *deadline) exceeded(now time.Time) string {
+	if d.expired(now) {
+		return fmt.Sprintf("max_time %vs exceeded", d.MaxTime)
+	}
+	if d.MaxRenew > 0 && d.Renews > d.MaxRenew {
+		return fmt.Sprintf("max_renew %v exceeded", d.MaxRenew)
+	}
+	return ""
+}
+
+// loadStarted sets start of first lease and renews if task leased before,
+// returns mod revision of started key, 0 if not set
+func loadStarted(ctx context.Context, group string, id string, d *deadline) (int64, error) {
+	resp, err := client.Get(ctx, startedKey(group, id))
+	if err != nil || len(resp.Kvs) == 0 {
+		return 0, err
+	}
+	kv := resp.Kvs[0]
+	// older version keeps time only
+	if d.Started, err = time.Parse(time.RFC3339Nano, string(kv.Value)); err == nil {
+		return kv.ModRevision, nil
+	}
+	var v started
+	if err = json.Unmarshal(kv.Value, &v); err != nil {
+		return 0, errors.Wrapf(err, "bad start of task %v", id)
+	}
+	d.Started, d.Renews = v.Started, v.Renews
+	return kv.ModRevision, nil
+}
+
+// startedOp returns op to put start and renews of task
+func startedOp(group string, id string, d *deadline) (clientv3.Op, error) {
+	value, err := json.Marshal(started{Started: d.Started, Renews: d.Renews})
+	if err != nil {
+		return clientv3.Op{}, err
+	}
+	return clientv3.OpPut(startedKey(group, id), string(value)), nil
+}
+
+// deadlineOps returns ops to put deadline with lease of task, and start of first lease
+// with cmp it is not set concurrently
+func deadlineOps(group string, id string, d *deadline, lease clientv3.LeaseID) ([]clientv3.Op, []clientv3.Cmp, error) {
+	var ops []clientv3.Op
+	var cmps []clientv3.Cmp
+	if d.Started.IsZero() {
+		d.Started = time.Now().UTC()
+		op, err := startedOp(group, id, d)
+		if err != nil {
+			return nil, nil, err
+		}
+		ops = append(ops, op)
+		cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(startedKey(group, id)), "=", 0))
+	}
+	value, err := json.Marshal(d)
+	if err != nil {
+		return nil, nil, err
+	}
+	ops = append(ops, clientv3.OpPut(deadlinePrefix(group)+id, string(value), clientv3.WithLease(lease)))
+	return ops, cmps, nil
+}
+
+// releaseOps returns ops to delete task lock and keys kept with it
+func releaseOps(group string, id string) []clientv3.Op {
+	return []clientv3.Op{
+		clientv3.OpDelete(activePrefix(group) + id),
+		clientv3.OpDelete(progressPrefix(group) + id),
+		clientv3.OpDelete(deadlinePrefix(group) + id),
+	}
+}
+
+// countRenew checks task limits on renew, task released if exceeded
+func countRenew(ctx context.Context, clientID string, group string, id string, token int64) (int, error) {
+	resp, err := client.Get(ctx, deadlinePrefix(group)+id)
+	if err != nil {
+		return http.StatusInternalServerError, errors.Wrap(err, "fail to get deadline")
+	}
+	if len(resp.Kvs) == 0 {
+		return http.StatusOK, nil
+	}
+	var d deadline
+	if err = json.Unmarshal(resp.Kvs[0].Value, &d); err != nil {
+		return http.StatusInternalServerError, errors.Wrapf(err, "bad deadline of task %v", id)
+	}
+	rev, err := loadStarted(ctx, group, id, &d)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	d.Renews++
+	if reason := d.exceeded(time.Now()); reason != "" {
+		if code, err := releaseOverdue(clientID, group, id, token); err != nil {
+			return code, err
+		}
+		return http.StatusGone, fmt.Errorf("task %v released, %v", id, reason)
+	}
+	op, err := startedOp(group, id, &d)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	put, err := client.Txn(ctx).
+		If(append(ownerCmps(activePrefix(group)+id, clientID, &token),
+			clientv3.Compare(clientv3.ModRevision(startedKey(group, id)), "=", rev))...).
+		Then(op).
+		Commit()
+	if err != nil {
+		return http.StatusInternalServerError, errors.Wrap(err, "fail to count renew")
+	}
+	if !put.Succeeded {
+		return http.StatusConflict, fmt.Errorf("task %v renewed concurrently or released", id)
+	}
+	return http.StatusOK, nil
+}
+
+// releaseOverdue naks task on behalf of client
+func releaseOverdue(clientID string, group string, id string, token int64) (int, error) {
+	f := log.Fields{"client": clientID, "task": id}
+	if group != "" {
+		f["group"] = group
+	}
+	code, err := releaseTask(clientID, id, group, &token, f)
+	if err == nil {
+		counter("overdue").Inc()
+		logger.WithFields(f).Info("overdue task released")
+	}
+	return code, err
+}
+
+// deadExpired moves task not leased with max_time passed to dead letter queue, it can not finish in time.
+// returns false if task leased or changed concurrently
+func deadExpired(ctx context.Context, group string, task *mvccpb.KeyValue, m *taskMeta) (bool, error) {
+	id := string(task.Key)[len(cfg.Queue)+1:]
+	meta, err := json.Marshal(m)
+	if err != nil {
+		return false, err
+	}
+	cmps := []clientv3.Cmp{
+		clientv3.Compare(clientv3.ModRevision(string(task.Key)), "=", task.ModRevision),
+		clientv3.Compare(clientv3.CreateRevision(activePrefix(group)+id), "=", 0),
+	}
+	if group != "" {
+		cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(groupAckedPrefix(group)+id), "=", 0))
+	}
+	resp, err := client.Txn(ctx).If(cmps...).Then(deadOps(group, id, task, meta)...).Commit()
+	if err != nil || !resp.Succeeded {
+		return false, err
+	}
+	f := log.Fields{"task": id, "max_time": m.MaxTime}
+	if group != "" {
+		f["group"] = group
+	}
+	logger.WithFields(f).Info("task with max_time passed moved to dead letter queue")
+	passDead(ctx, group, f)
+	return true, nil
+}
+
+// deadlineLoop releases tasks running longer than max_time, on leader only
+func deadlineLoop() {
+	ticker := time.NewTicker(deadlinePoll)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !isLeader() {
+			continue
+		}
+		if err := releaseAllOverdue(); err != nil {
+			logger.Warnf("fail to release overdue tasks: %v", err)
+		}
+	}
+}
+
+func releaseAllOverdue() error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	prefix := deadlinePrefix("")
+	resp, err := client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, ev := range resp.Kvs {
+		var d deadline
+		if err = json.Unmarshal(ev.Value, &d); err != nil {
+			return errors.Wrapf(err, "bad deadline %s", ev.Key)
+		}
+		if !d.expired(now) {
+			continue
+		}
+		// key is `id` or `group:id` in topic mode
+		group, id := "", string(ev.Key)[len(prefix):]
+		if i := strings.LastIndex(id, ":"); i >= 0 {
+			group, id = id[:i], id[i+1:]
+		}
+		active, err := client.Get(ctx, activePrefix(group)+id)
+		if err != nil {
+			return err
+		}
+		if len(active.Kvs) == 0 {
+			continue
+		}
+		kv := active.Kvs[0]
+		if _, err := releaseOverdue(string(kv.Value), group, id, kv.CreateRevision); err != nil {
+			logger.WithField("task", id).Warnf("fail to release overdue task: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestDeadline(t *testing.T) {
+	assert := assert.New(t)
+	assert.Nil(newDeadline(nil))
+	assert.Nil(newDeadline(&taskMeta{Trace: "x"}))
+
+	now := time.Now()
+	d := newDeadline(&taskMeta{MaxTime: 10, MaxRenew: 2})
+	d.Started = now
+	assert.Empty(d.exceeded(now.Add(10 * time.Second)))
+	assert.Contains(d.exceeded(now.Add(11*time.Second)), "max_time")
+	d.Renews = 2
+	assert.Empty(d.exceeded(now))
+	d.Renews = 3
+	assert.Contains(d.exceeded(now), "max_renew")
+
+	d = newDeadline(&taskMeta{MaxRenew: 1})
+	assert.Empty(d.exceeded(now.Add(time.Hour)))
+}
+
+// addLimited puts task with limits
+func addLimited(t *testing.T, data string, maxTime int64, maxRenew int64, parents ...string) string {
+	t.Helper()
+	code, task, err := putTask(&data, nil, nil, &parents, nil, nil, nil, &maxTime, &maxRenew, nil, nil)
+	if err != nil {
+		t.Fatalf("put: %v %v", code, err)
+	}
+	return task.ID
+}
+
+// setStarted moves first lease of task to the past
+func setStarted(t *testing.T, id string, ago time.Duration) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	op, err := startedOp("", id, &deadline{Started: time.Now().Add(-ago).UTC()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Do(ctx, op); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadStarted(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	var d deadline
+	rev, err := loadStarted(ctx, "", "1", &d)
+	assert.Nil(err)
+	assert.Zero(rev)
+	assert.True(d.Started.IsZero())
+
+	// time only, as older version keeps it
+	now := time.Now().UTC()
+	_, err = client.Put(ctx, startedKey("", "1"), now.Format(time.RFC3339Nano))
+	assert.Nil(err)
+	rev, err = loadStarted(ctx, "", "1", &d)
+	assert.Nil(err)
+	assert.NotZero(rev)
+	assert.True(now.Equal(d.Started))
+
+	d.Renews = 2
+	op, err := startedOp("", "1", &d)
+	assert.Nil(err)
+	_, err = client.Do(ctx, op)
+	assert.Nil(err)
+	loaded := deadline{}
+	_, err = loadStarted(ctx, "", "1", &loaded)
+	assert.Nil(err)
+	assert.True(now.Equal(loaded.Started))
+	assert.Equal(int64(2), loaded.Renews)
+}
+
+func TestMaxRenew(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	// limits of task waiting for parent not applied to task leased instead
+	parent := addTask(t, "parent")
+	addLimited(t, "child", 0, 1, parent)
+	free := addTask(t, "free")
+	lease(t, "w1", "")
+	assert.Equal(free, lease(t, "w2", "").ID)
+	w2 := "w2"
+	for i := 0; i < 3; i++ {
+		code, err := renewTask(&w2, &free, nil, nil, nil, nil)
+		assert.Nil(err)
+		assert.Equal(http.StatusOK, code)
+	}
+
+	ack(t, "w1", "", parent)
+	child := lease(t, "w1", "").ID
+	w1 := "w1"
+	code, err := renewTask(&w1, &child, nil, nil, nil, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	code, _ = renewTask(&w1, &child, nil, nil, nil, nil)
+	assert.Equal(http.StatusGone, code)
+
+	// released as failed attempt, renews counted over all leases
+	assert.Equal(child, lease(t, "w3", "").ID)
+	w3 := "w3"
+	code, _ = renewTask(&w3, &child, nil, nil, nil, nil)
+	assert.Equal(http.StatusGone, code)
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	resp, err := client.Get(ctx, attemptsKey("", child))
+	assert.Nil(err)
+	assert.Equal("2", string(resp.Kvs[0].Value))
+}
+
+func TestMaxTime(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	id := addLimited(t, "x", 60, 0)
+	first := lease(t, "w", "")
+	w := "w"
+	code, err := nakTask(&w, &id, nil, &first.Token, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+
+	// counted from first lease, task not leased after max_time and moved to dead letter queue
+	setStarted(t, id, time.Minute)
+	timeout := int64(10)
+	code, _, err = getTask(&w, &timeout, nil, nil, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusNoContent, code)
+	assert.Empty(taskKeys(t))
+	_, dead, err := listDead(nil)
+	assert.Nil(err)
+	assert.Equal([]KV{{ID: id, Value: "x"}}, *dead)
+
+	// renew after max_time refused, released as failed attempt
+	id = addLimited(t, "y", 60, 0)
+	task := lease(t, "w", "")
+	setStarted(t, id, time.Minute)
+	code, _ = renewTask(&w, &id, nil, nil, &task.Token, nil)
+	assert.Equal(http.StatusGone, code)
+	assert.Equal([]string{id}, taskKeys(t))
+
+	// leader releases expired task held by worker, max-attempts decides if it is dead
+	useSettings(t, settings{LogLevel: "info", Limit: 100, MaxAttempts: 2})
+	setStarted(t, id, 0)
+	lease(t, "w", "")
+	assert.Nil(releaseAllOverdue())
+	assert.Equal([]string{id}, taskKeys(t))
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	d, err := json.Marshal(deadline{Started: time.Now().Add(-time.Minute), MaxTime: 60})
+	assert.Nil(err)
+	_, err = client.Put(ctx, deadlinePrefix("")+id, string(d))
+	assert.Nil(err)
+	assert.Nil(releaseAllOverdue())
+	assert.Empty(taskKeys(t))
+	_, dead, err = listDead(nil)
+	assert.Nil(err)
+	assert.Len(*dead, 2)
+
+	// start kept until task done
+	id = addLimited(t, "z", 60, 0)
+	lease(t, "w", "")
+	ack(t, "w", "", id)
+	resp, err := client.Get(ctx, startedKey("", ""), clientv3.WithPrefix())
+	assert.Nil(err)
+	assert.Empty(resp.Kvs)
+}
+
+func TestStreamMaxRenew(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	id := addLimited(t, "x", 0, 1)
+	s, err := newStream("w", 5, 1, nil)
+	assert.Nil(err)
+	incoming, recv, done := testStream(t, s)
+	assert.Equal(id, recv().ID)
+
+	incoming <- StreamMsg{Op: "renew"}
+	assert.Equal(StreamMsg{Op: "renew", Code: http.StatusOK}, recv())
+	incoming <- StreamMsg{Op: "renew"}
+	assert.Equal(StreamMsg{Op: "renew", ID: id, Code: http.StatusGone}, recv())
+	assert.Equal(StreamMsg{Op: "renew", Code: http.StatusOK}, recv())
+	// released task sent again
+	assert.Equal(id, recv().ID)
+	close(incoming)
+	<-done
+}
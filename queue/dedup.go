@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// dedup: put writes dedup key (client key or data hash) with lease of dedup-window
+// in same txn as task, txn fails if key exists and put returns task added before
+
+func dedupPrefix() string {
+	return "__dedup:" + cfg.Queue + ":"
+}
+
+// dedupKey returns etcd key for task, client key preferred over data hash
+func dedupKey(data string, key *string) string {
+	if key != nil {
+		return dedupPrefix() + *key
+	}
+	sum := sha256.Sum256([]byte(data))
+	return dedupPrefix() + hex.EncodeToString(sum[:])
+}
+
+// duplicate returns task added before with same dedup key, nil if none.
+// Value is data of stored task, empty if task already done
+func duplicate(ctx context.Context, key string) (int, *KV, error) {
+	resp, err := client.Get(ctx, key)
+	if err != nil {
+		return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to check duplicate")
+	}
+	if len(resp.Kvs) == 0 {
+		return http.StatusOK, nil, nil
+	}
+	prev := KV{ID: string(resp.Kvs[0].Value), Duplicate: true}
+	stored, err := client.Get(ctx, cfg.Queue+":"+prev.ID)
+	if err != nil {
+		return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to get duplicate task")
+	}
+	if len(stored.Kvs) > 0 {
+		prev.Value = string(stored.Kvs[0].Value)
+	}
+	counter("duplicate").Inc()
+	logger.WithField("task", prev.ID).Debug("duplicate task skipped")
+	return http.StatusOK, &prev, nil
+}
+
+// leaseCache shares one lease between keys put within reuse time
+type leaseCache struct {
+	sync.Mutex
+	id      clientv3.LeaseID
+	ttl     int64
+	granted time.Time
+}
+
+// get returns lease expiring after ttl, precise to reuse time
+func (c *leaseCache) get(ctx context.Context, ttl time.Duration, reuse time.Duration) (clientv3.LeaseID, error) {
+	seconds := int64(math.Ceil(ttl.Seconds()))
+	c.Lock()
+	defer c.Unlock()
+	if c.ttl == seconds && time.Since(c.granted) < reuse {
+		return c.id, nil
+	}
+	lease, err := client.Grant(ctx, seconds)
+	if err != nil {
+		return 0, err
+	}
+	c.id, c.ttl, c.granted = lease.ID, seconds, time.Now()
+	return lease.ID, nil
+}
+
+// keys put within same second share a lease, so window is precise to a second
+var dedupLeases leaseCache
+
+// dedupLease returns lease for dedup keys expiring after window
+func dedupLease(ctx context.Context, window time.Duration) (clientv3.LeaseID, error) {
+	return dedupLeases.get(ctx, window, time.Second)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// putDedup puts task with optional dedup key
+func putDedup(t *testing.T, data string, key *string) *KV {
+	t.Helper()
+	code, task, err := putTask(&data, nil, nil, nil, nil, nil, key, nil, nil, nil, nil)
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("put: %v %v", code, err)
+	}
+	return task
+}
+
+func TestDedupKey(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	useSettings(t, settings{LogLevel: "info", Limit: 100, DedupWindow: time.Minute})
+
+	// client key: other data with same key returns stored task
+	key := "order-42"
+	first := putDedup(t, "report", &key)
+	assert.False(first.Duplicate)
+	dup := putDedup(t, "report v2", &key)
+	assert.Equal(KV{ID: first.ID, Value: "report", Duplicate: true}, *dup)
+
+	// stored task done, id still returned within window
+	lease(t, "w", "")
+	ack(t, "w", "", first.ID)
+	assert.Equal(KV{ID: first.ID, Duplicate: true}, *putDedup(t, "report", &key))
+	assert.Empty(taskKeys(t))
+}
+
+func TestDedupHash(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	useSettings(t, settings{LogLevel: "info", Limit: 100, DedupWindow: time.Minute})
+
+	sum := sha256.Sum256([]byte("data"))
+	assert.Equal(dedupPrefix()+hex.EncodeToString(sum[:]), dedupKey("data", nil))
+
+	first := putDedup(t, "data", nil)
+	assert.Equal(KV{ID: first.ID, Value: "data", Duplicate: true}, *putDedup(t, "data", nil))
+	other := putDedup(t, "other", nil)
+	assert.False(other.Duplicate)
+	assert.Equal([]string{first.ID, other.ID}, taskKeys(t))
+
+	// no window, no dedup key
+	useSettings(t, settings{LogLevel: "info", Limit: 100})
+	key := "k"
+	data := "x"
+	code, _, err := putTask(&data, nil, nil, nil, nil, nil, &key, nil, nil, nil, nil)
+	assert.NotNil(err)
+	assert.Equal(http.StatusBadRequest, code)
+}
+
+func TestDedupConcurrent(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	useSettings(t, settings{LogLevel: "info", Limit: 100, DedupWindow: time.Minute})
+
+	// puts racing past duplicate check fail in txn and return task of winner
+	const n = 10
+	key := "race"
+	ids := make(chan string, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data := "x"
+			code, task, err := putTask(&data, nil, nil, nil, nil, nil, &key, nil, nil, nil, nil)
+			if assert.Nil(err) && assert.Equal(http.StatusOK, code) {
+				ids <- task.ID
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	keys := taskKeys(t)
+	assert.Len(keys, 1)
+	for id := range ids {
+		assert.Equal(keys[0], id)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/client/v3"
+)
+
+// tests with etcd run against QUEUE_TEST_ETCD (localhost:2379 by default)
+// and skipped if it is not available. every test gets own queue, removed after test
+
+// etcdErr is set if etcd not available, checked once
+var (
+	etcdOnce sync.Once
+	etcdErr  error
+)
+
+func withEtcd(t *testing.T, groups ...string) {
+	t.Helper()
+	etcdOnce.Do(func() {
+		endpoint := os.Getenv("QUEUE_TEST_ETCD")
+		if endpoint == "" {
+			endpoint = "localhost:2379"
+		}
+		if client, etcdErr = clientv3.New(clientv3.Config{Endpoints: []string{endpoint}, DialTimeout: time.Second}); etcdErr != nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, etcdErr = client.Status(ctx, endpoint)
+	})
+	if etcdErr != nil {
+		t.Skipf("etcd not available: %v", etcdErr)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	saved := cfg
+	cfg = config{Queue: "test-" + strconv.FormatInt(time.Now().UnixNano(), 36), Groups: groups}
+	useSettings(t, settings{LogLevel: "info", Limit: 100})
+	t.Cleanup(func() {
+		removeQueue(t)
+		cfg = saved
+	})
+	if err := createQueue(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// useSettings makes settings current for test
+func useSettings(t *testing.T, s settings) {
+	live.Lock()
+	saved := live.current
+	live.current = s
+	live.Unlock()
+	t.Cleanup(func() {
+		live.Lock()
+		live.current = saved
+		live.Unlock()
+	})
+}
+
+// removeQueue deletes tasks and internal keys of test queue
+func removeQueue(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	if _, err := client.Delete(ctx, cfg.Queue+":", clientv3.WithPrefix()); err != nil {
+		t.Error(err)
+	}
+	resp, err := client.Get(ctx, "__", clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, ev := range resp.Kvs {
+		key := string(ev.Key)
+		if strings.Contains(key, ":"+cfg.Queue+":") || strings.HasSuffix(key, ":"+cfg.Queue) {
+			client.Delete(ctx, key)
+		}
+	}
+}
+
+// taskKeys returns ids of queue tasks
+func taskKeys(t *testing.T) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	resp, err := client.Get(ctx, cfg.Queue+":", clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, ev := range resp.Kvs {
+		ids = append(ids, string(ev.Key)[len(cfg.Queue)+1:])
+	}
+	return ids
+}
+
+// addTask puts task without options and returns its id
+func addTask(t *testing.T, data string) string {
+	t.Helper()
+	code, task, err := putTask(&data, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("put: %v %v", code, err)
+	}
+	return task.ID
+}
+
+// lease gets task for client, group is empty for plain queue
+func lease(t *testing.T, clientID string, group string) *KV {
+	t.Helper()
+	var g *string
+	if group != "" {
+		g = &group
+	}
+	timeout := int64(10)
+	code, task, err := getTask(&clientID, &timeout, g, nil, nil)
+	if err != nil || code != 200 {
+		t.Fatalf("get: %v %v", code, err)
+	}
+	return task
+}
+
+// ack acks task leased by client
+func ack(t *testing.T, clientID string, group string, id string) {
+	t.Helper()
+	var g *string
+	if group != "" {
+		g = &group
+	}
+	if code, _, err := ackTask(&clientID, &id, g, nil, nil, nil, nil, nil); err != nil {
+		t.Fatalf("ack: %v %v", code, err)
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// event feed: leader watches queue keys and each internal prefix of queue, groups changes by revision
+// and turns changes of each revision into task lifecycle events, in revision order.
+// events saved to __event:<queue>:<revision> keys for event-retention and sent to webhooks.
+// saves are append-only, __eventrev:<queue> marks last handled revision, so revision is
+// handled once even if feed restarted or changes of it came late
+
+// Event is a change of task state
+type Event struct {
+	Revision int64 // etcd revision of change
+	Type     string
+	Task     string
+	Group    string `json:",omitempty"`
+	Client   string `json:",omitempty"`
+	Time     string // RFC3339, time event seen by leader
+}
+
+const (
+	eventPut     = "put"
+	eventLeased  = "leased"
+	eventAcked   = "acked"
+	eventNaked   = "naked"
+	eventExpired = "expired" // lease expired or revoked (stream or session closed)
+	eventDead    = "dead"
+)
+
+var eventTypes = []string{eventPut, eventLeased, eventAcked, eventNaked, eventExpired, eventDead}
+
+// max events returned by /events
+const maxEvents = 1000
+
+func eventPrefix() string {
+	return "__event:" + cfg.Queue + ":"
+}
+
+// eventKey sorts by revision, n is index of event in revision
+func eventKey(rev int64, n int) string {
+	return fmt.Sprintf("%s%020d:%04d", eventPrefix(), rev, n)
+}
+
+// eventMarkerKey keeps last handled revision, zero padded to compare as string
+func eventMarkerKey() string {
+	return "__eventrev:" + cfg.Queue
+}
+
+func revisionValue(rev int64) string {
+	return fmt.Sprintf("%020d", rev)
+}
+
+// key kinds of task keys
+const (
+	keyTask     = "task"
+	keyActive   = "active"
+	keyDead     = "dead"
+	keyAttempts = "attempts"
+	keyAcked    = "acked"
+)
+
+var internalKinds = map[string]string{
+	"__active:":   keyActive,
+	"__gactive:":  keyActive,
+	"__dead:":     keyDead,
+	"__attempts:": keyAttempts,
+	"__gacked:":   keyAcked,
+}
+
+// eventPrefixes returns prefixes of queue keys and internal keys of queue
+func eventPrefixes() []string {
+	prefixes := []string{cfg.Queue + ":"}
+	for p := range internalKinds {
+		prefixes = append(prefixes, p+cfg.Queue+":")
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// parseTaskKey returns kind, group and task of key, empty kind if key is not task key of queue
+func parseTaskKey(key string) (string, string, string) {
+	queue := cfg.Queue + ":"
+	if strings.HasPrefix(key, queue) {
+		return keyTask, "", key[len(queue):]
+	}
+	i := strings.Index(key, ":")
+	kind, ok := internalKinds[key[:i+1]]
+	if i < 0 || !ok || !strings.HasPrefix(key[i+1:], queue) {
+		return "", "", ""
+	}
+	// `id` or `group:id` in topic mode
+	id := key[i+1+len(queue):]
+	group := ""
+	if j := strings.LastIndex(id, ":"); j >= 0 {
+		group, id = id[:j], id[j+1:]
+	}
+	return kind, group, id
+}
+
+// taskEvents returns events of one revision
+func taskEvents(evs []*clientv3.Event, now time.Time) []Event {
+	type ref struct{ kind, group, id string }
+	put := make(map[ref]bool)
+	deleted := make(map[ref]bool)
+	clients := make(map[ref]string) // lock owners
+	for _, ev := range evs {
+		kind, group, id := parseTaskKey(string(ev.Kv.Key))
+		r := ref{kind, group, id}
+		if ev.Type == clientv3.EventTypeDelete {
+			deleted[r] = true
+			if kind == keyActive && ev.PrevKv != nil {
+				clients[ref{"", group, id}] = string(ev.PrevKv.Value)
+			}
+		} else {
+			put[r] = true
+		}
+	}
+
+	var events []Event
+	for _, ev := range evs {
+		kind, group, id := parseTaskKey(string(ev.Kv.Key))
+		e := Event{Revision: ev.Kv.ModRevision, Task: id, Group: group, Time: now.Format(time.RFC3339)}
+		switch {
+		case kind == keyTask && ev.IsCreate():
+			e.Type = eventPut
+		case kind == keyActive && ev.IsCreate():
+			e.Type, e.Client = eventLeased, string(ev.Kv.Value)
+		case kind == keyDead && ev.IsCreate():
+			e.Type, e.Client = eventDead, clients[ref{"", group, id}]
+		case kind == keyActive && ev.Type == clientv3.EventTypeDelete:
+			e.Client = clients[ref{"", group, id}]
+			switch {
+			case put[ref{keyDead, group, id}]:
+				continue // dead event
+			case put[ref{keyAcked, group, id}] || (group == "" && deleted[ref{keyTask, "", id}]):
+				e.Type = eventAcked
+			case put[ref{keyAttempts, group, id}]:
+				e.Type = eventNaked
+			default:
+				e.Type = eventExpired
+			}
+		default:
+			continue
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+var eventLeases leaseCache
+
+// saveEvents puts events of revision with retention lease, in txns of maxTxnOps.
+// txn puts only keys not saved yet and last txn moves marker, so handling revision again
+// saves what is left after failure. returns events saved now, to be sent
+func saveEvents(ctx context.Context, rev int64, events []Event) ([]Event, error) {
+	retention := current().EventRetention
+	lease, err := eventLeases.get(ctx, retention, retention/100+time.Second)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to create a lease")
+	}
+	marker := eventMarkerKey()
+	var saved []Event
+	// cmp per event and marker cmp
+	for i := 0; i < len(events); i += maxTxnOps - 1 {
+		j := i + maxTxnOps - 1
+		if j > len(events) {
+			j = len(events)
+		}
+		cmps := []clientv3.Cmp{clientv3.Compare(clientv3.Value(marker), "<", revisionValue(rev))}
+		ops := make([]clientv3.Op, 0, j-i+1)
+		for k := i; k < j; k++ {
+			value, err := json.Marshal(events[k])
+			if err != nil {
+				return nil, err
+			}
+			key := eventKey(rev, k)
+			cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key), "=", 0))
+			ops = append(ops, clientv3.OpPut(key, string(value), clientv3.WithLease(lease)))
+		}
+		if j == len(events) {
+			ops = append(ops, clientv3.OpPut(marker, revisionValue(rev)))
+		}
+		resp, err := client.Txn(ctx).If(cmps...).Then(ops...).Commit()
+		if err != nil {
+			return nil, err
+		}
+		// saved before, or revision handled already
+		if resp.Succeeded {
+			saved = append(saved, events[i:j]...)
+		}
+	}
+	return saved, nil
+}
+
+// lastEventRevision returns last handled revision, marker created with current revision if none
+func lastEventRevision(ctx context.Context) (int64, error) {
+	marker := eventMarkerKey()
+	for {
+		resp, err := client.Get(ctx, marker)
+		if err != nil {
+			return 0, err
+		}
+		if len(resp.Kvs) > 0 {
+			return strconv.ParseInt(string(resp.Kvs[0].Value), 10, 64)
+		}
+		txn, err := client.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(marker), "=", 0)).
+			Then(clientv3.OpPut(marker, revisionValue(resp.Header.Revision))).
+			Commit()
+		if err != nil {
+			return 0, err
+		}
+		if txn.Succeeded {
+			return resp.Header.Revision, nil
+		}
+	}
+}
+
+// eventLoop runs event feed on leader if event-retention set
+func eventLoop() {
+	for {
+		if isLeader() && current().EventRetention > 0 {
+			if err := feedEvents(); err != nil {
+				logger.Warnf("event feed stopped: %v", err)
+			}
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// feedEvents saves and sends events until leadership lost or feed disabled
+func feedEvents() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !isLeader() || current().EventRetention == 0 {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	rev, err := lastEventRevision(ctx)
+	if err != nil {
+		return errors.Wrap(err, "fail to get last event")
+	}
+	logger.WithField("revision", rev).Info("event feed started")
+	for ctx.Err() == nil {
+		if rev, err = watchEvents(ctx, rev); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// time to wait for changes of revision from other watches before revision handled
+const eventDelay = 500 * time.Millisecond
+
+// watchEvents handles changes after rev, returns last handled revision if history compacted.
+// txn may change keys of several prefixes, watches deliver them separately,
+// so changes buffered by revision for eventDelay and handled in revision order
+func watchEvents(ctx context.Context, rev int64) (int64, error) {
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	responses := make(chan clientv3.WatchResponse)
+	for _, p := range eventPrefixes() {
+		wch := client.Watch(wctx, p, clientv3.WithPrefix(), clientv3.WithRev(rev+1), clientv3.WithPrevKV())
+		go func() {
+			// all watches restarted if one closed
+			defer cancel()
+			for wr := range wch {
+				select {
+				case responses <- wr:
+				case <-wctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	type changes struct {
+		evs  []*clientv3.Event
+		seen time.Time
+	}
+	pending := make(map[int64]*changes)
+	ticker := time.NewTicker(eventDelay / 5)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-wctx.Done():
+			if ctx.Err() == nil {
+				return rev, errors.New("watch closed")
+			}
+			return rev, ctx.Err()
+		case wr := <-responses:
+			if wr.CompactRevision != 0 {
+				logger.Warnf("events before revision %v lost, compacted", wr.CompactRevision)
+				return wr.CompactRevision - 1, nil
+			}
+			if err := wr.Err(); err != nil {
+				return rev, err
+			}
+			for _, ev := range wr.Events {
+				r := ev.Kv.ModRevision
+				if r <= rev {
+					// events of revision saved already, can't tell them from partial set
+					logger.WithField("revision", r).Warn("changes came after revision handled, skipped")
+					continue
+				}
+				c, ok := pending[r]
+				if !ok {
+					c = &changes{seen: time.Now()}
+					pending[r] = c
+				}
+				c.evs = append(c.evs, ev)
+			}
+		case now := <-ticker.C:
+			revs := make([]int64, 0, len(pending))
+			for r := range pending {
+				revs = append(revs, r)
+			}
+			sort.Slice(revs, func(i, j int) bool { return revs[i] < revs[j] })
+			for _, r := range revs {
+				if now.Sub(pending[r].seen) < eventDelay {
+					break
+				}
+				if err := handleRevision(wctx, r, pending[r].evs); err != nil {
+					return rev, err
+				}
+				delete(pending, r)
+				if r > rev {
+					rev = r
+				}
+			}
+		}
+	}
+}
+
+// handleRevision saves and sends events of changes of one revision, events saved before not sent again
+func handleRevision(ctx context.Context, rev int64, evs []*clientv3.Event) error {
+	// same order of events if revision handled again
+	sort.Slice(evs, func(i, j int) bool { return string(evs[i].Kv.Key) < string(evs[j].Kv.Key) })
+	events := taskEvents(evs, time.Now().UTC())
+	if len(events) == 0 {
+		return nil
+	}
+	saved, err := saveEvents(ctx, rev, events)
+	if err != nil {
+		return errors.Wrap(err, "fail to save events")
+	}
+	if len(saved) < len(events) {
+		logger.WithField("revision", rev).Debugf("%d events of revision saved before", len(events)-len(saved))
+	}
+	sendEvents(saved)
+	return nil
+}
+
+func listEvents(sinceRevision *int64, limit *int64) (int, *[]Event, error) {
+	if current().EventRetention == 0 {
+		return http.StatusNotFound, nil, fmt.Errorf("event feed disabled, event-retention not set")
+	}
+	var since int64
+	if sinceRevision != nil {
+		since = *sinceRevision
+	}
+	n := int64(100)
+	if limit != nil && *limit < maxEvents {
+		n = *limit
+	} else if limit != nil {
+		n = maxEvents
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	resp, err := client.Get(ctx, eventKey(since+1, 0), clientv3.WithRange(clientv3.GetPrefixRangeEnd(eventPrefix())),
+		clientv3.WithLimit(n))
+	if err != nil {
+		return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to get events")
+	}
+	result := make([]Event, len(resp.Kvs))
+	for i, ev := range resp.Kvs {
+		if err = json.Unmarshal(ev.Value, &result[i]); err != nil {
+			return http.StatusInternalServerError, nil, errors.Wrapf(err, "bad event %s", ev.Key)
+		}
+	}
+	return http.StatusOK, &result, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestTaskEvents(t *testing.T) {
+	assert := assert.New(t)
+	defer func(q string) { cfg.Queue = q }(cfg.Queue)
+	cfg.Queue = "q"
+
+	kind, group, id := parseTaskKey("__gactive:q:a:1")
+	assert.Equal([]string{keyActive, "a", "1"}, []string{kind, group, id})
+	kind, _, id = parseTaskKey("q:1")
+	assert.Equal([]string{keyTask, "1"}, []string{kind, id})
+	kind, _, _ = parseTaskKey("__active:q2:1")
+	assert.Empty(kind)
+	kind, _, _ = parseTaskKey("__event:q:1")
+	assert.Empty(kind)
+
+	put := func(key string, value string, rev int64, create bool) *clientv3.Event {
+		kv := &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value), ModRevision: rev, Version: 2}
+		if create {
+			kv.CreateRevision, kv.Version = rev, 1
+		}
+		return &clientv3.Event{Type: clientv3.EventTypePut, Kv: kv}
+	}
+	del := func(key string, prev string, rev int64) *clientv3.Event {
+		return &clientv3.Event{Type: clientv3.EventTypeDelete, Kv: &mvccpb.KeyValue{Key: []byte(key), ModRevision: rev},
+			PrevKv: &mvccpb.KeyValue{Key: []byte(key), Value: []byte(prev)}}
+	}
+	types := func(evs ...*clientv3.Event) []string {
+		var r []string
+		for _, e := range taskEvents(evs, time.Now()) {
+			r = append(r, e.Type+":"+e.Task+":"+e.Client)
+		}
+		return r
+	}
+
+	assert.Equal([]string{"put:1:"}, types(put("q:1", "x", 5, true)))
+	assert.Empty(types(put("q:1", "y", 5, false)))
+	assert.Equal([]string{"leased:1:w1"}, types(put("__active:q:1", "w1", 6, true)))
+	assert.Equal([]string{"acked:1:w1"}, types(del("__active:q:1", "w1", 7), del("q:1", "", 7)))
+	assert.Equal([]string{"naked:1:w1"}, types(del("__active:q:1", "w1", 7), put("__attempts:q:1", "1", 7, true)))
+	assert.Equal([]string{"expired:1:w1"}, types(del("__active:q:1", "w1", 7)))
+	assert.Equal([]string{"dead:1:w1"}, types(del("__active:q:1", "w1", 7), del("q:1", "", 7), put("__dead:q:1", "x", 7, true)))
+	// topic mode: task kept, ack marked for group
+	assert.Equal([]string{"acked:1:w1"}, types(del("__gactive:q:a:1", "w1", 8), put("__gacked:q:a:1", "", 8, true)))
+}
+
+func TestEventFeed(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	useSettings(t, settings{LogLevel: "info", Limit: 100, EventRetention: time.Minute})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rev, err := lastEventRevision(ctx)
+	assert.Nil(err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watchEvents(ctx, rev)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// more events in one revision than ops in one txn: leases of closed session revoked together
+	grant, err := client.Grant(ctx, 60)
+	assert.Nil(err)
+	n := maxTxnOps + 2
+	for i := 0; i < n; i += maxTxnOps / 2 {
+		var ops []clientv3.Op
+		for j := i; j < n && j < i+maxTxnOps/2; j++ {
+			ops = append(ops, clientv3.OpPut(fmt.Sprintf("%s%04d", activePrefix(""), j), "w", clientv3.WithLease(grant.ID)))
+		}
+		_, err = client.Txn(ctx).Then(ops...).Commit()
+		assert.Nil(err)
+	}
+	revoked, err := client.Revoke(ctx, grant.ID)
+	assert.Nil(err)
+	id := addTask(t, "next")
+
+	var events []Event
+	since, limit := revoked.Header.Revision-1, int64(maxEvents)
+	for deadline := time.Now().Add(5 * time.Second); len(events) < n+1 && time.Now().Before(deadline); {
+		time.Sleep(100 * time.Millisecond)
+		_, list, err := listEvents(&since, &limit)
+		assert.Nil(err)
+		events = *list
+	}
+	if !assert.Len(events, n+1) {
+		return
+	}
+	for i, e := range events[:n] {
+		assert.Equal(Event{Revision: revoked.Header.Revision, Type: eventExpired, Task: fmt.Sprintf("%04d", i), Client: "w", Time: e.Time}, e)
+	}
+	last := events[n]
+	assert.Equal(eventPut, last.Type)
+	assert.Equal(id, last.Task)
+	saved, err := lastEventRevision(ctx)
+	assert.Nil(err)
+	assert.Equal(last.Revision, saved)
+
+	// revision saved partly before failure: only rest saved and returned for webhooks
+	rev = last.Revision + 100
+	full := make([]Event, maxTxnOps+10)
+	for i := range full {
+		full[i] = Event{Revision: rev, Type: eventPut, Task: fmt.Sprint(i)}
+	}
+	_, err = client.Put(ctx, eventKey(rev, 0), "saved")
+	assert.Nil(err)
+	sent, err := saveEvents(ctx, rev, full)
+	assert.Nil(err)
+	assert.Equal(full[maxTxnOps-1:], sent)
+	saved, err = lastEventRevision(ctx)
+	assert.Nil(err)
+	assert.Equal(rev, saved)
+
+	// handled revision and late changes of older one not saved again
+	sent, err = saveEvents(ctx, rev, full)
+	assert.Nil(err)
+	assert.Empty(sent)
+	sent, err = saveEvents(ctx, rev-1, full[:1])
+	assert.Nil(err)
+	assert.Empty(sent)
+	resp, err := client.Get(ctx, eventKey(rev-1, 0), clientv3.WithCountOnly())
+	assert.Nil(err)
+	assert.Zero(resp.Count)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// fencing token is create revision of active key: every lease of a task gets bigger token
+// than previous ones. worker passes token to renew/ack/nak and to downstream systems,
+// they keep max token seen and reject writes with smaller one
+
+// ownerCmps returns conditions for client to own running task, token checked if set
+func ownerCmps(key string, clientID string, token *int64) []clientv3.Cmp {
+	cmps := []clientv3.Cmp{clientv3.Compare(clientv3.Value(key), "=", clientID)}
+	if token != nil {
+		cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key), "=", *token))
+	}
+	return cmps
+}
+
+// owned returns true if active key as of failed check is still held by client,
+// so other conditions of txn failed
+func owned(active []*mvccpb.KeyValue, clientID string, token *int64) bool {
+	return len(active) > 0 && string(active[0].Value) == clientID && (token == nil || active[0].CreateRevision == *token)
+}
+
+// notOwned returns error for failed owner check, `active` is active key as of check.
+// lease taken over by other worker is conflict if client set token
+func notOwned(active []*mvccpb.KeyValue, taskID string, token *int64) (int, error) {
+	if token != nil && len(active) > 0 && active[0].CreateRevision != *token {
+		return http.StatusConflict, fmt.Errorf("stale token %d for task %v, current %d", *token, taskID, active[0].CreateRevision)
+	}
+	return http.StatusNotFound, fmt.Errorf("task %v not running", taskID)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestRenewFencing(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	id := addTask(t, "x")
+	first := lease(t, "w", "")
+
+	w, w2, progress := "w", "w2", "50%"
+	code, err := renewTask(&w, &id, nil, &progress, &first.Token, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	stale := first.Token - 1
+	code, _ = renewTask(&w, &id, nil, nil, &stale, nil)
+	assert.Equal(http.StatusConflict, code)
+	code, _ = renewTask(&w2, &id, nil, nil, nil, nil)
+	assert.Equal(http.StatusConflict, code)
+
+	// lease expired, task leased again by same client: old token rejected
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	resp, err := client.Get(ctx, activePrefix("")+id)
+	assert.Nil(err)
+	_, err = client.Revoke(ctx, clientv3.LeaseID(resp.Kvs[0].Lease))
+	assert.Nil(err)
+	second := lease(t, "w", "")
+	assert.Equal(id, second.ID)
+	code, _ = renewTask(&w, &id, nil, &progress, &first.Token, nil)
+	assert.Equal(http.StatusConflict, code)
+
+	// progress of old lease expired with it, new one not saved if lease changed between get and save
+	update := "90%"
+	owned, active, err := saveProgress(ctx, "", id, w, first.Token, &update, clientv3.LeaseID(resp.Kvs[0].Lease))
+	assert.Nil(err)
+	assert.False(owned)
+	assert.Equal(second.Token, active[0].CreateRevision)
+	owned, _, err = saveProgress(ctx, "", id, w2, second.Token, nil, 0)
+	assert.Nil(err)
+	assert.False(owned)
+
+	assert.Equal(map[string]string{id: ""}, dumped(t))
+
+	code, _ = renewTask(&w, &id, nil, &update, &second.Token, nil)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(map[string]string{id: update}, dumped(t))
+}
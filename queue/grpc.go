@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"ogogo.com/queue/queuepb"
+)
+
+// grpc api: same operations as http handlers, empty proto fields are missing params
+
+type grpcServer struct {
+	queuepb.UnimplementedQueueServer
+}
+
+func startGRPC() {
+	lis, err := net.Listen("tcp", cfg.GRPCAddr)
+	if err != nil {
+		logger.Fatalf("cant listen grpc: %v", err)
+	}
+	s := grpc.NewServer()
+	queuepb.RegisterQueueServer(s, grpcServer{})
+	logger.Infof("start grpc api at %v", cfg.GRPCAddr)
+	if err := s.Serve(lis); err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+// grpcCode maps http status of operation to grpc code
+func grpcCode(code int) codes.Code {
+	switch code {
+	case http.StatusOK, http.StatusNoContent:
+		return codes.OK
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusTooManyRequests, http.StatusInsufficientStorage:
+		return codes.ResourceExhausted
+	case http.StatusGone:
+		return codes.FailedPrecondition
+	}
+	return codes.Internal
+}
+
+// grpcError logs error of operation and returns it as grpc status, nil on success
+func grpcError(method string, code int, err error) error {
+	c := grpcCode(code)
+	if c == codes.OK {
+		return nil
+	}
+	if err == nil {
+		err = fmt.Errorf("%v", http.StatusText(code))
+	}
+	logger.WithFields(log.Fields{"method": method, "code": code}).Warn(err)
+	return status.Error(c, err.Error())
+}
+
+// requestID is x-request-id from call metadata, nil if not passed
+func requestID(ctx context.Context) *string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get("x-request-id"); len(v) > 0 {
+		return &v[0]
+	}
+	return nil
+}
+
+func optString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func optStrings(s []string) *[]string {
+	if len(s) == 0 {
+		return nil
+	}
+	return &s
+}
+
+func optInt(i int64) *int64 {
+	if i == 0 {
+		return nil
+	}
+	return &i
+}
+
+func required(names ...string) error {
+	for i := 0; i < len(names); i += 2 {
+		if names[i+1] == "" {
+			return status.Errorf(codes.InvalidArgument, "no required param %v", names[i])
+		}
+	}
+	return nil
+}
+
+func pbTask(t *KV) *queuepb.Task {
+	return &queuepb.Task{
+		Id:           t.ID,
+		Value:        t.Value,
+		Progress:     t.Progress,
+		Trace:        t.Trace,
+		Token:        t.Token,
+		MessageGroup: t.MessageGroup,
+		Queue:        t.Queue,
+		Duplicate:    t.Duplicate,
+	}
+}
+
+func (grpcServer) Put(ctx context.Context, r *queuepb.PutRequest) (*queuepb.Task, error) {
+	var old, state *string
+	if r.Cas {
+		old, state = &r.Old, &r.State
+	}
+	if r.MaxTime < 0 || r.MaxRenew < 0 {
+		return nil, status.Error(codes.InvalidArgument, "bad param max_time or max_renew")
+	}
+	code, t, err := putTask(&r.Data, old, state, optStrings(r.Parents), optString(r.MessageGroup),
+		optStrings(r.Require), optString(r.DedupKey), optInt(r.MaxTime), optInt(r.MaxRenew), optString(r.Traceparent), requestID(ctx))
+	if err = grpcError("Put", code, err); err != nil {
+		return nil, err
+	}
+	return pbTask(t), nil
+}
+
+func (grpcServer) Get(ctx context.Context, r *queuepb.GetRequest) (*queuepb.GetResponse, error) {
+	if err := required("client_id", r.ClientId); err != nil {
+		return nil, err
+	}
+	if r.Timeout < 0 || r.Timeout == 0 && r.Session == "" {
+		return nil, status.Error(codes.InvalidArgument, "bad param timeout")
+	}
+	code, t, err := getTask(&r.ClientId, optInt(r.Timeout), optString(r.Group), optString(r.Session), requestID(ctx))
+	if err = grpcError("Get", code, err); err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return &queuepb.GetResponse{}, nil
+	}
+	return &queuepb.GetResponse{Task: pbTask(t)}, nil
+}
+
+func (grpcServer) Renew(ctx context.Context, r *queuepb.RenewRequest) (*queuepb.Empty, error) {
+	if err := required("client_id", r.ClientId, "task_id", r.TaskId); err != nil {
+		return nil, err
+	}
+	code, err := renewTask(&r.ClientId, &r.TaskId, optString(r.Group), optString(r.Progress), optInt(r.Token), requestID(ctx))
+	return &queuepb.Empty{}, grpcError("Renew", code, err)
+}
+
+func (grpcServer) Ack(ctx context.Context, r *queuepb.AckRequest) (*queuepb.AckResponse, error) {
+	if err := required("client_id", r.ClientId, "task_id", r.TaskId); err != nil {
+		return nil, err
+	}
+	code, next, err := ackTask(&r.ClientId, &r.TaskId, optString(r.Group), optStrings(r.Next),
+		optString(r.Result), optInt(r.Token), optString(r.Traceparent), requestID(ctx))
+	if err = grpcError("Ack", code, err); err != nil {
+		return nil, err
+	}
+	resp := &queuepb.AckResponse{}
+	if next != nil {
+		for i := range *next {
+			resp.Next = append(resp.Next, pbTask(&(*next)[i]))
+		}
+	}
+	return resp, nil
+}
+
+func (grpcServer) Nak(ctx context.Context, r *queuepb.NakRequest) (*queuepb.Empty, error) {
+	if err := required("client_id", r.ClientId, "task_id", r.TaskId); err != nil {
+		return nil, err
+	}
+	code, err := nakTask(&r.ClientId, &r.TaskId, optString(r.Group), optInt(r.Token), requestID(ctx))
+	return &queuepb.Empty{}, grpcError("Nak", code, err)
+}
+
+func (grpcServer) GetState(ctx context.Context, r *queuepb.StateRequest) (*queuepb.State, error) {
+	code, s, err := getState()
+	if err = grpcError("GetState", code, err); err != nil {
+		return nil, err
+	}
+	return &queuepb.State{State: s.State, Revision: s.Revision, Paused: s.Paused}, nil
+}
+
+func (grpcServer) OpenSession(ctx context.Context, r *queuepb.OpenSessionRequest) (*queuepb.Session, error) {
+	if err := required("client_id", r.ClientId); err != nil {
+		return nil, err
+	}
+	if r.Ttl < 1 || r.Hold < 0 {
+		return nil, status.Error(codes.InvalidArgument, "bad param ttl or hold")
+	}
+	code, s, err := openSession(&r.ClientId, &r.Ttl, optInt(r.Hold))
+	if err = grpcError("OpenSession", code, err); err != nil {
+		return nil, err
+	}
+	return &queuepb.Session{Id: s.ID, ClientId: s.ClientID, Ttl: s.TTL, Hold: s.Hold}, nil
+}
+
+func (grpcServer) KeepaliveSession(ctx context.Context, r *queuepb.SessionRequest) (*queuepb.Empty, error) {
+	if err := required("client_id", r.ClientId, "session", r.Session); err != nil {
+		return nil, err
+	}
+	code, err := keepaliveSession(&r.ClientId, &r.Session)
+	return &queuepb.Empty{}, grpcError("KeepaliveSession", code, err)
+}
+
+func (grpcServer) CloseSession(ctx context.Context, r *queuepb.SessionRequest) (*queuepb.Empty, error) {
+	if err := required("client_id", r.ClientId, "session", r.Session); err != nil {
+		return nil, err
+	}
+	code, err := closeSession(&r.ClientId, &r.Session)
+	return &queuepb.Empty{}, grpcError("CloseSession", code, err)
+}
+
+// consumeResponse converts stream message, Value is task data or error message
+func consumeResponse(m StreamMsg) *queuepb.ConsumeResponse {
+	if m.Op == "task" {
+		return &queuepb.ConsumeResponse{Op: m.Op, Task: &queuepb.Task{Id: m.ID, Value: m.Value, Trace: m.Trace, Token: m.Token}}
+	}
+	return &queuepb.ConsumeResponse{Op: m.Op, Id: m.ID, Code: int32(m.Code), Error: m.Value}
+}
+
+// Consume runs stream as websocket one, first request must be `open`
+func (grpcServer) Consume(srv queuepb.Queue_ConsumeServer) error {
+	open, err := srv.Recv()
+	if err != nil {
+		return err
+	}
+	if open.Op != "open" {
+		return status.Error(codes.InvalidArgument, "first request must be open")
+	}
+	prefetch := int(open.Prefetch)
+	if prefetch == 0 {
+		prefetch = 1
+	}
+	s, err := newStream(open.ClientId, open.Timeout, prefetch, optString(open.Group))
+	if err != nil {
+		logger.WithField("method", "Consume").Warn(err)
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	s.send = func(m StreamMsg) error {
+		return srv.Send(consumeResponse(m))
+	}
+
+	incoming := make(chan StreamMsg)
+	go func() {
+		defer close(incoming)
+		for {
+			r, err := srv.Recv()
+			if err != nil {
+				return
+			}
+			select {
+			case incoming <- StreamMsg{Op: r.Op, ID: r.Id, Token: r.Token}:
+			case <-srv.Context().Done():
+				return
+			}
+		}
+	}()
+	s.run(incoming)
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"ogogo.com/queue/queuepb"
+)
+
+func TestConsumeResponse(t *testing.T) {
+	assert := assert.New(t)
+	task := consumeResponse(StreamMsg{Op: "task", ID: "1", Value: "data", Token: 7})
+	assert.True(proto.Equal(&queuepb.ConsumeResponse{Op: "task", Task: &queuepb.Task{Id: "1", Value: "data", Token: 7}}, task))
+	ack := consumeResponse(StreamMsg{Op: "ack", ID: "1", Code: http.StatusOK})
+	assert.True(proto.Equal(&queuepb.ConsumeResponse{Op: "ack", Id: "1", Code: http.StatusOK}, ack))
+	failed := consumeResponse(StreamMsg{Op: "error", Value: "bad param timeout", Code: http.StatusBadRequest})
+	assert.Equal("bad param timeout", failed.Error)
+	assert.Equal(int32(http.StatusBadRequest), failed.Code)
+}
+
+// grpcClient runs grpc server in memory, stopped after test
+func grpcClient(t *testing.T) queuepb.QueueClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	queuepb.RegisterQueueServer(s, grpcServer{})
+	go s.Serve(lis)
+	conn, err := grpc.Dial("bufconn", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		s.Stop()
+	})
+	return queuepb.NewQueueClient(conn)
+}
+
+func TestGRPC(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	c := grpcClient(t)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "req-1")
+
+	put, err := c.Put(ctx, &queuepb.PutRequest{Data: "task"})
+	assert.Nil(err)
+	got, err := c.Get(ctx, &queuepb.GetRequest{ClientId: "w", Timeout: 10})
+	assert.Nil(err)
+	assert.Equal(put.Id, got.Task.Id)
+	assert.Equal("task", got.Task.Value)
+	assert.NotZero(got.Task.Token)
+
+	// empty response if no task
+	empty, err := c.Get(ctx, &queuepb.GetRequest{ClientId: "w2", Timeout: 10})
+	assert.Nil(err)
+	assert.Nil(empty.Task)
+
+	// http status of operation mapped to grpc code
+	_, err = c.Get(ctx, &queuepb.GetRequest{ClientId: "w", Timeout: 10})
+	assert.Equal(codes.Aborted, status.Code(err))
+	_, err = c.Get(ctx, &queuepb.GetRequest{Timeout: 10})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+	_, err = c.Get(ctx, &queuepb.GetRequest{ClientId: "w", Timeout: 10, Group: "a"})
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+	_, err = c.Ack(ctx, &queuepb.AckRequest{ClientId: "w", TaskId: got.Task.Id, Token: got.Task.Token + 1})
+	assert.Equal(codes.Aborted, status.Code(err))
+
+	ack, err := c.Ack(ctx, &queuepb.AckRequest{ClientId: "w", TaskId: got.Task.Id, Token: got.Task.Token, Next: []string{"next"}})
+	assert.Nil(err)
+	assert.Len(ack.Next, 1)
+	_, err = c.Ack(ctx, &queuepb.AckRequest{ClientId: "w", TaskId: got.Task.Id})
+	assert.Equal(codes.NotFound, status.Code(err))
+
+	useSettings(t, settings{LogLevel: "info", Limit: 100, MaxPending: 1})
+	_, err = c.Put(ctx, &queuepb.PutRequest{Data: "over"})
+	assert.Equal(codes.ResourceExhausted, status.Code(err))
+}
+
+func TestGRPCConsume(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	c := grpcClient(t)
+	id := addTask(t, "task")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := c.Consume(ctx)
+	assert.Nil(err)
+	assert.Nil(s.Send(&queuepb.ConsumeRequest{Op: "open", ClientId: "w", Timeout: 10}))
+	m, err := s.Recv()
+	if !assert.Nil(err) {
+		return
+	}
+	assert.Equal("task", m.Op)
+	assert.Equal(id, m.Task.Id)
+	assert.Nil(s.Send(&queuepb.ConsumeRequest{Op: "ack", Id: id, Token: m.Task.Token}))
+	m, err = s.Recv()
+	assert.Nil(err)
+	assert.True(proto.Equal(&queuepb.ConsumeResponse{Op: "ack", Id: id, Code: http.StatusOK}, m))
+	// ack of task not held by stream
+	assert.Nil(s.Send(&queuepb.ConsumeRequest{Op: "ack", Id: id}))
+	m, err = s.Recv()
+	assert.Nil(err)
+	assert.Equal(int32(http.StatusNotFound), m.Code)
+	assert.Nil(s.CloseSend())
+	assert.Empty(taskKeys(t))
+
+	// stream must start with open, params checked
+	for _, open := range []*queuepb.ConsumeRequest{{Op: "ack", Id: id}, {Op: "open", ClientId: "w"}} {
+		s, err = c.Consume(ctx)
+		assert.Nil(err)
+		assert.Nil(s.Send(open))
+		_, err = s.Recv()
+		assert.Equal(codes.InvalidArgument, status.Code(err))
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// one replica is elected to run periodic jobs.
+// leader key is kept with lease, other replicas wait for it deletion
+
+// leader lease ttl in seconds, new leader elected after this time if leader died
+const leaderTTL = 10
+
+var leader int32
+
+func leaderKey() string {
+	return "__leader:" + cfg.Queue
+}
+
+func isLeader() bool {
+	return atomic.LoadInt32(&leader) == 1
+}
+
+// campaign runs election forever
+func campaign() {
+	name, _ := os.Hostname()
+	for {
+		err := lead(name)
+		logger.Warnf("leadership lost: %v", err)
+		time.Sleep(time.Second)
+	}
+}
+
+// lead waits to became a leader and returns when leadership lost
+func lead(name string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, leaderTTL)
+	if err != nil {
+		return err
+	}
+	defer client.Revoke(context.Background(), lease.ID)
+	alive, err := client.KeepAlive(ctx, lease.ID)
+	if err != nil {
+		return err
+	}
+
+	for {
+		resp, err := client.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(leaderKey()), "=", 0)).
+			Then(clientv3.OpPut(leaderKey(), name, clientv3.WithLease(lease.ID))).
+			Commit()
+		if err != nil {
+			return err
+		}
+		if resp.Succeeded {
+			break
+		}
+		// wait for current leader to go away
+		rev := resp.Header.Revision
+		if err = waitDelete(ctx, leaderKey(), rev, alive); err != nil {
+			return err
+		}
+	}
+
+	atomic.StoreInt32(&leader, 1)
+	defer atomic.StoreInt32(&leader, 0)
+	logger.WithField("name", name).Info("elected as leader")
+
+	for range alive {
+	}
+	return errors.New("lease expired")
+}
+
+// waitDelete waits for key deletion after revision while keeping lease alive
+func waitDelete(ctx context.Context, key string, rev int64, alive <-chan *clientv3.LeaseKeepAliveResponse) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	wch := client.Watch(ctx, key, clientv3.WithRev(rev+1), clientv3.WithFilterPut())
+	for {
+		select {
+		case <-wch:
+			return nil
+		case _, ok := <-alive:
+			if !ok {
+				return errors.New("lease expired")
+			}
+		}
+	}
+}
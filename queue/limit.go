@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.etcd.io/etcd/client/v3"
+)
+
+// dispatch limits shared by all replicas: token bucket and active leases are
+// checked in same txn as task lease, so concurrent get from other replica fails the txn
+
+// retry hint if no lease slots available, we don't know when lease expire
+const activeRetry = time.Second
+
+func bucketKey() string {
+	return "__bucket:" + cfg.Queue
+}
+
+// retryError asks client to retry later
+type retryError struct {
+	error
+	after time.Duration
+}
+
+func (e retryError) Header() http.Header {
+	return http.Header{"Retry-After": []string{strconv.Itoa(int(math.Ceil(e.after.Seconds())))}}
+}
+
+// refill returns tokens in bucket after elapsed time
+func refill(tokens float64, elapsed time.Duration, rate float64, burst float64) float64 {
+	if elapsed > 0 {
+		tokens += elapsed.Seconds() * rate
+	}
+	return math.Min(tokens, burst)
+}
+
+// tokenWait returns time to wait for one token
+func tokenWait(tokens float64, rate float64) time.Duration {
+	if tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - tokens) / rate * float64(time.Second))
+}
+
+func burst(s settings) float64 {
+	if s.Burst > 0 {
+		return s.Burst
+	}
+	return math.Max(s.Rate, 1)
+}
+
+// checkActive ensures no more than max-active leases, count of leases read at revision `rev`
+func checkActive(c *candidate, group string, count int, rev int64) (int, error) {
+	max := current().MaxActive
+	if max == 0 {
+		return http.StatusOK, nil
+	}
+	if count >= max {
+		return http.StatusTooManyRequests, retryError{fmt.Errorf("max active tasks reached"), activeRetry}
+	}
+	// new lease by someone else after we counted
+	c.cmps = append(c.cmps, clientv3.Compare(clientv3.ModRevision(activePrefix(group)), "<", rev+1).WithPrefix())
+	return http.StatusOK, nil
+}
+
+// takeToken takes one token from dispatch bucket
+func takeToken(ctx context.Context, c *candidate) (int, error) {
+	s := current()
+	if s.Rate == 0 {
+		return http.StatusOK, nil
+	}
+	resp, err := client.Get(ctx, bucketKey())
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("fail to get bucket")
+	}
+
+	now := time.Now()
+	tokens := burst(s)
+	if len(resp.Kvs) > 0 {
+		var last int64
+		if _, err := fmt.Sscanf(string(resp.Kvs[0].Value), "%g:%d", &tokens, &last); err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("bad bucket %s", resp.Kvs[0].Value)
+		}
+		tokens = refill(tokens, now.Sub(time.Unix(0, last)), s.Rate, burst(s))
+		c.cmps = append(c.cmps, clientv3.Compare(clientv3.ModRevision(bucketKey()), "=", resp.Kvs[0].ModRevision))
+	} else {
+		c.cmps = append(c.cmps, clientv3.Compare(clientv3.CreateRevision(bucketKey()), "=", 0))
+	}
+
+	if wait := tokenWait(tokens, s.Rate); wait > 0 {
+		return http.StatusTooManyRequests, retryError{fmt.Errorf("rate limit reached"), wait}
+	}
+	c.ops = append(c.ops, clientv3.OpPut(bucketKey(), fmt.Sprintf("%g:%d", tokens-1, now.UnixNano())))
+	return http.StatusOK, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefill(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(1.5, refill(0.5, time.Second, 1, 5))
+	assert.Equal(5.0, refill(4, time.Minute, 1, 5))
+	assert.Equal(2.0, refill(2, -time.Second, 1, 5)) // clock skew between replicas
+}
+
+func TestTokenWait(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(time.Duration(0), tokenWait(1, 10))
+	assert.Equal(500*time.Millisecond, tokenWait(0.5, 1))
+	assert.Equal(100*time.Millisecond, tokenWait(0, 10))
+}
+
+func TestCheckActive(t *testing.T) {
+	assert := assert.New(t)
+
+	useSettings(t, settings{})
+	c := &candidate{}
+	code, err := checkActive(c, "", 5, 10)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Empty(c.cmps) // not limited
+
+	useSettings(t, settings{MaxActive: 2})
+	code, err = checkActive(c, "a", 1, 10)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Len(c.cmps, 1) // no lease after count
+
+	c = &candidate{}
+	code, err = checkActive(c, "a", 2, 10)
+	assert.Equal(http.StatusTooManyRequests, code)
+	assert.Equal(http.Header{"Retry-After": []string{"1"}}, err.(HeaderError).Header())
+	assert.Empty(c.cmps)
+}
+
+// getHTTP gets task via api handler
+func getHTTP(r http.Handler, clientID string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/get?timeout=10&client_id="+clientID, nil))
+	return w
+}
+
+func TestRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	useSettings(t, settings{LogLevel: "info", Limit: 100, Rate: 0.5, Burst: 1})
+	r := CreateRouter(logger, funcHandler{})
+	addTask(t, "first")
+	addTask(t, "second")
+
+	assert.Equal(http.StatusOK, getHTTP(r, "w1").Code)
+	w := getHTTP(r, "w2")
+	assert.Equal(http.StatusTooManyRequests, w.Code)
+	// token refilled in 2s
+	assert.Contains([]string{"1", "2"}, w.Header().Get("Retry-After"))
+	assert.Equal(int64(1), keyCount(t, activePrefix("")))
+}
+
+func TestMaxActive(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	useSettings(t, settings{LogLevel: "info", Limit: 100, MaxActive: 1})
+	r := CreateRouter(logger, funcHandler{})
+	addTask(t, "first")
+	addTask(t, "second")
+
+	task := lease(t, "w1", "")
+	w := getHTTP(r, "w2")
+	assert.Equal(http.StatusTooManyRequests, w.Code)
+	assert.Equal("1", w.Header().Get("Retry-After"))
+
+	// slot freed by ack
+	ack(t, "w1", "", task.ID)
+	assert.Equal(http.StatusOK, getHTTP(r, "w2").Code)
+}
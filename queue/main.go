@@ -1,40 +1,18 @@
+//go:generate go run ./swagger/gen -spec swagger/urykhy1-queue-1.0.0-openapi.yaml -server api.go -funcs -client client/client.go
+
 package main
 
 import (
-	"io/ioutil"
+	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"time"
 
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v2"
 )
 
-type config struct {
-	Queue    string `yaml:"queue"`
-	Etcd     string `yaml:"etcd"`
-	Addr     string `yaml:"addr"`
-	LogLevel string `yaml:"log-level"`
-	Limit    int64  `yaml:"client-limit"`
-}
-
-func (c *config) getConf(filename string) error {
-	f, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return err
-	}
-	err = yaml.Unmarshal(f, c)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 func getLogger() *log.Logger {
-	level, err := log.ParseLevel(cfg.LogLevel)
-	if err != nil {
-		log.Fatalf("bad log level: %v", err)
-	}
 	logger := &log.Logger{
 		Out:       os.Stderr,
 		Formatter: new(log.JSONFormatter),
@@ -42,37 +20,60 @@ func getLogger() *log.Logger {
 			TimestampFormat: "2006-01-02 15:04:05",
 			FullTimestamp:   true,
 		},*/
-		Level: level,
+		Level: log.InfoLevel,
 	}
 	return logger
 }
 
-func getConfig() config {
-	var c config
-	err := c.getConf("queue.yml")
-	if err != nil {
-		log.Fatalf("can't read configuration: %v", err)
-	}
-	return c
-}
-
 var logger = log.New()
-var cfg = getConfig()
+var cfg config // settings are read with current()
 
 func main() {
+	configFile := flag.String("config", "queue.yml", "config file")
+	flag.Parse()
+	c, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	cfg = *c
+
 	logger = getLogger()
+	applySettings(cfg.Settings, *configFile)
 	logger.Infof("starting queue %s with %s backend", cfg.Queue, cfg.Etcd)
 
-	err := openEtcd()
+	err = openEtcd()
 	if err != nil {
 		logger.Fatalf("cant create connection to etcd: %v", err)
 	}
+	initSettings(cfg.Settings)
+	go reloadLoop(*configFile)
+	go watchPause()
+	go deadlineLoop()
+	go eventLoop()
+	if isTopic() {
+		go retentionLoop()
+	}
+	if err := addCronJobs(); err != nil {
+		logger.Fatalf("cant add cron jobs: %v", err)
+	}
+	go campaign()
+	go sampleLoop()
+	startCron()
+	if cfg.GRPCAddr != "" {
+		go startGRPC()
+	}
 
-	r := CreateRouter(logger)
+	r := CreateRouter(logger, funcHandler{})
+	AddAccessLog(r)
+	AddAPIValidation(r)
+	AddStreamRoute(r)
+	AddBackupRoute(r)
+	AddDashboardRoute(r)
 	logger.Infof("start api at %v", cfg.Addr)
 	server := &http.Server{
 		Addr:         cfg.Addr,
-		WriteTimeout: time.Second * 5,
+		WriteTimeout: time.Second*5 + maxPoll,
 		ReadTimeout:  time.Second * 5,
 		IdleTimeout:  time.Second * 5,
 		Handler:      r, // Pass our instance of gorilla/mux in.
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.etcd.io/etcd/client/v3"
+)
+
+// taskMeta is optional task attributes, stored only if task have any
+type taskMeta struct {
+	Parents []string `json:",omitempty"`
+	Trace   string   `json:",omitempty"`
+	Group   string   `json:",omitempty"` // message group, leased one by one in id order
+	Require []string `json:",omitempty"` // worker tags
+
+	MaxTime  int64 `json:",omitempty"` // seconds since first lease, see deadline
+	MaxRenew int64 `json:",omitempty"` // renews over all leases
+}
+
+// newMeta returns metadata for task attributes, nil if none set
+func newMeta(parents []string, trace string, group string, require []string, maxTime int64, maxRenew int64) *taskMeta {
+	if len(parents) == 0 && trace == "" && group == "" && len(require) == 0 && maxTime == 0 && maxRenew == 0 {
+		return nil
+	}
+	return &taskMeta{Parents: parents, Trace: trace, Group: group, Require: require, MaxTime: maxTime, MaxRenew: maxRenew}
+}
+
+var lastID int64
+
+func metaPrefix() string {
+	return "__meta:" + cfg.Queue + ":"
+}
+
+func metaKey(task string) string {
+	return metaPrefix() + task
+}
+
+// newTaskID returns unique increasing id based on current time
+func newTaskID() string {
+	for {
+		last := atomic.LoadInt64(&lastID)
+		id := time.Now().UnixNano()
+		if id <= last {
+			id = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&lastID, last, id) {
+			return strconv.FormatInt(id, 10)
+		}
+	}
+}
+
+// putTaskOps returns ops to add task with metadata
+func putTaskOps(id string, data string, meta *taskMeta) ([]clientv3.Op, error) {
+	return queueTaskOps(cfg.Queue, id, data, meta)
+}
+
+// queueTaskOps returns ops to add task with metadata to named queue
+func queueTaskOps(queue string, id string, data string, meta *taskMeta) ([]clientv3.Op, error) {
+	ops := []clientv3.Op{clientv3.OpPut(queue+":"+id, data)}
+	if meta != nil {
+		m, err := json.Marshal(meta)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, clientv3.OpPut("__meta:"+queue+":"+id, string(m)))
+	}
+	return ops, nil
+}
+
+// deleteTasksOps returns ops to delete tasks with metadata in [from, to) id range
+func deleteTasksOps(from string, to string) []clientv3.Op {
+	return []clientv3.Op{
+		clientv3.OpDelete(cfg.Queue+":"+from, clientv3.WithRange(cfg.Queue+":"+to)),
+		clientv3.OpDelete(metaKey(from), clientv3.WithRange(metaKey(to))),
+	}
+}
+
+// nextSize returns count and bytes of follow-up tasks, for capacity plan
+func nextSize(next *[]string) (int, int) {
+	if next == nil {
+		return 0, 0
+	}
+	size := 0
+	for _, data := range *next {
+		size += len(data)
+	}
+	return len(*next), size
+}
+
+// nextTasksOps returns ops to add follow-up tasks to planned queue
+func nextTasksOps(queue string, next *[]string, trace string) ([]KV, []clientv3.Op, error) {
+	if next == nil {
+		return nil, nil, nil
+	}
+	var meta *taskMeta
+	if trace != "" {
+		meta = &taskMeta{Trace: trace}
+	}
+	created := make([]KV, 0, len(*next))
+	ops := make([]clientv3.Op, 0, len(*next))
+	for _, data := range *next {
+		t := KV{ID: newTaskID(), Value: data, Trace: trace}
+		if queue != cfg.Queue {
+			t.Queue = queue
+		}
+		put, err := queueTaskOps(queue, t.ID, t.Value, meta)
+		if err != nil {
+			return nil, nil, err
+		}
+		created = append(created, t)
+		ops = append(ops, put...)
+	}
+	return created, ops, nil
+}
+
+// loadMeta reads metadata for tasks in [first, last] id range
+func loadMeta(ctx context.Context, first string, last string, opts ...clientv3.OpOption) (map[string]*taskMeta, error) {
+	resp, err := client.Get(ctx, metaKey(first), append(opts, clientv3.WithRange(metaKey(last)+"\x00"))...)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]*taskMeta, len(resp.Kvs))
+	prefixLen := len(metaPrefix())
+	for _, ev := range resp.Kvs {
+		var m taskMeta
+		if err := json.Unmarshal(ev.Value, &m); err != nil {
+			return nil, err
+		}
+		result[string(ev.Key)[prefixLen:]] = &m
+	}
+	return result, nil
+}
+
+// waitParents returns true if some parent task not acked yet:
+// still in queue and not acked by consuming group (topic mode)
+func waitParents(ctx context.Context, m *taskMeta, acked func(id string, created int64) bool) (bool, error) {
+	for _, p := range m.Parents {
+		resp, err := client.Get(ctx, cfg.Queue+":"+p, clientv3.WithKeysOnly())
+		if err != nil {
+			return false, err
+		}
+		if len(resp.Kvs) > 0 && !acked(p, resp.Kvs[0].CreateRevision) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
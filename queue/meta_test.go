@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// addChild puts task waiting for parents
+func addChild(t *testing.T, data string, parents ...string) string {
+	t.Helper()
+	code, task, err := putTask(&data, nil, nil, &parents, nil, nil, nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("put: %v %v", code, err)
+	}
+	return task.ID
+}
+
+func TestParents(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	// window of client-limit filled with waiting tasks, free task found on next page
+	useSettings(t, settings{LogLevel: "info", Limit: 2})
+	parent := addTask(t, "parent")
+	child := addChild(t, "child", parent)
+	addChild(t, "child2", parent)
+	free := addTask(t, "free")
+
+	assert.Equal(parent, lease(t, "w1", "").ID)
+	assert.Equal(free, lease(t, "w2", "").ID)
+	timeout := int64(10)
+	w3 := "w3"
+	code, _, err := getTask(&w3, &timeout, nil, nil, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusNoContent, code)
+
+	ack(t, "w1", "", parent)
+	assert.Equal(child, lease(t, "w3", "").ID)
+}
+
+func TestParentsTopic(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t, "a", "b")
+	parent := addTask(t, "parent")
+	child := addChild(t, "child", parent)
+
+	// child waits for parent ack by own group only
+	assert.Equal(parent, lease(t, "w1", "a").ID)
+	ack(t, "w1", "a", parent)
+	assert.Equal(child, lease(t, "w1", "a").ID)
+	assert.Equal(parent, lease(t, "w2", "b").ID)
+	timeout := int64(10)
+	w3, b := "w3", "b"
+	code, _, err := getTask(&w3, &timeout, &b, nil, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusNoContent, code)
+}
+
+func TestNextTasks(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	id := addTask(t, "step1")
+	lease(t, "w", "")
+
+	w, next := "w", []string{"step2", "step3"}
+	trace := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	code, created, err := ackTask(&w, &id, nil, &next, nil, nil, &trace, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Len(*created, 2)
+	assert.Equal([]string{(*created)[0].ID, (*created)[1].ID}, taskKeys(t))
+
+	task := lease(t, "w", "")
+	assert.Equal("step2", task.Value)
+	assert.Equal(trace, task.Trace)
+
+	// follow-up tasks not added if ack failed
+	_, _, err = ackTask(&w, &id, nil, &next, nil, nil, nil, nil)
+	assert.NotNil(err)
+	assert.Len(taskKeys(t), 2)
+}
+
+// addGrouped puts task to message group
+func addGrouped(t *testing.T, data string, group string) string {
+	t.Helper()
+	code, task, err := putTask(&data, nil, nil, nil, &group, nil, nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("put: %v %v", code, err)
+	}
+	return task.ID
+}
+
+func TestMessageGroups(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	a1, a2, b1 := addGrouped(t, "a1", "a"), addGrouped(t, "a2", "a"), addGrouped(t, "b1", "b")
+
+	task := lease(t, "w1", "")
+	assert.Equal(a1, task.ID)
+	assert.Equal("a", task.MessageGroup)
+	assert.Equal(b1, lease(t, "w2", "").ID)
+	timeout, w3 := int64(10), "w3"
+	code, _, err := getTask(&w3, &timeout, nil, nil, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusNoContent, code)
+
+	// released task goes first again, next one after ack
+	w1 := "w1"
+	code, err = nakTask(&w1, &a1, nil, nil, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(a1, lease(t, "w3", "").ID)
+	ack(t, "w3", "", a1)
+	assert.Equal(a2, lease(t, "w1", "").ID)
+}
+
+func TestHotMessageGroup(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	// client-limit window filled with blocked tasks of hot group, other group found on next page
+	useSettings(t, settings{LogLevel: "info", Limit: 2})
+	hot := addGrouped(t, "h1", "hot")
+	for i := 0; i < 5; i++ {
+		addGrouped(t, "h", "hot")
+	}
+	cold := addGrouped(t, "c1", "cold")
+
+	assert.Equal(hot, lease(t, "w1", "").ID)
+	assert.Equal(cold, lease(t, "w2", "").ID)
+}
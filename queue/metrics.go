@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// task counters of this replica, exported in prometheus format
+// and sampled to show recent throughput in dashboard
+
+var counterNames = []string{"put", "leased", "acked", "naked", "dead", "dropped", "spilled", "duplicate", "overdue"}
+
+func counter(name string) *metrics.Counter {
+	return metrics.GetOrCreateCounter(fmt.Sprintf(`queue_tasks_%s_total{queue=%q}`, name, cfg.Queue))
+}
+
+// sample every 10 seconds, rate calculated over last minute
+const (
+	sampleInterval = 10 * time.Second
+	sampleCount    = 7
+)
+
+type sample struct {
+	at     time.Time
+	values map[string]uint64
+}
+
+var samples struct {
+	sync.Mutex
+	ring []sample
+}
+
+func takeSample() {
+	s := sample{at: time.Now(), values: make(map[string]uint64, len(counterNames))}
+	for _, name := range counterNames {
+		s.values[name] = counter(name).Get()
+	}
+	samples.Lock()
+	samples.ring = append(samples.ring, s)
+	if len(samples.ring) > sampleCount {
+		samples.ring = samples.ring[1:]
+	}
+	samples.Unlock()
+}
+
+func sampleLoop() {
+	takeSample()
+	for range time.Tick(sampleInterval) {
+		takeSample()
+	}
+}
+
+// throughput returns tasks per second for every counter
+func throughput() map[string]float64 {
+	samples.Lock()
+	defer samples.Unlock()
+	result := make(map[string]float64, len(counterNames))
+	for _, name := range counterNames {
+		result[name] = 0
+	}
+	if len(samples.ring) < 2 {
+		return result
+	}
+	first, last := samples.ring[0], samples.ring[len(samples.ring)-1]
+	elapsed := last.at.Sub(first.at).Seconds()
+	for _, name := range counterNames {
+		result[name] = float64(last.values[name]-first.values[name]) / elapsed
+	}
+	return result
+}
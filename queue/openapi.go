@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// requests checked against openapi spec (served at /api/v1/openapi.json) before handler:
+// unknown or repeated params, types and limits, form and json body.
+// responses checked in tests, see checkResponse
+
+type apiSchema struct {
+	Ref        string `json:"$ref"`
+	Type       string
+	Items      *apiSchema
+	Properties map[string]*apiSchema
+	Required   []string
+	Enum       []interface{}
+	Minimum    *float64
+	Maximum    *float64
+	MaxLength  *int
+}
+
+type apiContent map[string]struct {
+	Schema *apiSchema
+}
+
+type apiParam struct {
+	In       string
+	Name     string
+	Required bool
+	Schema   *apiSchema
+}
+
+type apiOperation struct {
+	Parameters  []apiParam
+	RequestBody *struct {
+		Required bool
+		Content  apiContent
+	}
+	Responses map[string]struct {
+		Content apiContent
+	}
+}
+
+type apiSpec struct {
+	Servers []struct {
+		URL string
+	}
+	Paths      map[string]map[string]*apiOperation
+	Components struct {
+		Schemas map[string]*apiSchema
+	}
+}
+
+var api = loadAPISpec()
+
+func loadAPISpec() *apiSpec {
+	var s apiSpec
+	if err := json.Unmarshal([]byte(openAPISpec), &s); err != nil {
+		panic(fmt.Sprintf("bad openapi spec: %v", err))
+	}
+	return &s
+}
+
+// operation returns spec of matched route, nil if route not in spec
+func (s *apiSpec) operation(r *http.Request) *apiOperation {
+	route := mux.CurrentRoute(r)
+	if route == nil || len(s.Servers) == 0 {
+		return nil
+	}
+	path, err := route.GetPathTemplate()
+	if err != nil || !strings.HasPrefix(path, s.Servers[0].URL) {
+		return nil
+	}
+	return s.Paths[strings.TrimPrefix(path, s.Servers[0].URL)][strings.ToLower(r.Method)]
+}
+
+func (s *apiSpec) resolve(sc *apiSchema) *apiSchema {
+	for sc != nil && sc.Ref != "" {
+		sc = s.Components.Schemas[strings.TrimPrefix(sc.Ref, "#/components/schemas/")]
+	}
+	return sc
+}
+
+// checkValue checks decoded json value, at is value location for error message
+func (s *apiSpec) checkValue(sc *apiSchema, v interface{}, at string) error {
+	sc = s.resolve(sc)
+	if sc == nil {
+		return nil
+	}
+	if v == nil {
+		return fmt.Errorf("%s: null", at)
+	}
+	switch sc.Type {
+	case "object":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: object expected", at)
+		}
+		for _, r := range sc.Required {
+			if _, ok := m[r]; !ok {
+				return fmt.Errorf("%s: no required field %s", at, r)
+			}
+		}
+		for k, x := range m {
+			p, ok := sc.Properties[k]
+			if !ok {
+				return fmt.Errorf("%s: unknown field %s", at, k)
+			}
+			if err := s.checkValue(p, x, at+"."+k); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "array":
+		a, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: array expected", at)
+		}
+		for i, x := range a {
+			if err := s.checkValue(sc.Items, x, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: string expected", at)
+		}
+		if sc.MaxLength != nil && len(str) > *sc.MaxLength {
+			return fmt.Errorf("%s: longer than %d", at, *sc.MaxLength)
+		}
+	case "integer", "number":
+		n, ok := v.(float64)
+		if !ok || sc.Type == "integer" && n != math.Trunc(n) {
+			return fmt.Errorf("%s: %s expected", at, sc.Type)
+		}
+		if sc.Minimum != nil && n < *sc.Minimum {
+			return fmt.Errorf("%s: less than %v", at, *sc.Minimum)
+		}
+		if sc.Maximum != nil && n > *sc.Maximum {
+			return fmt.Errorf("%s: greater than %v", at, *sc.Maximum)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: boolean expected", at)
+		}
+	}
+	if len(sc.Enum) > 0 {
+		for _, e := range sc.Enum {
+			if e == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: not one of %v", at, sc.Enum)
+	}
+	return nil
+}
+
+// checkParam converts param string to json value and checks it
+func (s *apiSpec) checkParam(sc *apiSchema, str string, at string) error {
+	sc = s.resolve(sc)
+	if sc == nil {
+		return nil
+	}
+	var v interface{} = str
+	switch sc.Type {
+	case "integer":
+		n, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s: integer expected", at)
+		}
+		v = float64(n)
+	case "number":
+		n, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return fmt.Errorf("%s: number expected", at)
+		}
+		v = n
+	case "boolean":
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			return fmt.Errorf("%s: boolean expected", at)
+		}
+		v = b
+	}
+	return s.checkValue(sc, v, at)
+}
+
+// checkValues checks param or form field values, arrays sent as repeated values
+func (s *apiSpec) checkValues(sc *apiSchema, values []string, name string) error {
+	sc = s.resolve(sc)
+	if sc != nil && sc.Type == "array" {
+		for i, v := range values {
+			if err := s.checkParam(sc.Items, v, fmt.Sprintf("%s[%d]", name, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(values) > 1 {
+		return fmt.Errorf("param %s repeated", name)
+	}
+	return s.checkParam(sc, values[0], name)
+}
+
+// checkForm checks form fields against body schema, fields marked known
+func (s *apiSpec) checkForm(sc *apiSchema, form url.Values, known map[string]bool) error {
+	sc = s.resolve(sc)
+	if sc == nil {
+		return nil
+	}
+	for _, f := range sc.Required {
+		if len(form[f]) == 0 {
+			return fmt.Errorf("no required field %s", f)
+		}
+	}
+	for name, p := range sc.Properties {
+		known[name] = true
+		if values := form[name]; len(values) > 0 {
+			if err := s.checkValues(p, values, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkRequest checks params and body, json body restored for handler.
+// handlers of non-GET routes read query params from form, so form body checked as query
+func (s *apiSpec) checkRequest(op *apiOperation, r *http.Request) error {
+	query := r.URL.Query()
+	if r.Method != http.MethodGet {
+		if err := r.ParseForm(); err != nil {
+			return fmt.Errorf("bad form: %v", err)
+		}
+		query = r.Form
+	}
+	known := make(map[string]bool)
+	for _, p := range op.Parameters {
+		var values []string
+		switch p.In {
+		case "query":
+			values = query[p.Name]
+			known[p.Name] = true
+		case "header":
+			values = r.Header[http.CanonicalHeaderKey(p.Name)]
+		case "path":
+			if v, ok := mux.Vars(r)[p.Name]; ok {
+				values = []string{v}
+			}
+		}
+		if len(values) == 0 {
+			if p.Required {
+				return fmt.Errorf("no required param %s", p.Name)
+			}
+			continue
+		}
+		if err := s.checkValues(p.Schema, values, p.Name); err != nil {
+			return err
+		}
+	}
+
+	if op.RequestBody != nil {
+		if c, ok := op.RequestBody.Content["application/x-www-form-urlencoded"]; ok {
+			if err := s.checkForm(c.Schema, query, known); err != nil {
+				return err
+			}
+		}
+	}
+	for k := range query {
+		if !known[k] {
+			return fmt.Errorf("unknown param %s", k)
+		}
+	}
+
+	if op.RequestBody == nil {
+		return nil
+	}
+	if c, ok := op.RequestBody.Content["application/json"]; ok && r.Body != nil {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return fmt.Errorf("fail to read body: %v", err)
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if len(body) == 0 {
+			if op.RequestBody.Required {
+				return fmt.Errorf("no required body")
+			}
+			return nil
+		}
+		var v interface{}
+		if err = json.Unmarshal(body, &v); err != nil {
+			return fmt.Errorf("bad body: %v", err)
+		}
+		return s.checkValue(c.Schema, v, "body")
+	}
+	return nil
+}
+
+// checkResponse checks status code is declared and body matches its schema
+func (s *apiSpec) checkResponse(op *apiOperation, code int, body []byte) error {
+	resp, ok := op.Responses[strconv.Itoa(code)]
+	if !ok {
+		if resp, ok = op.Responses["default"]; !ok {
+			return fmt.Errorf("status %d not declared", code)
+		}
+	}
+	// handlers may return declared code without body
+	if len(body) == 0 {
+		return nil
+	}
+	c, ok := resp.Content["application/json"]
+	if !ok {
+		return fmt.Errorf("status %d: unexpected body", code)
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return fmt.Errorf("status %d: bad json: %v", code, err)
+	}
+	return s.checkValue(c.Schema, v, "response")
+}
+
+func checkAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if op := api.operation(r); op != nil {
+			if err := api.checkRequest(op, r); err != nil {
+				logger.WithField("method", r.URL.Path).WithField("request_id", r.Header.Get(requestIDHeader)).Warn(err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AddAPIValidation checks requests to api routes against openapi spec
+func AddAPIValidation(r *mux.Router) {
+	r.Use(checkAPI)
+}
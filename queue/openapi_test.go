@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// values returned by handlers, one for every schema in spec
+var apiSamples = map[string]interface{}{
+	"KV":          KV{ID: "1", Value: "data", Progress: "50%", Trace: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", Token: 7},
+	"State":       State{State: "A", Revision: 10, Paused: true},
+	"StateChange": StateChange{State: "A", Revision: 10, Tasks: []string{"1"}},
+	"CronJob":     CronJob{Name: "tick", Schedule: "@every 1m", Data: "x", CatchUp: "once"},
+	"Stats":       Stats{Group: "a", Pending: 1, Active: 2, Dead: 3},
+	"InternalKey": InternalKey{Kind: "cursor", Group: "a", ID: "1", Value: "2"},
+	"Lease":       Lease{Group: "a", ID: "1", Client: "w1", TTL: 5, Token: 7, Progress: "50%"},
+	"Event":       Event{Revision: 12, Type: "acked", Task: "1", Client: "w1", Time: "2020-01-01T00:00:00Z"},
+	"Session":     Session{ID: "694d7a0c1b5e0a04", ClientID: "w1", TTL: 30, Hold: 10},
+	"Worker":      Worker{ID: "w1", Registered: true, Tags: []string{"gpu=true"}, LastSeen: "2020-01-01T00:00:00Z", TTL: 5, Tasks: []string{"a:1"}},
+}
+
+// bodyRecorder keeps whole response to check it
+type bodyRecorder struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (r *bodyRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *bodyRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// sampleResponse returns json for response schema, from apiSamples
+func sampleResponse(t *testing.T, sc *apiSchema) []byte {
+	array := sc.Type == "array"
+	if array {
+		sc = sc.Items
+	}
+	v, ok := apiSamples[strings.TrimPrefix(sc.Ref, "#/components/schemas/")]
+	if !ok {
+		t.Fatalf("no sample for %v", sc.Ref)
+	}
+	if array {
+		v = []interface{}{v}
+	}
+	b, _ := json.Marshal(v)
+	return b
+}
+
+// checkResponses validates requests and responses of router, response errors saved to errs
+func checkResponses(r *mux.Router, errs *[]error) {
+	r.Use(checkAPI)
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			rec := &bodyRecorder{ResponseWriter: w, code: http.StatusOK}
+			next.ServeHTTP(rec, req)
+			if err := api.checkResponse(api.operation(req), rec.code, rec.body.Bytes()); err != nil {
+				*errs = append(*errs, fmt.Errorf("%v: %v", req.URL, err))
+			}
+		})
+	})
+}
+
+func TestAPIResponses(t *testing.T) {
+	assert := assert.New(t)
+	var errs []error
+	r := mux.NewRouter()
+	checkResponses(r, &errs)
+
+	// every route responds with every declared code, body built from go types
+	type call struct {
+		method, target, code string
+	}
+	var calls []call
+	for path, methods := range api.Paths {
+		for method, op := range methods {
+			q := url.Values{}
+			for _, p := range op.Parameters {
+				if p.Required {
+					q.Set(p.Name, "1")
+				}
+			}
+			bodies := make(map[string][]byte)
+			for code, resp := range op.Responses {
+				if c, ok := resp.Content["application/json"]; ok {
+					bodies[code] = sampleResponse(t, c.Schema)
+				}
+				calls = append(calls, call{strings.ToUpper(method), api.Servers[0].URL + path + "?" + q.Encode(), code})
+			}
+			r.Path(api.Servers[0].URL + path).Methods(method).HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				code := req.Header.Get("X-Code")
+				n, _ := strconv.Atoi(code)
+				w.WriteHeader(n)
+				w.Write(bodies[code])
+			})
+		}
+	}
+	for _, c := range calls {
+		req := httptest.NewRequest(c.method, c.target, nil)
+		req.Header.Set("X-Code", c.code)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(c.code, strconv.Itoa(w.Code), c.target)
+	}
+	assert.Empty(errs)
+	assert.NotEmpty(calls)
+
+	for name := range api.Components.Schemas {
+		assert.Contains(apiSamples, name)
+	}
+
+	// lowercase fields are not KV
+	op := api.Paths["/get"]["get"]
+	assert.NotNil(api.checkResponse(op, http.StatusOK, []byte(`{"id":"1","value":"x"}`)))
+	assert.NotNil(api.checkResponse(op, http.StatusTeapot, nil))
+}
+
+// real handlers on etcd, responses must match spec
+func TestAPIHandlers(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	var errs []error
+	r := CreateRouter(logger, funcHandler{})
+	checkResponses(r, &errs)
+
+	send := func(code int, method string, target string) []byte {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, "/api/v1/"+target, nil))
+		assert.Equal(code, w.Code, target)
+		return w.Body.Bytes()
+	}
+	call := func(code int, target string) []byte {
+		t.Helper()
+		return send(code, "GET", target)
+	}
+	var task KV
+	json.Unmarshal(call(http.StatusOK, "put?data=x"), &task)
+	id := task.ID
+	call(http.StatusOK, "dump")
+	call(http.StatusOK, "state")
+	call(http.StatusOK, "stats")
+	call(http.StatusOK, "keys")
+	call(http.StatusOK, "get?client_id=w&timeout=10")
+	call(http.StatusNoContent, "get?client_id=w2&timeout=10")
+	call(http.StatusOK, "leases")
+	call(http.StatusOK, "renew?client_id=w&task_id="+id+"&progress=50")
+	call(http.StatusConflict, "renew?client_id=w2&task_id="+id)
+	call(http.StatusOK, "ack?client_id=w&task_id="+id+"&result=done")
+	call(http.StatusNotFound, "ack?client_id=w&task_id="+id)
+	call(http.StatusNotFound, "nak?client_id=w&task_id="+id)
+	call(http.StatusOK, "result?task_id="+id)
+	call(http.StatusOK, "state/history")
+	call(http.StatusOK, "dead/list")
+	send(http.StatusOK, "DELETE", "dead/purge")
+	send(http.StatusNotFound, "POST", "dead/requeue?task_id="+id)
+	send(http.StatusNotFound, "DELETE", "dead/delete?task_id="+id)
+	send(http.StatusNotFound, "DELETE", "delete?task_id="+id)
+	send(http.StatusMethodNotAllowed, "GET", "delete?task_id="+id)
+	call(http.StatusOK, "register?client_id=w&ttl=10&tag=gpu=true")
+	call(http.StatusOK, "heartbeat?client_id=w")
+	call(http.StatusOK, "clients")
+	call(http.StatusOK, "unregister?client_id=w")
+	var session Session
+	json.Unmarshal(call(http.StatusOK, "session/open?client_id=w&ttl=10"), &session)
+	call(http.StatusOK, "session/keepalive?client_id=w&session="+session.ID)
+	call(http.StatusOK, "session/close?client_id=w&session="+session.ID)
+	call(http.StatusNotFound, "events?since_revision=1") // feed disabled
+	call(http.StatusOK, "cron/list")
+	call(http.StatusOK, "pause")
+	call(http.StatusOK, "resume")
+	send(http.StatusOK, "DELETE", "purge")
+	assert.Empty(errs)
+}
+
+func TestAPIRequests(t *testing.T) {
+	assert := assert.New(t)
+	r := mux.NewRouter()
+	r.Use(checkAPI)
+	for _, p := range []string{"/api/v1/get", "/api/v1/put", "/api/v1/cron/put", "/api/v1/state/history", "/api/v1/session/open"} {
+		r.Path(p).HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	}
+	status := func(method, target string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w.Code
+	}
+	assert.Equal(http.StatusOK, status("GET", "/api/v1/get?client_id=a&timeout=1"))
+	assert.Equal(http.StatusBadRequest, status("GET", "/api/v1/get?timeout=1"))
+	assert.Equal(http.StatusBadRequest, status("GET", "/api/v1/get?client_id=a&timeout=0"))
+	assert.Equal(http.StatusBadRequest, status("GET", "/api/v1/get?client_id=a&timeout=x"))
+	assert.Equal(http.StatusBadRequest, status("GET", "/api/v1/get?client_id=a&client_id=b&timeout=1"))
+	assert.Equal(http.StatusBadRequest, status("GET", "/api/v1/get?client=a&client_id=a&timeout=1"))
+	assert.Equal(http.StatusOK, status("POST", "/api/v1/put?data=x&parents=1&parents=2"))
+	assert.Equal(http.StatusBadRequest, status("GET", "/api/v1/cron/put?name=a&schedule=x&data=y&catch_up=never"))
+	assert.Equal(http.StatusOK, status("GET", "/api/v1/state/history?limit=100"))
+	assert.Equal(http.StatusBadRequest, status("GET", "/api/v1/state/history?limit=1000000000"))
+	assert.Equal(http.StatusBadRequest, status("GET", "/api/v1/session/open?client_id=a&ttl=10&hold=0"))
+}
+
+// form body of POST checked as query params, handler reads both
+func TestAPIFormBody(t *testing.T) {
+	assert := assert.New(t)
+	r := mux.NewRouter()
+	r.Use(checkAPI)
+	r.Path("/api/v1/put").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	post := func(target, form string) int {
+		req := httptest.NewRequest("POST", target, strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(http.StatusOK, post("/api/v1/put", "data=x&max_time=10&parents=1&parents=2"))
+	assert.Equal(http.StatusOK, post("/api/v1/put?max_time=10", "data=x"))
+	assert.Equal(http.StatusBadRequest, post("/api/v1/put", "data=x&max_time=0"))
+	assert.Equal(http.StatusBadRequest, post("/api/v1/put", "data=x&max_renew=x"))
+	assert.Equal(http.StatusBadRequest, post("/api/v1/put", "max_time=10"))
+	assert.Equal(http.StatusBadRequest, post("/api/v1/put", "data=x&group=a"))
+	assert.Equal(http.StatusBadRequest, post("/api/v1/put?data=x", "data=y"))
+	assert.Equal(http.StatusBadRequest, post("/api/v1/put", "data=%zz"))
+}
+
+func TestAPIFormSchema(t *testing.T) {
+	assert := assert.New(t)
+	var s apiSpec
+	assert.Nil(json.Unmarshal([]byte(`{"components": {"schemas": {"Item": {"type": "object", "required": ["name"],
+		"properties": {"name": {"type": "string", "maxLength": 3}, "weight": {"type": "array", "items": {"type": "number", "minimum": 0}}}}}}}`), &s))
+	var op apiOperation
+	assert.Nil(json.Unmarshal([]byte(`{"parameters": [{"in": "query", "name": "dry_run", "schema": {"type": "boolean"}}],
+		"requestBody": {"content": {"application/x-www-form-urlencoded": {"schema": {"$ref": "#/components/schemas/Item"}}}}}`), &op))
+	check := func(target, form string) error {
+		req := httptest.NewRequest("POST", target, strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return s.checkRequest(&op, req)
+	}
+	assert.Nil(check("/items", "name=a&weight=1&weight=2.5"))
+	assert.Nil(check("/items?dry_run=true", "name=a"))
+	assert.EqualError(check("/items", "weight=1"), "no required field name")
+	assert.EqualError(check("/items", "name=long"), "name: longer than 3")
+	assert.EqualError(check("/items", "name=a&name=b"), "param name repeated")
+	assert.EqualError(check("/items", "name=a&weight=-1"), "weight[0]: less than 0")
+	assert.EqualError(check("/items", "name=a&size=1"), "unknown param size")
+	assert.EqualError(check("/items?dry_run=x", "name=a"), "dry_run: boolean expected")
+}
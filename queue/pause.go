@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// dispatch pause: flag key checked in lease txn, so no task leased after pause returns.
+// replicas watch the key to skip task lookup and to export paused gauge.
+// put, renew, ack and nak are not affected
+
+var paused int32
+
+func pausedKey() string {
+	return "__paused:" + cfg.Queue
+}
+
+func isPaused() bool {
+	return atomic.LoadInt32(&paused) == 1
+}
+
+func setPaused(v bool) {
+	var n int32
+	if v {
+		n = 1
+	}
+	if atomic.SwapInt32(&paused, n) != n {
+		logger.WithField("paused", v).Warn("dispatch state changed")
+	}
+}
+
+// notPaused is lease txn condition
+func notPaused() clientv3.Cmp {
+	return clientv3.Compare(clientv3.CreateRevision(pausedKey()), "=", 0)
+}
+
+func pauseQueue() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	_, err := client.Put(ctx, pausedKey(), time.Now().UTC().Format(time.RFC3339))
+	cancel()
+	if err != nil {
+		return http.StatusInternalServerError, errors.Wrap(err, "fail to pause queue")
+	}
+	setPaused(true)
+	return http.StatusOK, nil
+}
+
+func resumeQueue() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	_, err := client.Delete(ctx, pausedKey())
+	cancel()
+	if err != nil {
+		return http.StatusInternalServerError, errors.Wrap(err, "fail to resume queue")
+	}
+	setPaused(false)
+	return http.StatusOK, nil
+}
+
+// watchPause keeps paused flag in sync with etcd
+func watchPause() {
+	metrics.GetOrCreateGauge(fmt.Sprintf(`queue_paused{queue=%q}`, cfg.Queue), func() float64 {
+		return float64(atomic.LoadInt32(&paused))
+	})
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+		resp, err := client.Get(ctx, pausedKey())
+		cancel()
+		if err != nil {
+			logger.Errorf("fail to get paused flag: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		setPaused(len(resp.Kvs) > 0)
+		for wr := range client.Watch(context.Background(), pausedKey(), clientv3.WithRev(resp.Header.Revision+1)) {
+			if err := wr.Err(); err != nil {
+				logger.Errorf("paused flag watch failed: %v", err)
+				break
+			}
+			for _, ev := range wr.Events {
+				setPaused(ev.Type == clientv3.EventTypePut)
+			}
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPause(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	t.Cleanup(func() { setPaused(false) })
+	first := addTask(t, "first")
+
+	code, err := pauseQueue()
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	_, state, err := getState()
+	assert.Nil(err)
+	assert.True(state.Paused)
+
+	// put works, nothing leased
+	second := addTask(t, "second")
+	timeout, w := int64(10), "w"
+	code, task, err := getTask(&w, &timeout, nil, nil, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusNoContent, code)
+	assert.Nil(task)
+	assert.Equal([]string{first, second}, taskKeys(t))
+
+	code, err = resumeQueue()
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	_, state, err = getState()
+	assert.Nil(err)
+	assert.False(state.Paused)
+	assert.Equal(first, lease(t, "w", "").ID)
+	assert.Equal(second, lease(t, "w2", "").ID)
+}
+
+func TestPauseOtherReplica(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	addTask(t, "task")
+
+	// flag set by other replica, not seen by watch yet: lease txn refused
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	_, err := client.Put(ctx, pausedKey(), time.Now().UTC().Format(time.RFC3339))
+	assert.Nil(err)
+	assert.False(isPaused())
+	timeout, w := int64(10), "w"
+	code, task, err := getTask(&w, &timeout, nil, nil, nil)
+	assert.NotEqual(http.StatusOK, code, err)
+	assert.Nil(task)
+	assert.Zero(keyCount(t, activePrefix("")))
+
+	_, err = client.Delete(ctx, pausedKey())
+	assert.Nil(err)
+	lease(t, "w", "")
+}
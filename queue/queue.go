@@ -4,23 +4,42 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
-	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/v3"
 )
 
 var client *clientv3.Client
 
 const etcdTimeout = time.Second * 5
 
+// how many times to try lease a task if other clients are faster
+const lockAttempts = 3
+
 func stateKey() string {
 	return "__internal:" + cfg.Queue
 }
 
+func activePrefix(group string) string {
+	if group == "" {
+		return "__active:" + cfg.Queue + ":"
+	}
+	return groupActivePrefix(group)
+}
+
 func activeKey(task string) string {
-	return "__active:" + cfg.Queue + ":" + task
+	return activePrefix("") + task
+}
+
+func groupName(group *string) string {
+	if group == nil {
+		return ""
+	}
+	return *group
 }
 
 func openEtcd() error {
@@ -34,29 +53,54 @@ func openEtcd() error {
 	client = cli
 
 	// FIXME: wrap with retry ?
-	// create queue state if not exists
 	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
-	_, err = client.Txn(ctx).
+	defer cancel()
+	return createQueue(ctx)
+}
+
+// createQueue creates queue state and groups if not exists
+func createQueue(ctx context.Context) error {
+	_, err := client.Txn(ctx).
 		If(clientv3.Compare(clientv3.CreateRevision(stateKey()), "=", 0)).
 		Then(clientv3.OpPut(stateKey(), "")).
 		Commit()
-	cancel()
 	if err != nil {
 		return err
 	}
-
+	if isTopic() {
+		return createGroups(ctx)
+	}
 	return nil
 }
 
+// checkGroup ensures group passed only to topic and topic always used with group
+func checkGroup(group *string) (int, error) {
+	if isTopic() && group == nil {
+		return http.StatusBadRequest, fmt.Errorf("queue is a topic, group required")
+	}
+	if !isTopic() && group != nil {
+		return http.StatusBadRequest, fmt.Errorf("queue is not a topic")
+	}
+	return http.StatusOK, nil
+}
+
 // KV XXX
 type KV struct {
-	ID    string
-	Value string
+	ID           string
+	Value        string
+	Progress     string `json:",omitempty"`
+	Trace        string `json:",omitempty"`
+	Token        int64  `json:",omitempty"` // fencing token of lease, set by get
+	MessageGroup string `json:",omitempty"` // set by put, see taskMeta
+	Queue        string `json:",omitempty"` // set by put if task spilled to other queue
+	Duplicate    bool   `json:",omitempty"` // set by put if same task added within dedup-window
 }
 
 // State XXX
 type State struct {
-	State string
+	State    string
+	Revision int64
+	Paused   bool `json:",omitempty"` // task dispatch paused
 }
 
 func dump() (int, *[]KV, error) {
@@ -68,88 +112,305 @@ func dump() (int, *[]KV, error) {
 		return http.StatusInternalServerError, nil, err
 	}
 
+	// progress of every group in topic mode
+	reported := make(map[string][]string)
+	for _, g := range groups() {
+		ctx, cancel = context.WithTimeout(context.Background(), etcdTimeout)
+		progress, err := client.Get(ctx, progressPrefix(g), clientv3.WithPrefix())
+		cancel()
+		if err != nil {
+			return http.StatusInternalServerError, nil, err
+		}
+		for _, ev := range progress.Kvs {
+			id, value := string(ev.Key)[len(progressPrefix(g)):], string(ev.Value)
+			if g != "" {
+				value = g + ": " + value
+			}
+			reported[id] = append(reported[id], value)
+		}
+	}
+
 	prefixLen := len(cfg.Queue) + 1 // to skip `:`
 	result := make([]KV, 0, len(resp.Kvs))
 	for _, ev := range resp.Kvs {
 		t := KV{ID: string(ev.Key)[prefixLen:], Value: string(ev.Value)}
+		t.Progress = strings.Join(reported[t.ID], ", ")
 		result = append(result, t)
 	}
 	return http.StatusOK, &result, nil
 }
 
-func getTask(clientID *string, timeout *int64) (int, *KV, error) {
-	f := log.Fields{"client": clientID}
+// candidate is a task to lease with conditions to check in lease txn
+type candidate struct {
+	KV
+	cmps     []clientv3.Cmp
+	ops      []clientv3.Op
+	deadline *deadline // put with lock if task have limits
+}
+
+// findTask returns first task available for client, client may hold up to `hold` tasks.
+// group is empty for plain queue
+func findTask(ctx context.Context, clientID string, group string, hold int) (int, *candidate, error) {
+	if isPaused() {
+		return http.StatusNoContent, nil, nil
+	}
+	skip := make(map[string]struct{})
+	acked := make(map[string]struct{})
+	var cursor int64
+	if group != "" {
+		resp, err := client.Get(ctx, cursorKey(group))
+		if err != nil {
+			return http.StatusInternalServerError, nil, fmt.Errorf("fail to get cursor")
+		}
+		if len(resp.Kvs) == 0 {
+			return http.StatusNotFound, nil, fmt.Errorf("unknown group %v", group)
+		}
+		cursor = parseRevision(resp.Kvs[0].Value)
+
+		prefix := groupAckedPrefix(group)
+		resp, err = client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+		if err != nil {
+			return http.StatusInternalServerError, nil, err
+		}
+		for _, ev := range resp.Kvs {
+			acked[string(ev.Key)[len(prefix):]] = struct{}{}
+		}
+	}
+	// parent acked by group if it is before cursor or marked, acked by all if removed
+	ackedBy := func(id string, created int64) bool {
+		_, ok := acked[id]
+		return ok || created <= cursor
+	}
 
 	// fetch all running tasks
-	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
-	resp, err := client.Get(ctx, "__active:"+cfg.Queue+":", clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
-	cancel()
+	prefix := activePrefix(group)
+	resp, err := client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
 	if err != nil {
 		return http.StatusInternalServerError, nil, err
 	}
 
-	// ensure client have no running task
-	prefixLen := len("__active:" + cfg.Queue + ":") // task id right after prefix
-	running := make(map[string]struct{})
+	// ensure client have no more than `hold` running tasks
+	held := 0
 	for _, ev := range resp.Kvs {
-		if string(ev.Value) == *clientID {
-			return http.StatusConflict, nil, fmt.Errorf("client already have a task %s", ev)
+		if string(ev.Value) == clientID {
+			held++
+			if held >= hold {
+				return http.StatusConflict, nil, fmt.Errorf("client already have a task %s", ev)
+			}
 		}
-		runningTaskID := string(ev.Key)[prefixLen:]
-		running[runningTaskID] = struct{}{}
+		skip[string(ev.Key)[len(prefix):]] = struct{}{}
 	}
-
-	// get pending tasks
-	ctx, cancel = context.WithTimeout(context.Background(), etcdTimeout)
-	all, err := client.Get(ctx, cfg.Queue+":", clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend), clientv3.WithLimit(cfg.Limit))
-	cancel()
-	if err != nil {
-		return http.StatusInternalServerError, nil, fmt.Errorf("fail to get tasks")
+	c := &candidate{cmps: []clientv3.Cmp{notPaused()}}
+	if code, err := checkActive(c, group, len(resp.Kvs), resp.Header.Revision); err != nil {
+		return code, nil, err
 	}
 
-	prefixLen = len(cfg.Queue) + 1 // to skip `:`
-	var pending KV
-	for _, ev := range all.Kvs {
-		t := KV{ID: string(ev.Key)[prefixLen:], Value: string(ev.Value)}
-		_, ok := running[t.ID]
-		if !ok && len(pending.ID) == 0 {
-			pending = t // pick first not running task
+	// only first task of message group can be leased, group blocked until it acked
+	blocked := make(map[string]struct{})
+	var worker *workerInfo
+	workerLoaded := false
+	prefixLen := len(cfg.Queue) + 1 // to skip `:`
+
+	// pending tasks read by pages of client-limit at same revision, until task found.
+	// tasks at queue head may wait for parents or be blocked by message group
+	start, opts := tasksAfter(nil, cursor)
+	for {
+		all, err := client.Get(ctx, start, opts...)
+		if err != nil {
+			return http.StatusInternalServerError, nil, fmt.Errorf("fail to get tasks")
 		}
+		kvs := pageTasks(all)
+		if len(kvs) == 0 {
+			break
+		}
+		first, last := string(kvs[0].Key)[prefixLen:], string(kvs[0].Key)[prefixLen:]
+		for _, ev := range kvs {
+			if id := string(ev.Key)[prefixLen:]; id < first {
+				first = id
+			} else if id > last {
+				last = id
+			}
+		}
+		meta, err := loadMeta(ctx, first, last, clientv3.WithRev(all.Header.Revision))
+		if err != nil {
+			return http.StatusInternalServerError, nil, fmt.Errorf("fail to get tasks metadata")
+		}
+		for _, ev := range kvs {
+			t := KV{ID: string(ev.Key)[prefixLen:], Value: string(ev.Value)}
+			if _, ok := acked[t.ID]; ok {
+				continue
+			}
+			m, ok := meta[t.ID]
+			if ok && m.Group != "" {
+				if _, ok := blocked[m.Group]; ok {
+					continue
+				}
+				blocked[m.Group] = struct{}{}
+			}
+			if _, ok := skip[t.ID]; ok {
+				continue
+			}
+			if ok {
+				t.Trace = m.Trace
+				t.MessageGroup = m.Group
+				if len(m.Require) > 0 && !workerLoaded {
+					if worker, err = loadWorker(ctx, clientID); err != nil {
+						return http.StatusInternalServerError, nil, fmt.Errorf("fail to get worker")
+					}
+					workerLoaded = true
+				}
+				if !hasTags(worker, m.Require) {
+					continue
+				}
+				wait, err := waitParents(ctx, m, ackedBy)
+				if err != nil {
+					return http.StatusInternalServerError, nil, fmt.Errorf("fail to check parents")
+				}
+				if wait {
+					continue
+				}
+			}
+			d := newDeadline(m)
+			if d != nil {
+				if _, err := loadStarted(ctx, group, t.ID, d); err != nil {
+					return http.StatusInternalServerError, nil, fmt.Errorf("fail to get task start")
+				}
+				if d.expired(time.Now()) {
+					dead, err := deadExpired(ctx, group, ev, m)
+					if err != nil {
+						return http.StatusInternalServerError, nil, fmt.Errorf("fail to move expired task")
+					}
+					if dead && m.Group != "" {
+						delete(blocked, m.Group) // next task of group is head now
+					}
+					continue
+				}
+			}
+			c.KV = t // pick first not running task
+			c.deadline = d
+			// task may be acked or moved to dead letter queue after we read active tasks
+			c.cmps = append(c.cmps, clientv3.Compare(clientv3.ModRevision(string(ev.Key)), "=", ev.ModRevision))
+			if group != "" {
+				c.cmps = append(c.cmps, clientv3.Compare(clientv3.CreateRevision(groupAckedPrefix(group)+t.ID), "=", 0))
+			}
+			break
+		}
+		if len(c.ID) > 0 || !all.More {
+			break
+		}
+		start, opts = tasksAfter(kvs[len(kvs)-1], cursor)
+		opts = append(opts, clientv3.WithRev(all.Header.Revision))
 	}
-
-	if len(pending.ID) == 0 {
+	if len(c.ID) == 0 {
 		return http.StatusNoContent, nil, nil
 	}
-	f = log.Fields{"client": clientID, "task": pending.ID, "value": pending.Value}
-
-	// create lease
-	lease, err := client.Grant(context.TODO(), *timeout)
-	if err != nil {
-		return http.StatusInternalServerError, nil, fmt.Errorf("fail to create a lease")
+	if code, err := takeToken(ctx, c); err != nil {
+		return code, nil, err
 	}
+	return http.StatusOK, c, nil
+}
 
-	// put with Lease in txn
-	ctx, cancel = context.WithTimeout(context.Background(), etcdTimeout)
+// lockTask marks task as running by client, lock released with lease.
+// revision of lock txn is fencing token
+func lockTask(ctx context.Context, clientID string, group string, c *candidate, lease clientv3.LeaseID) (int, error) {
+	key := activePrefix(group) + c.ID
+	ops := append(c.ops, clientv3.OpPut(key, clientID, clientv3.WithLease(lease)))
+	cmps := append(c.cmps, clientv3.Compare(clientv3.CreateRevision(key), "=", 0))
+	if c.deadline != nil {
+		dops, dcmps, err := deadlineOps(group, c.ID, c.deadline, lease)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		ops, cmps = append(ops, dops...), append(cmps, dcmps...)
+	}
 	putResp, err := client.Txn(ctx).
-		If(clientv3.Compare(clientv3.CreateRevision(activeKey(pending.ID)), "=", 0)).
-		Then(clientv3.OpPut(activeKey(pending.ID), *clientID, clientv3.WithLease(lease.ID))).
+		If(cmps...).
+		Then(ops...).
 		Commit()
-	cancel()
 	if err != nil {
-		return http.StatusInternalServerError, nil, fmt.Errorf("fail to get lease on task %s", pending.ID)
+		return http.StatusInternalServerError, fmt.Errorf("fail to get lease on task %s", c.ID)
 	}
 	if !putResp.Succeeded {
-		return http.StatusConflict, nil, nil
+		return http.StatusConflict, nil
+	}
+	c.Token = putResp.Header.Revision
+	counter("leased").Inc()
+	return http.StatusOK, nil
+}
+
+func getTask(clientID *string, timeout *int64, group *string, session *string, requestID *string) (int, *KV, error) {
+	if code, err := checkGroup(group); err != nil {
+		return code, nil, err
+	}
+	if timeout == nil && session == nil {
+		return http.StatusBadRequest, nil, fmt.Errorf("timeout or session required")
+	}
+	f := withRequest(log.Fields{"client": *clientID}, requestID)
+	if group != nil {
+		f["group"] = *group
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	// task held under session lease, session checked in lock txn
+	var lease *clientv3.LeaseGrantResponse
+	var sessionCmps []clientv3.Cmp
+	hold := 1
+	if session != nil {
+		f["session"] = *session
+		code, s, id, cmp, err := sessionLease(ctx, *clientID, *session)
+		if err != nil {
+			return code, nil, err
+		}
+		lease = &clientv3.LeaseGrantResponse{ID: id}
+		sessionCmps = append(sessionCmps, cmp)
+		hold = int(s.Hold)
 	}
-	logger.WithFields(f).Debug("got a task")
-	return http.StatusOK, &pending, nil
+
+	// lease txn fails if other client got same task or limits changed
+	var err error
+	code := http.StatusConflict
+	for i := 0; i < lockAttempts && code == http.StatusConflict; i++ {
+		var pending *candidate
+		code, pending, err = findTask(ctx, *clientID, groupName(group), hold)
+		if pending == nil {
+			break
+		}
+		if lease == nil {
+			lease, err = client.Grant(ctx, *timeout)
+			if err != nil {
+				return http.StatusInternalServerError, nil, fmt.Errorf("fail to create a lease")
+			}
+		}
+		pending.cmps = append(pending.cmps, sessionCmps...)
+		code, err = lockTask(ctx, *clientID, groupName(group), pending, lease.ID)
+		if code == http.StatusOK {
+			f["task"] = pending.ID
+			f["value"] = pending.Value
+			f["token"] = pending.Token
+			logger.WithFields(f).Debug("got a task")
+			return http.StatusOK, &pending.KV, nil
+		}
+	}
+	if lease != nil && session == nil {
+		client.Revoke(ctx, lease.ID)
+	}
+	return code, nil, err
 }
 
-func renewTask(clientID *string, taskID *string) (int, error) {
-	f := log.Fields{"client": *clientID, "task": *taskID}
+func renewTask(clientID *string, taskID *string, group *string, progress *string, token *int64, requestID *string) (int, error) {
+	if code, err := checkGroup(group); err != nil {
+		return code, err
+	}
+	f := withRequest(log.Fields{"client": *clientID, "task": *taskID}, requestID)
+	key := activePrefix(groupName(group)) + *taskID
+	if group != nil {
+		f["group"] = *group
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
-	resp, err := client.Get(ctx, activeKey(*taskID))
+	resp, err := client.Get(ctx, key)
 	cancel()
 	if err != nil {
 		return http.StatusInternalServerError, fmt.Errorf("fail to get running tasks")
@@ -160,10 +421,34 @@ func renewTask(clientID *string, taskID *string) (int, error) {
 		if string(ev.Value) != *clientID {
 			return http.StatusConflict, fmt.Errorf("client do not own this task")
 		}
-		_, err = client.KeepAliveOnce(context.Background(), clientv3.LeaseID(ev.Lease))
+		if token != nil && ev.CreateRevision != *token {
+			return notOwned(resp.Kvs, *taskID, token)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+		code, err := countRenew(ctx, *clientID, groupName(group), *taskID, ev.CreateRevision)
+		cancel()
+		if err != nil {
+			return code, err
+		}
+		ctx, cancel = context.WithTimeout(context.Background(), etcdTimeout)
+		_, err = client.KeepAliveOnce(ctx, clientv3.LeaseID(ev.Lease))
+		cancel()
+		if err == rpctypes.ErrLeaseNotFound {
+			return http.StatusNotFound, fmt.Errorf("task %v not running", *taskID)
+		}
 		if err != nil {
 			return http.StatusInternalServerError, fmt.Errorf("fail to refresh")
 		}
+		// task may be released and leased again since get, renew ok only if lease is still ours
+		ctx, cancel = context.WithTimeout(context.Background(), etcdTimeout)
+		owned, active, err := saveProgress(ctx, groupName(group), *taskID, *clientID, ev.CreateRevision, progress, clientv3.LeaseID(ev.Lease))
+		cancel()
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("fail to save progress")
+		}
+		if !owned {
+			return notOwned(active, *taskID, &ev.CreateRevision)
+		}
 		renewOk = true
 	}
 	if renewOk {
@@ -173,57 +458,166 @@ func renewTask(clientID *string, taskID *string) (int, error) {
 	return http.StatusNotFound, fmt.Errorf("no task to refresh")
 }
 
-func ackTask(clientID *string, taskID *string) (int, error) {
-	var err error
-	f := log.Fields{"client": *clientID, "task": *taskID}
-
+func ackTask(clientID *string, taskID *string, group *string, next *[]string, result *string, token *int64, traceparent *string, requestID *string) (int, *[]KV, error) {
+	if code, err := checkGroup(group); err != nil {
+		return code, nil, err
+	}
+	if group != nil {
+		return ackGroupTask(clientID, taskID, *group, next, result, token, traceparent, requestID)
+	}
+	f := withRequest(log.Fields{"client": *clientID, "task": *taskID}, requestID)
 	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
-	var resp *clientv3.TxnResponse
-	resp, err = client.Txn(ctx).
-		If(clientv3.Compare(clientv3.Value(activeKey(*taskID)), "=", *clientID)).
-		Then(clientv3.OpDelete(activeKey(*taskID)),
-			clientv3.OpDelete(cfg.Queue+":"+*taskID)).
-		Commit()
-	cancel()
+	defer cancel()
+	rops, err := resultOps(ctx, "", *taskID, result)
 	if err != nil {
-		return http.StatusInternalServerError, fmt.Errorf("fail to ack task %v", *taskID)
+		return http.StatusInternalServerError, nil, fmt.Errorf("fail to create a lease")
 	}
-	if !resp.Succeeded {
-		return http.StatusNotFound, fmt.Errorf("task %v not running", *taskID)
+	// acked task deleted by same txn, room for follow-ups
+	var created []KV
+	n, size := nextSize(next)
+	code, err := putPlanned(ctx, n, size, *taskID, func(plan *putPlan) (int, error) {
+		var ops []clientv3.Op
+		var err error
+		if created, ops, err = nextTasksOps(plan.queue, next, validTrace(traceparent)); err != nil {
+			return http.StatusInternalServerError, errors.Wrap(err, "fail to add follow-up tasks")
+		}
+		resp, err := client.Txn(ctx).
+			If(append(ownerCmps(activeKey(*taskID), *clientID, token), plan.cmps...)...).
+			Then(append(append(append(append(ops, plan.ops...), rops...), releaseOps("", *taskID)...),
+				clientv3.OpDelete(cfg.Queue+":"+*taskID),
+				clientv3.OpDelete(metaKey(*taskID)),
+				clientv3.OpDelete(attemptsKey("", *taskID)),
+				clientv3.OpDelete(startedKey("", *taskID)))...).
+			Else(clientv3.OpGet(activeKey(*taskID))).
+			Commit()
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("fail to ack task %v", *taskID)
+		}
+		if !resp.Succeeded {
+			kvs := resp.Responses[0].GetResponseRange().Kvs
+			if owned(kvs, *clientID, token) {
+				return 0, errQueueChanged
+			}
+			return notOwned(kvs, *taskID, token)
+		}
+		plan.added(len(created), f)
+		return http.StatusOK, nil
+	})
+	if err != nil {
+		return code, nil, err
 	}
 
 	logger.WithFields(f).Debug("task completed")
-	return http.StatusOK, nil
+	counter("acked").Inc()
+	if next != nil {
+		return http.StatusOK, &created, nil
+	}
+	return http.StatusOK, nil, nil
 }
 
-func putTask(data *string, old *string, state *string) (int, error) {
-	var err error
-	dataKey := cfg.Queue + ":" + fmt.Sprintf("%v", time.Now().UnixNano())
+func putTask(data *string, old *string, state *string, parents *[]string, messageGroup *string, require *[]string, dedup *string, maxTime *int64, maxRenew *int64, traceparent *string, requestID *string) (int, *KV, error) {
+	task := KV{ID: newTaskID(), Value: *data, Trace: validTrace(traceparent), MessageGroup: groupName(messageGroup)}
+	required, err := checkTags(require)
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	var ps []string
+	if parents != nil {
+		ps = *parents
+	}
+	var limit, renews int64
+	if maxTime != nil {
+		limit = *maxTime
+	}
+	if maxRenew != nil {
+		renews = *maxRenew
+	}
+	meta := newMeta(ps, task.Trace, task.MessageGroup, required, limit, renews)
+	f := withRequest(log.Fields{"task": task.ID}, requestID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
 	if state == nil {
-		logger.Debug("no cas, just add a task")
-		ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
-		_, err = client.Put(ctx, dataKey, *data)
-		cancel()
+		return enqueueTask(ctx, &task, meta, dedup, nil, nil, nil, f)
+	}
+	logger.WithFields(f).Debug("with cas, start transaction")
+	cmps := []clientv3.Cmp{clientv3.Compare(clientv3.Value(stateKey()), "=", *old)}
+	ops := []clientv3.Op{clientv3.OpPut(stateKey(), *state)}
+	return enqueueTask(ctx, &task, meta, dedup, cmps, ops, func() (int, error) {
+		resp, err := client.Get(ctx, stateKey())
+		if err != nil {
+			return http.StatusInternalServerError, errors.Wrap(err, "fail to get state")
+		}
+		if len(resp.Kvs) == 0 || string(resp.Kvs[0].Value) != *old {
+			return http.StatusConflict, fmt.Errorf("fail to add task, state changed")
+		}
+		return http.StatusOK, nil
+	}, f)
+}
+
+// enqueueTask puts task with dedup and capacity check, used by put and cron.
+// cmps and ops of caller applied in same txn, `own` checks cmps if txn failed
+func enqueueTask(ctx context.Context, task *KV, meta *taskMeta, dedup *string, cmps []clientv3.Cmp, ops []clientv3.Op, own func() (int, error), f log.Fields) (int, *KV, error) {
+	window := current().DedupWindow
+	if window == 0 && dedup != nil {
+		return http.StatusBadRequest, nil, fmt.Errorf("dedup-window not set")
+	}
+	var dk string
+	var dedupOps []clientv3.Op
+	if window > 0 {
+		dk = dedupKey(task.Value, dedup)
+		if code, prev, err := duplicate(ctx, dk); prev != nil || err != nil {
+			return code, prev, err
+		}
+		lease, err := dedupLease(ctx, window)
+		if err != nil {
+			return http.StatusInternalServerError, nil, fmt.Errorf("fail to create a lease")
+		}
+		dedupOps = []clientv3.Op{clientv3.OpPut(dk, task.ID, clientv3.WithLease(lease))}
+	}
+
+	// put txn fails if caller's cmps failed, other task added while queue full or duplicate added
+	var prev *KV
+	code, err := putPlanned(ctx, 1, len(task.Value), "", func(plan *putPlan) (int, error) {
+		put, err := queueTaskOps(plan.queue, task.ID, task.Value, meta)
 		if err != nil {
 			return http.StatusInternalServerError, errors.Wrap(err, "fail to add task")
 		}
-	} else {
-		logger.Debug("with cas, start transaction")
-		ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
-		var resp *clientv3.TxnResponse
-		resp, err = client.Txn(ctx).
-			If(clientv3.Compare(clientv3.Value(stateKey()), "=", *old)).
-			Then(clientv3.OpPut(stateKey(), *state), clientv3.OpPut(dataKey, *data)).
-			Commit()
-		cancel()
+		put = append(append(append(put, plan.ops...), dedupOps...), ops...)
+		check := append(plan.cmps, cmps...)
+		if dk != "" {
+			check = append(check, clientv3.Compare(clientv3.CreateRevision(dk), "=", 0))
+		}
+		resp, err := client.Txn(ctx).If(check...).Then(put...).Commit()
 		if err != nil {
 			return http.StatusInternalServerError, errors.Wrap(err, "fail to add task")
 		}
-		if !resp.Succeeded {
-			return http.StatusConflict, errors.Wrap(err, "fail to add task")
+		if resp.Succeeded {
+			if plan.spilled {
+				task.Queue = plan.queue
+			}
+			plan.added(1, f)
+			logger.WithFields(f).Debug("task added")
+			return http.StatusOK, nil
 		}
+		if dk != "" {
+			code, dup, err := duplicate(ctx, dk)
+			if dup != nil || err != nil {
+				prev = dup
+				return code, err
+			}
+		}
+		if own != nil {
+			if code, err := own(); err != nil {
+				return code, err
+			}
+		}
+		return 0, errQueueChanged
+	})
+	if prev != nil || err != nil {
+		return code, prev, err
 	}
-	return http.StatusOK, nil
+	return http.StatusOK, task, nil
 }
 
 func getState() (int, *State, error) {
@@ -234,5 +628,5 @@ func getState() (int, *State, error) {
 		return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to get state")
 	}
 
-	return http.StatusOK, &State{string(resp.Kvs[0].Value)}, nil
+	return http.StatusOK, &State{string(resp.Kvs[0].Value), resp.Kvs[0].ModRevision, isPaused()}, nil
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// lease is task hold seen by client: from get response to sending of last successful renew or ack,
+// or to lease timeout if client crashed. server lease covers this interval,
+// so overlapping intervals of one task are double leases
+type lease struct {
+	client     string
+	token      int64
+	start, end time.Time
+	crashed    bool
+	acked      bool
+}
+
+type checker struct {
+	sync.Mutex
+	put    map[string]bool
+	leases map[string][]lease
+	acked  int
+}
+
+func newChecker() *checker {
+	return &checker{put: make(map[string]bool), leases: make(map[string][]lease)}
+}
+
+func (c *checker) addPut(id string) {
+	c.Lock()
+	c.put[id] = true
+	c.Unlock()
+}
+
+func (c *checker) addLease(id string, l lease) {
+	c.Lock()
+	defer c.Unlock()
+	if l.acked && !c.isAcked(id) {
+		c.acked++
+	}
+	c.leases[id] = append(c.leases[id], l)
+}
+
+func (c *checker) isAcked(id string) bool {
+	for _, l := range c.leases[id] {
+		if l.acked {
+			return true
+		}
+	}
+	return false
+}
+
+// done returns true if all put tasks acked
+func (c *checker) done() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.acked >= len(c.put)
+}
+
+type report struct {
+	missing     []string // put but never acked
+	overlaps    []string // held by two clients at once
+	redelivered int      // acked more than once
+	crashed     int
+}
+
+func (c *checker) check() report {
+	c.Lock()
+	defer c.Unlock()
+	var r report
+	for id := range c.put {
+		if !c.isAcked(id) {
+			r.missing = append(r.missing, id)
+		}
+	}
+	sort.Strings(r.missing)
+	for id, leases := range c.leases {
+		sort.Slice(leases, func(i, j int) bool { return leases[i].start.Before(leases[j].start) })
+		acks := 0
+		for i, l := range leases {
+			if l.acked {
+				acks++
+			}
+			if l.crashed {
+				r.crashed++
+			}
+			for _, prev := range leases[:i] {
+				if l.start.Before(prev.end) {
+					r.overlaps = append(r.overlaps, fmt.Sprintf("task %v: %v (token %v) and %v (token %v)",
+						id, prev.client, prev.token, l.client, l.token))
+				}
+			}
+		}
+		if acks > 1 {
+			r.redelivered++
+		}
+	}
+	sort.Strings(r.overlaps)
+	return r
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	assert := assert.New(t)
+	at := func(s int) time.Time { return time.Unix(int64(s), 0) }
+	c := newChecker()
+	c.addPut("1")
+	c.addPut("2")
+	c.addPut("3")
+	c.addLease("1", lease{client: "a", start: at(0), end: at(2), crashed: true})
+	c.addLease("1", lease{client: "b", start: at(3), end: at(4), acked: true})
+	c.addLease("2", lease{client: "a", start: at(0), end: at(2), acked: true})
+	c.addLease("2", lease{client: "b", start: at(1), end: at(3), acked: true})
+	assert.False(c.done())
+
+	r := c.check()
+	assert.Equal([]string{"3"}, r.missing)
+	assert.Len(r.overlaps, 1)
+	assert.Equal(1, r.redelivered)
+	assert.Equal(1, r.crashed)
+
+	c.addLease("3", lease{client: "c", start: at(5), end: at(6), acked: true})
+	assert.True(c.done())
+
+	// task leased again before crashed lease timed out
+	c.addLease("3", lease{client: "d", start: at(7), end: at(9), crashed: true})
+	c.addLease("3", lease{client: "e", start: at(8), end: at(10), acked: true})
+	r = c.check()
+	assert.Len(r.overlaps, 2)
+	assert.Equal(2, r.redelivered)
+	assert.Equal(2, r.crashed)
+}
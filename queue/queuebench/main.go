@@ -0,0 +1,214 @@
+package main
+
+// queuebench: load generator for queue api. producers put tasks, consumers get/renew/ack them,
+// some consumers crash holding a task. checks every task acked and no task held by two clients at once
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"ogogo.com/queue/client"
+)
+
+var (
+	addr      = flag.String("addr", "http://localhost:2080", "queue api address")
+	producers = flag.Int("producers", 4, "number of producers")
+	consumers = flag.Int("consumers", 8, "number of consumers")
+	tasks     = flag.Int("tasks", 1000, "tasks to put")
+	timeout   = flag.Int64("timeout", 2, "lease timeout in seconds, crashed tasks are released after it")
+	work      = flag.Duration("work", 10*time.Millisecond, "processing time of task")
+	renew     = flag.Bool("renew", true, "renew task in the middle of processing")
+	crash     = flag.Float64("crash", 0.02, "probability of consumer crash while holding a task")
+	drain     = flag.Duration("drain", time.Minute, "time to wait for all tasks acked after put")
+)
+
+// recorder collects latencies and errors per operation
+type recorder struct {
+	sync.Mutex
+	latency map[string][]time.Duration
+	errors  map[string]int
+}
+
+func (r *recorder) add(op string, start time.Time, err error) {
+	d := time.Since(start)
+	r.Lock()
+	defer r.Unlock()
+	if err != nil {
+		r.errors[op]++
+		return
+	}
+	r.latency[op] = append(r.latency[op], d)
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(float64(len(sorted)-1)*p)]
+}
+
+func (r *recorder) print(elapsed time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	fmt.Printf("%-6s %8s %6s %9s %9s %9s %9s %9s\n", "op", "ok", "errors", "ops/s", "p50", "p90", "p99", "max")
+	for _, op := range []string{"put", "get", "renew", "ack"} {
+		l := r.latency[op]
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+		fmt.Printf("%-6s %8d %6d %9.1f %9v %9v %9v %9v\n", op, len(l), r.errors[op],
+			float64(len(l))/elapsed.Seconds(), percentile(l, 0.5), percentile(l, 0.9), percentile(l, 0.99), percentile(l, 1))
+	}
+}
+
+var (
+	api *client.Client
+	rec = &recorder{latency: make(map[string][]time.Duration), errors: make(map[string]int)}
+	chk = newChecker()
+)
+
+// backoff waits before retry, for Retry-After if limit reached
+func backoff(err error) {
+	var e *client.StatusError
+	if errors.As(err, &e) && e.Code == http.StatusTooManyRequests {
+		if s, err := strconv.Atoi(e.Header.Get("Retry-After")); err == nil {
+			time.Sleep(time.Duration(s) * time.Second)
+			return
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+}
+
+func produce(n int, count int) {
+	ctx := context.Background()
+	for i := 0; i < count; i++ {
+		start := time.Now()
+		t, err := api.PutTask(ctx, fmt.Sprintf("bench-%d-%d", n, i), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+		rec.add("put", start, err)
+		if err != nil {
+			log.Printf("put: %v", err)
+			backoff(err)
+			continue
+		}
+		chk.addPut(t.ID)
+	}
+}
+
+func consume(n int, stop <-chan struct{}) {
+	ctx := context.Background()
+	restarts := 0
+	clientID := fmt.Sprintf("bench-%d-%d", n, restarts)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		start := time.Now()
+		t, err := api.GetTask(ctx, clientID, timeout, nil, nil, nil)
+		rec.add("get", start, err)
+		if err != nil || t == nil {
+			backoff(err)
+			continue
+		}
+		l := lease{client: clientID, token: t.Token, start: time.Now()}
+		l.end = l.start
+		if rand.Float64() < *crash {
+			// task left leased until timeout, consumer restarts as new client.
+			// lease granted after get sent, so it is held at least timeout from then
+			l.crashed = true
+			l.end = start.Add(time.Duration(*timeout) * time.Second)
+			chk.addLease(t.ID, l)
+			restarts++
+			clientID = fmt.Sprintf("bench-%d-%d", n, restarts)
+			continue
+		}
+
+		time.Sleep(*work / 2)
+		if *renew {
+			start = time.Now()
+			err = api.RenewTask(ctx, clientID, t.ID, nil, nil, &t.Token, nil)
+			rec.add("renew", start, err)
+			if err == nil {
+				l.end = start
+			}
+		}
+		time.Sleep(*work / 2)
+		start = time.Now()
+		_, err = api.AckTask(ctx, clientID, t.ID, nil, nil, nil, &t.Token, nil, nil)
+		rec.add("ack", start, err)
+		if err == nil {
+			l.end, l.acked = start, true
+		} else {
+			log.Printf("ack %v: %v", t.ID, err)
+		}
+		chk.addLease(t.ID, l)
+	}
+}
+
+func main() {
+	flag.Parse()
+	api = client.New(*addr)
+	api.HTTP = &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{MaxIdleConnsPerHost: *producers + *consumers}}
+	log.Printf("%v tasks, %v producers, %v consumers, crash %v", *tasks, *producers, *consumers, *crash)
+
+	start := time.Now()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < *consumers; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			consume(n, stop)
+		}(i)
+	}
+	var pwg sync.WaitGroup
+	for i := 0; i < *producers; i++ {
+		count := *tasks / *producers
+		if i < *tasks%*producers {
+			count++
+		}
+		pwg.Add(1)
+		go func(n int) {
+			defer pwg.Done()
+			produce(n, count)
+		}(i)
+	}
+	pwg.Wait()
+	log.Printf("put done in %v", time.Since(start))
+
+	deadline := time.Now().Add(*drain)
+	for !chk.done() && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	close(stop)
+	wg.Wait()
+	elapsed := time.Since(start)
+	log.Printf("done in %v", elapsed)
+	rec.print(elapsed)
+
+	r := chk.check()
+	fmt.Printf("crashed %v, acked more than once %v, not acked %v, double leases %v\n",
+		r.crashed, r.redelivered, len(r.missing), len(r.overlaps))
+	for i, id := range r.missing {
+		if i == 10 {
+			fmt.Println("...")
+			break
+		}
+		fmt.Println("not acked:", id)
+	}
+	for _, o := range r.overlaps {
+		fmt.Println("double lease:", o)
+	}
+	if len(r.missing) > 0 || len(r.overlaps) > 0 {
+		os.Exit(1)
+	}
+}
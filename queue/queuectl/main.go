@@ -0,0 +1,329 @@
+package main
+
+// queuectl: command line client for queue api, built on generated client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"ogogo.com/queue/client"
+)
+
+const usage = `usage: queuectl [flags] command [args]
+
+commands:
+  put <data> [parent-id...]     add task
+  get <client-id>               lease task for -timeout seconds
+  ack <client-id> <task-id> [result]
+  nak <client-id> <task-id>     release task, counts attempts
+  state                         current state
+  pause|resume                  stop or resume leasing tasks
+  dump                          all tasks
+  stats                         pending/active/dead counters
+  purge                         remove all tasks, dead letters and state kept
+  dlq [list|purge]              dead letter queue
+  keys                          queue keys in etcd, decoded
+  export [file]                 save queue snapshot as jsonl, stdout by default
+  import <file>                 load snapshot, ids kept unless -ids regenerate
+
+flags:
+`
+
+var (
+	addr     = flag.String("addr", "http://localhost:2080", "queue api address")
+	group    = flag.String("group", "", "consumer group, if queue is a topic (get, ack, nak, dlq)")
+	msgGroup = flag.String("message-group", "", "put: message group, tasks of group leased one at a time")
+	timeout  = flag.Int("timeout", 60, "lease timeout in seconds for get")
+	asJSON   = flag.Bool("json", false, "print json instead of table")
+	ids      = flag.String("ids", "preserve", "import: preserve or regenerate task ids")
+)
+
+// timeout of api call, export and import are not limited
+const callTimeout = 30 * time.Second
+
+// stdout is output of commands, replaced by tests
+var stdout io.Writer = os.Stdout
+
+// optional returns nil for empty flag value
+func optional(v string) *string {
+	if v == "" {
+		return nil
+	}
+	return &v
+}
+
+// explain converts error status of server to message for user
+func explain(err error) error {
+	var e *client.StatusError
+	if !errors.As(err, &e) {
+		return err
+	}
+	switch e.Code {
+	case http.StatusNotFound:
+		return fmt.Errorf("not found")
+	case http.StatusConflict:
+		return fmt.Errorf("conflict")
+	case http.StatusTooManyRequests:
+		return fmt.Errorf("limit reached, retry after %vs", e.Header.Get("Retry-After"))
+	default:
+		return fmt.Errorf("server returns %v %v", e.Code, http.StatusText(e.Code))
+	}
+}
+
+// show prints value as json or as table with given header and rows
+func show(v interface{}, header string, rows func(w *tabwriter.Writer)) {
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(v)
+		return
+	}
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, header)
+	rows(w)
+	w.Flush()
+}
+
+func printTasks(tasks []client.KV) {
+	show(tasks, "ID\tVALUE\tPROGRESS", func(w *tabwriter.Writer) {
+		for _, t := range tasks {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", t.ID, t.Value, t.Progress)
+		}
+	})
+}
+
+func printTask(t *client.KV) {
+	show(t, "ID\tVALUE\tPROGRESS", func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", t.ID, t.Value, t.Progress)
+	})
+}
+
+// export copies snapshot to file, file must end with `end` record
+func export(api *client.Client, name string) error {
+	resp, err := api.HTTP.Get(api.URL + "/api/v1/export")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returns %v", resp.Status)
+	}
+	out := stdout
+	if name != "" && name != "-" {
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var last struct{ Kind string }
+	for scanner.Scan() {
+		w.Write(scanner.Bytes())
+		w.WriteByte('\n')
+		json.Unmarshal(scanner.Bytes(), &last)
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	if last.Kind != "end" {
+		return fmt.Errorf("export incomplete, see server log")
+	}
+	return w.Flush()
+}
+
+func load(api *client.Client, name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	resp, err := api.HTTP.Post(api.URL+"/api/v1/import?"+url.Values{"ids": {*ids}}.Encode(), "application/x-ndjson", in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		State              bool
+		Tasks, Acked, Dead int
+		Conflicts          []string
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusConflict:
+		// keys already in queue reported if import refused
+		if json.NewDecoder(resp.Body).Decode(&result) == nil && len(result.Conflicts) > 0 {
+			for _, k := range result.Conflicts {
+				fmt.Fprintln(os.Stderr, k)
+			}
+			return fmt.Errorf("%d keys already exist, nothing imported, try -ids regenerate", len(result.Conflicts))
+		}
+		return fmt.Errorf("conflict, see server log")
+	default:
+		return fmt.Errorf("server returns %v", resp.Status)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	show(result, "STATE\tTASKS\tACKED\tDEAD", func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "%v\t%d\t%d\t%d\n", result.State, result.Tasks, result.Acked, result.Dead)
+	})
+	return nil
+}
+
+// errUsage is returned for unknown command or missing args
+var errUsage = errors.New("bad usage")
+
+func need(args []string, n int) error {
+	if len(args) < n {
+		return errUsage
+	}
+	return nil
+}
+
+func run(api *client.Client, args []string) error {
+	if err := need(args, 1); err != nil {
+		return err
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "export":
+		if len(args) > 0 {
+			return export(api, args[0])
+		}
+		return export(api, "")
+	case "import":
+		if err := need(args, 1); err != nil {
+			return err
+		}
+		return load(api, args[0])
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	return explain(call(ctx, api, cmd, args))
+}
+
+// call runs api command
+func call(ctx context.Context, api *client.Client, cmd string, args []string) error {
+	switch cmd {
+	case "put":
+		if err := need(args, 1); err != nil {
+			return err
+		}
+		t, err := api.PutTask(ctx, args[0], nil, nil, args[1:], optional(*msgGroup), nil, nil, nil, nil, nil, nil)
+		if err != nil {
+			return err
+		}
+		printTask(t)
+	case "get":
+		if err := need(args, 1); err != nil {
+			return err
+		}
+		lease := int64(*timeout)
+		t, err := api.GetTask(ctx, args[0], &lease, optional(*group), nil, nil)
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return fmt.Errorf("no tasks available")
+		}
+		printTask(t)
+	case "ack":
+		if err := need(args, 2); err != nil {
+			return err
+		}
+		var result *string
+		if len(args) > 2 {
+			result = &args[2]
+		}
+		_, err := api.AckTask(ctx, args[0], args[1], optional(*group), nil, result, nil, nil, nil)
+		return err
+	case "nak":
+		if err := need(args, 2); err != nil {
+			return err
+		}
+		return api.NakTask(ctx, args[0], args[1], optional(*group), nil, nil)
+	case "state":
+		s, err := api.GetState(ctx)
+		if err != nil {
+			return err
+		}
+		show(s, "STATE\tREVISION\tPAUSED", func(w *tabwriter.Writer) {
+			fmt.Fprintf(w, "%s\t%d\t%v\n", s.State, s.Revision, s.Paused)
+		})
+	case "pause":
+		return api.PauseQueue(ctx)
+	case "resume":
+		return api.ResumeQueue(ctx)
+	case "dump":
+		tasks, err := api.Dump(ctx)
+		if err != nil {
+			return err
+		}
+		printTasks(tasks)
+	case "stats":
+		stats, err := api.Stats(ctx)
+		if err != nil {
+			return err
+		}
+		show(stats, "GROUP\tPENDING\tACTIVE\tDEAD", func(w *tabwriter.Writer) {
+			for _, s := range stats {
+				fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", s.Group, s.Pending, s.Active, s.Dead)
+			}
+		})
+	case "purge":
+		return api.PurgeQueue(ctx)
+	case "dlq":
+		if len(args) > 0 && args[0] == "purge" {
+			return api.PurgeDead(ctx, optional(*group))
+		}
+		tasks, err := api.ListDead(ctx, optional(*group))
+		if err != nil {
+			return err
+		}
+		printTasks(tasks)
+	case "keys":
+		keys, err := api.ListKeys(ctx)
+		if err != nil {
+			return err
+		}
+		show(keys, "KIND\tGROUP\tID\tVALUE", func(w *tabwriter.Writer) {
+			for _, k := range keys {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", k.Kind, k.Group, k.ID, k.Value)
+			}
+		})
+	default:
+		return errUsage
+	}
+	return nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	err := run(client.New(*addr), flag.Args())
+	if err == errUsage {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "queuectl: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"ogogo.com/queue/client"
+)
+
+// served is request seen by test server
+type served struct {
+	method string
+	target string
+}
+
+// testAPI runs server answering with given status and body per path, records requests.
+// output of commands captured
+func testAPI(t *testing.T, replies map[string]func(w http.ResponseWriter)) (*client.Client, *[]served, *bytes.Buffer) {
+	t.Helper()
+	var seen []served
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, served{r.Method, r.URL.RequestURI()})
+		if reply, ok := replies[r.URL.Path]; ok {
+			reply(w)
+		}
+	}))
+	out := &bytes.Buffer{}
+	stdout = out
+	t.Cleanup(func() {
+		srv.Close()
+		stdout = os.Stdout
+		*group, *asJSON = "", false
+	})
+	return client.New(srv.URL), &seen, out
+}
+
+// trimLines drops trailing spaces of table cells
+func trimLines(s string) string {
+	lines := strings.Split(s, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func reply(code int, body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.WriteHeader(code)
+		w.Write([]byte(body))
+	}
+}
+
+func TestCommands(t *testing.T) {
+	assert := assert.New(t)
+	api, seen, out := testAPI(t, map[string]func(w http.ResponseWriter){
+		"/api/v1/put":   reply(http.StatusOK, `{"ID":"1","Value":"x"}`),
+		"/api/v1/get":   reply(http.StatusOK, `{"ID":"1","Value":"x","Token":5}`),
+		"/api/v1/state": reply(http.StatusOK, `{"State":"A","Revision":7,"Paused":true}`),
+		"/api/v1/stats": reply(http.StatusOK, `[{"Pending":2,"Active":1,"Dead":0}]`),
+	})
+	for _, args := range [][]string{
+		{"put", "x", "p1", "p2"},
+		{"get", "w"},
+		{"ack", "w", "1", "done"},
+		{"nak", "w", "1"},
+		{"state"},
+		{"stats"},
+		{"pause"},
+		{"resume"},
+		{"purge"},
+		{"dlq", "purge"},
+	} {
+		assert.Nil(run(api, args), args)
+	}
+	assert.Equal([]served{
+		{"GET", "/api/v1/put?data=x&parents=p1&parents=p2"},
+		{"GET", "/api/v1/get?client_id=w&timeout=60"},
+		{"GET", "/api/v1/ack?client_id=w&result=done&task_id=1"},
+		{"GET", "/api/v1/nak?client_id=w&task_id=1"},
+		{"GET", "/api/v1/state"},
+		{"GET", "/api/v1/stats"},
+		{"GET", "/api/v1/pause"},
+		{"GET", "/api/v1/resume"},
+		{"DELETE", "/api/v1/purge"},
+		{"DELETE", "/api/v1/dead/purge"},
+	}, *seen)
+	assert.Equal(`ID  VALUE  PROGRESS
+1   x
+ID  VALUE  PROGRESS
+1   x
+STATE  REVISION  PAUSED
+A      7         true
+GROUP  PENDING  ACTIVE  DEAD
+       2        1       0
+`, trimLines(out.String()))
+
+	// group passed to methods of consumer
+	*seen = nil
+	*group = "a"
+	assert.Nil(run(api, []string{"dlq"}))
+	assert.Nil(run(api, []string{"nak", "w", "1"}))
+	assert.Equal([]served{{"GET", "/api/v1/dead/list?group=a"}, {"GET", "/api/v1/nak?client_id=w&group=a&task_id=1"}}, *seen)
+
+	assert.Equal(errUsage, run(api, []string{"ack", "w"}))
+	assert.Equal(errUsage, run(api, []string{"nope"}))
+}
+
+func TestCommandErrors(t *testing.T) {
+	assert := assert.New(t)
+	api, _, _ := testAPI(t, map[string]func(w http.ResponseWriter){
+		"/api/v1/get": reply(http.StatusNoContent, ""),
+		"/api/v1/ack": reply(http.StatusNotFound, ""),
+		"/api/v1/put": func(w http.ResponseWriter) {
+			w.Header().Set("Retry-After", "5")
+			w.WriteHeader(http.StatusTooManyRequests)
+		},
+		"/api/v1/dump": reply(http.StatusInternalServerError, ""),
+	})
+	assert.EqualError(run(api, []string{"get", "w"}), "no tasks available")
+	assert.EqualError(run(api, []string{"ack", "w", "1"}), "not found")
+	assert.EqualError(run(api, []string{"put", "x"}), "limit reached, retry after 5s")
+	assert.EqualError(run(api, []string{"dump"}), "server returns 500 Internal Server Error")
+}
+
+func TestExportImport(t *testing.T) {
+	assert := assert.New(t)
+	snapshot := "{\"Kind\":\"header\"}\n{\"Kind\":\"end\"}\n"
+	api, seen, out := testAPI(t, map[string]func(w http.ResponseWriter){
+		"/api/v1/export": reply(http.StatusOK, snapshot),
+		"/api/v1/import": reply(http.StatusConflict, `{"Conflicts":["q:1"]}`),
+	})
+	file := filepath.Join(t.TempDir(), "queue.jsonl")
+	assert.Nil(run(api, []string{"export", file}))
+	saved, err := ioutil.ReadFile(file)
+	assert.Nil(err)
+	assert.Equal(snapshot, string(saved))
+
+	err = run(api, []string{"import", file})
+	assert.NotNil(err)
+	assert.True(strings.HasPrefix(err.Error(), "1 keys already exist"), err)
+	assert.Equal([]served{{"GET", "/api/v1/export"}, {"POST", "/api/v1/import?ids=preserve"}}, *seen)
+	assert.Empty(out.String())
+}
@@ -0,0 +1,5 @@
+//go:generate buf generate
+
+// Package queuepb is grpc interface of queue, see queue.proto.
+// generated by buf with protoc-gen-go v1.26.0 and protoc-gen-go-grpc v1.1.0
+package queuepb
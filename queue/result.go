@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// progress reported with renew lives with task lease,
+// result saved with ack lives for result-ttl
+
+const defaultResultTTL = time.Hour
+
+func progressPrefix(group string) string {
+	if group == "" {
+		return "__progress:" + cfg.Queue + ":"
+	}
+	return "__progress:" + cfg.Queue + ":" + group + ":"
+}
+
+func resultKey(group string, task string) string {
+	if group == "" {
+		return "__result:" + cfg.Queue + ":" + task
+	}
+	return "__result:" + cfg.Queue + ":" + group + ":" + task
+}
+
+func resultTTL() time.Duration {
+	if ttl := current().ResultTTL; ttl > 0 {
+		return ttl
+	}
+	return defaultResultTTL
+}
+
+// one lease for results saved within a second
+var resultLeases leaseCache
+
+// resultOps returns ops to save task result and drop progress
+func resultOps(ctx context.Context, group string, task string, result *string) ([]clientv3.Op, error) {
+	ops := []clientv3.Op{clientv3.OpDelete(progressPrefix(group) + task)}
+	if result == nil {
+		return ops, nil
+	}
+	lease, err := resultLeases.get(ctx, resultTTL(), time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return append(ops, clientv3.OpPut(resultKey(group, task), *result, clientv3.WithLease(lease))), nil
+}
+
+// saveProgress updates progress if task still owned by client with same token,
+// progress may be nil to check owner only. active key returned if not owned
+func saveProgress(ctx context.Context, group string, task string, clientID string, token int64, progress *string, lease clientv3.LeaseID) (bool, []*mvccpb.KeyValue, error) {
+	key := activePrefix(group) + task
+	var ops []clientv3.Op
+	if progress != nil {
+		ops = append(ops, clientv3.OpPut(progressPrefix(group)+task, *progress, clientv3.WithLease(lease)))
+	}
+	resp, err := client.Txn(ctx).If(ownerCmps(key, clientID, &token)...).Then(ops...).Else(clientv3.OpGet(key)).Commit()
+	if err != nil {
+		return false, nil, err
+	}
+	if !resp.Succeeded {
+		return false, resp.Responses[0].GetResponseRange().Kvs, nil
+	}
+	return true, nil, nil
+}
+
+func getResult(taskID *string, group *string) (int, *KV, error) {
+	if code, err := checkGroup(group); err != nil {
+		return code, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	resp, err := client.Get(ctx, resultKey(groupName(group), *taskID))
+	cancel()
+	if err != nil {
+		return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to get result")
+	}
+	if len(resp.Kvs) == 0 {
+		return http.StatusNotFound, nil, nil
+	}
+	return http.StatusOK, &KV{ID: *taskID, Value: string(resp.Kvs[0].Value)}, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dumped returns progress of tasks in dump
+func dumped(t *testing.T) map[string]string {
+	_, tasks, err := dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress := make(map[string]string)
+	for _, task := range *tasks {
+		progress[task.ID] = task.Progress
+	}
+	return progress
+}
+
+func TestProgressAndResult(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	id1, id2 := addTask(t, "1"), addTask(t, "2")
+	lease(t, "w1", "")
+	lease(t, "w2", "")
+
+	w1, w2, progress := "w1", "w2", "50%"
+	code, err := renewTask(&w1, &id1, nil, &progress, nil, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(map[string]string{id1: "50%", id2: ""}, dumped(t))
+	// not owner
+	_, err = renewTask(&w2, &id1, nil, &progress, nil, nil)
+	assert.NotNil(err)
+
+	result := "done"
+	code, _, err = ackTask(&w1, &id1, nil, nil, &result, nil, nil, nil)
+	assert.Nil(err)
+	code, kv, err := getResult(&id1, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(&KV{ID: id1, Value: "done"}, kv)
+	assert.Equal(map[string]string{id2: ""}, dumped(t))
+
+	// results saved in same second share lease
+	_, _, err = ackTask(&w2, &id2, nil, nil, &result, nil, nil, nil)
+	assert.Nil(err)
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	r1, err := client.Get(ctx, resultKey("", id1))
+	assert.Nil(err)
+	r2, err := client.Get(ctx, resultKey("", id2))
+	assert.Nil(err)
+	assert.Equal(r1.Kvs[0].Lease, r2.Kvs[0].Lease)
+
+	// failed ack saves no result
+	id3 := addTask(t, "3")
+	_, _, err = ackTask(&w1, &id3, nil, nil, &result, nil, nil, nil)
+	assert.NotNil(err)
+	code, _, _ = getResult(&id3, nil)
+	assert.Equal(http.StatusNotFound, code)
+}
+
+func TestProgressTopic(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t, "a", "b")
+	id := addTask(t, "1")
+	lease(t, "w1", "a")
+	lease(t, "w2", "b")
+
+	w1, w2, a, b := "w1", "w2", "a", "b"
+	p1, p2 := "10%", "90%"
+	_, err := renewTask(&w1, &id, &a, &p1, nil, nil)
+	assert.Nil(err)
+	assert.Equal(map[string]string{id: "a: 10%"}, dumped(t))
+	_, err = renewTask(&w2, &id, &b, &p2, nil, nil)
+	assert.Nil(err)
+	assert.Equal(map[string]string{id: "a: 10%, b: 90%"}, dumped(t))
+
+	result := "x"
+	_, _, err = ackTask(&w2, &id, &b, nil, &result, nil, nil, nil)
+	assert.Nil(err)
+	code, kv, _ := getResult(&id, &b)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal("x", kv.Value)
+	code, _, _ = getResult(&id, &a)
+	assert.Equal(http.StatusNotFound, code)
+	assert.Equal(map[string]string{id: "a: 10%"}, dumped(t))
+}
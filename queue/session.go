@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// sessions: client opens session with ttl, get with session holds task under session lease,
+// so one keepalive renews all held tasks and close or expiry releases them together.
+// session key kept with same lease, lock txn checks it
+
+// Session is an open session of client
+type Session struct {
+	ID       string
+	ClientID string
+	TTL      int64
+	Hold     int64 // max tasks held in session
+}
+
+// sessionInfo is value of session key
+type sessionInfo struct {
+	Client string
+	Hold   int64
+}
+
+// tasks held in session if open without hold
+const defaultHold = 100
+
+func sessionKey(id string) string {
+	return "__session:" + cfg.Queue + ":" + id
+}
+
+// loadSession returns session with its lease and key, nil if session expired
+func loadSession(ctx context.Context, session string) (*Session, clientv3.LeaseID, *mvccpb.KeyValue, error) {
+	if _, err := strconv.ParseInt(session, 16, 64); err != nil {
+		return nil, 0, nil, nil
+	}
+	resp, err := client.Get(ctx, sessionKey(session))
+	if err != nil {
+		return nil, 0, nil, errors.Wrap(err, "fail to get session")
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, 0, nil, nil
+	}
+	var info sessionInfo
+	if err = json.Unmarshal(resp.Kvs[0].Value, &info); err != nil {
+		return nil, 0, nil, errors.Wrapf(err, "bad session %v", session)
+	}
+	kv := resp.Kvs[0]
+	return &Session{ID: session, ClientID: info.Client, Hold: info.Hold}, clientv3.LeaseID(kv.Lease), kv, nil
+}
+
+// sessionLease returns client session with its lease and cmp to check session alive in lock txn
+func sessionLease(ctx context.Context, clientID string, session string) (int, *Session, clientv3.LeaseID, clientv3.Cmp, error) {
+	s, lease, kv, err := loadSession(ctx, session)
+	if err != nil {
+		return http.StatusInternalServerError, nil, 0, clientv3.Cmp{}, err
+	}
+	if s == nil {
+		return http.StatusNotFound, nil, 0, clientv3.Cmp{}, fmt.Errorf("session %v expired", session)
+	}
+	if s.ClientID != clientID {
+		return http.StatusNotFound, nil, 0, clientv3.Cmp{}, fmt.Errorf("session %v opened by other client", session)
+	}
+	return http.StatusOK, s, lease, clientv3.Compare(clientv3.ModRevision(sessionKey(session)), "=", kv.ModRevision), nil
+}
+
+func openSession(clientID *string, ttl *int64, hold *int64) (int, *Session, error) {
+	info := sessionInfo{Client: *clientID, Hold: defaultHold}
+	if hold != nil {
+		info.Hold = *hold
+	}
+	value, err := json.Marshal(info)
+	if err != nil {
+		return http.StatusInternalServerError, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	lease, err := client.Grant(ctx, *ttl)
+	if err != nil {
+		return http.StatusInternalServerError, nil, fmt.Errorf("fail to create a lease")
+	}
+	s := &Session{ID: strconv.FormatInt(int64(lease.ID), 16), ClientID: *clientID, TTL: *ttl, Hold: info.Hold}
+	if _, err = client.Put(ctx, sessionKey(s.ID), string(value), clientv3.WithLease(lease.ID)); err != nil {
+		client.Revoke(ctx, lease.ID)
+		return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to open session")
+	}
+	logger.WithField("client", *clientID).WithField("session", s.ID).Debug("session opened")
+	return http.StatusOK, s, nil
+}
+
+func keepaliveSession(clientID *string, session *string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	code, _, lease, _, err := sessionLease(ctx, *clientID, *session)
+	if err != nil {
+		return code, err
+	}
+	if _, err = client.KeepAliveOnce(ctx, lease); err != nil {
+		return http.StatusNotFound, fmt.Errorf("session %v expired", *session)
+	}
+	return http.StatusOK, nil
+}
+
+// closeSession revokes session lease, held tasks released
+func closeSession(clientID *string, session *string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	code, s, lease, _, err := sessionLease(ctx, *clientID, *session)
+	if err != nil {
+		return code, err
+	}
+	if _, err = client.Revoke(ctx, lease); err != nil {
+		return http.StatusInternalServerError, errors.Wrap(err, "fail to close session")
+	}
+	logger.WithField("client", s.ClientID).WithField("session", s.ID).Debug("session closed")
+	return http.StatusOK, nil
+}
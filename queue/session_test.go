@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestSession(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	for i := 0; i < 3; i++ {
+		addTask(t, "x")
+	}
+	w, other, ttl, hold := "w", "other", int64(10), int64(2)
+	code, s, err := openSession(&w, &ttl, &hold)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(Session{ID: s.ID, ClientID: w, TTL: ttl, Hold: hold}, *s)
+
+	// up to hold tasks under session, session of other client rejected
+	get := func(clientID string) int {
+		code, _, _ := getTask(&clientID, nil, nil, &s.ID, nil)
+		return code
+	}
+	assert.Equal(http.StatusOK, get(w))
+	assert.Equal(http.StatusOK, get(w))
+	assert.Equal(http.StatusConflict, get(w))
+	assert.Equal(http.StatusNotFound, get(other))
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	active := func() int {
+		resp, err := client.Get(ctx, activePrefix(""), clientv3.WithPrefix())
+		assert.Nil(err)
+		return len(resp.Kvs)
+	}
+	assert.Equal(2, active())
+
+	// only owner keeps or closes session
+	code, _ = keepaliveSession(&other, &s.ID)
+	assert.Equal(http.StatusNotFound, code)
+	code, err = keepaliveSession(&w, &s.ID)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	code, _ = closeSession(&other, &s.ID)
+	assert.Equal(http.StatusNotFound, code)
+	assert.Equal(2, active())
+
+	code, err = closeSession(&w, &s.ID)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(0, active())
+	code, _ = keepaliveSession(&w, &s.ID)
+	assert.Equal(http.StatusNotFound, code)
+	bad := "not-a-session"
+	code, _ = closeSession(&w, &bad)
+	assert.Equal(http.StatusNotFound, code)
+}
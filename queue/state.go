@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// max time to wait in long poll, server WriteTimeout must be greater
+const maxPoll = 25 * time.Second
+
+// state transitions returned by history, every one costs two etcd reads
+const (
+	defaultHistory = 10
+	maxHistory     = 100
+)
+
+// StateChange XXX
+type StateChange struct {
+	State    string
+	Revision int64
+	Tasks    []string
+}
+
+func watchState(revision *int64, timeout *int64) (int, *State, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	resp, err := client.Get(ctx, stateKey())
+	cancel()
+	if err != nil {
+		return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to get state")
+	}
+	kv := resp.Kvs[0]
+	if revision == nil || kv.ModRevision > *revision {
+		return http.StatusOK, &State{string(kv.Value), kv.ModRevision, isPaused()}, nil
+	}
+
+	wait := maxPoll
+	if timeout != nil && time.Duration(*timeout)*time.Second < wait {
+		wait = time.Duration(*timeout) * time.Second
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), wait)
+	defer cancel()
+
+	// state not changed up to revision we read it at
+	wch := client.Watch(clientv3.WithRequireLeader(ctx), stateKey(), clientv3.WithRev(resp.Header.Revision+1))
+	for w := range wch {
+		if err := w.Err(); err != nil {
+			return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to watch state")
+		}
+		for _, ev := range w.Events {
+			return http.StatusOK, &State{string(ev.Kv.Value), ev.Kv.ModRevision, isPaused()}, nil
+		}
+	}
+	return http.StatusNoContent, nil, nil
+}
+
+func stateHistory(limit *int64) (int, *[]StateChange, error) {
+	n := int64(defaultHistory)
+	if limit != nil {
+		n = *limit
+	}
+	if n > maxHistory {
+		n = maxHistory
+	}
+	result := make([]StateChange, 0, n)
+	prefixLen := len(cfg.Queue) + 1 // to skip `:`
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	// walk back over state versions, tasks put in same txn have same revision
+	var rev int64
+	for int64(len(result)) < n {
+		resp, err := client.Get(ctx, stateKey(), clientv3.WithRev(rev))
+		if err == rpctypes.ErrCompacted {
+			break
+		}
+		if err != nil {
+			return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to get state")
+		}
+		if len(resp.Kvs) == 0 {
+			break
+		}
+		kv := resp.Kvs[0]
+		change := StateChange{State: string(kv.Value), Revision: kv.ModRevision, Tasks: []string{}}
+
+		tasks, err := client.Get(ctx, cfg.Queue+":", clientv3.WithPrefix(), clientv3.WithKeysOnly(),
+			clientv3.WithRev(kv.ModRevision), clientv3.WithMinModRev(kv.ModRevision), clientv3.WithMaxModRev(kv.ModRevision))
+		if err == rpctypes.ErrCompacted {
+			break
+		}
+		if err != nil {
+			return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to get tasks")
+		}
+		for _, t := range tasks.Kvs {
+			change.Tasks = append(change.Tasks, string(t.Key)[prefixLen:])
+		}
+		result = append(result, change)
+
+		if kv.Version == 1 {
+			break // state created
+		}
+		rev = kv.ModRevision - 1
+	}
+
+	if len(result) == 0 {
+		return http.StatusGone, nil, errors.New("state history compacted")
+	}
+	return http.StatusOK, &result, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateWatch(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	code, state, err := watchState(nil, nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal("", state.State)
+
+	// not changed in timeout
+	timeout := int64(1)
+	code, _, err = watchState(&state.Revision, &timeout)
+	assert.Nil(err)
+	assert.Equal(http.StatusNoContent, code)
+
+	// put joined before test ends, queue removed after it
+	done := make(chan error, 1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		data, old, next := "x", "", "A"
+		_, _, err := putTask(&data, &old, &next, nil, nil, nil, nil, nil, nil, nil, nil)
+		done <- err
+	}()
+	timeout = 5
+	code, changed, err := watchState(&state.Revision, &timeout)
+	assert.Nil(<-done)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal("A", changed.State)
+	assert.Greater(changed.Revision, state.Revision)
+
+	// changed before watch
+	code, changed, _ = watchState(&state.Revision, &timeout)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal("A", changed.State)
+}
+
+func TestStateHistory(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	addTask(t, "no state")
+	data, old, next := "x", "", "A"
+	_, a, err := putTask(&data, &old, &next, nil, nil, nil, nil, nil, nil, nil, nil)
+	assert.Nil(err)
+	old, next = "A", "B"
+	_, b, err := putTask(&data, &old, &next, nil, nil, nil, nil, nil, nil, nil, nil)
+	assert.Nil(err)
+	_, _, err = putTask(&data, &old, &next, nil, nil, nil, nil, nil, nil, nil, nil)
+	assert.NotNil(err) // state changed
+
+	code, history, err := stateHistory(nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Len(*history, 3)
+	for i, want := range []struct {
+		state string
+		tasks []string
+	}{{"B", []string{b.ID}}, {"A", []string{a.ID}}, {"", []string{}}} {
+		assert.Equal(want.state, (*history)[i].State)
+		assert.Equal(want.tasks, (*history)[i].Tasks)
+	}
+
+	limit := int64(1)
+	_, history, _ = stateHistory(&limit)
+	assert.Len(*history, 1)
+	limit = 1 << 40 // capped, not allocated
+	_, history, _ = stateHistory(&limit)
+	assert.Len(*history, 3)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// Stats is a task counters for queue or topic group
+type Stats struct {
+	Group   string `json:",omitempty"`
+	Pending int64
+	Active  int64
+	Dead    int64
+}
+
+// InternalKey is decoded etcd key used by queue
+type InternalKey struct {
+	Kind  string
+	Group string `json:",omitempty"`
+	ID    string `json:",omitempty"`
+	Value string
+}
+
+func countKeys(ctx context.Context, key string, end string) (int64, error) {
+	resp, err := client.Get(ctx, key, clientv3.WithRange(end), clientv3.WithCountOnly())
+	if err != nil {
+		return 0, err
+	}
+	return resp.Count, nil
+}
+
+func countPrefix(ctx context.Context, prefix string) (int64, error) {
+	return countKeys(ctx, prefix, clientv3.GetPrefixRangeEnd(prefix))
+}
+
+func groupStats(ctx context.Context, group string) (*Stats, error) {
+	s := &Stats{Group: group}
+	prefix := cfg.Queue + ":"
+	var total, acked int64
+	var err error
+	if group == "" {
+		if total, err = countPrefix(ctx, prefix); err != nil {
+			return nil, err
+		}
+	} else {
+		cursor, err := client.Get(ctx, cursorKey(group))
+		if err != nil {
+			return nil, err
+		}
+		var after int64
+		if len(cursor.Kvs) > 0 {
+			after = parseRevision(cursor.Kvs[0].Value)
+		}
+		// etcd counts keys before revision filters, so keys read
+		tasks, err := client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly(), clientv3.WithMinCreateRev(after+1))
+		if err != nil {
+			return nil, err
+		}
+		total = int64(len(tasks.Kvs))
+		markers, err := client.Get(ctx, groupAckedPrefix(group), clientv3.WithPrefix())
+		if err != nil {
+			return nil, err
+		}
+		for _, ev := range markers.Kvs {
+			if parseRevision(ev.Value) > after {
+				acked++
+			}
+		}
+	}
+	if s.Active, err = countPrefix(ctx, activePrefix(group)); err != nil {
+		return nil, err
+	}
+	if s.Dead, err = countPrefix(ctx, deadPrefix(group)); err != nil {
+		return nil, err
+	}
+	s.Pending = total - acked - s.Active
+	return s, nil
+}
+
+func stats() (int, *[]Stats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	result := make([]Stats, 0, len(groups()))
+	for _, g := range groups() {
+		s, err := groupStats(ctx, g)
+		if err != nil {
+			return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to get stats")
+		}
+		result = append(result, *s)
+	}
+	return http.StatusOK, &result, nil
+}
+
+// keyKind is a key family, grouped families have group name after queue name
+type keyKind struct {
+	name    string
+	prefix  func(group string) string
+	grouped bool
+}
+
+func keyKinds() []keyKind {
+	return []keyKind{
+		{"task", func(string) string { return cfg.Queue + ":" }, false},
+		{"meta", func(string) string { return metaPrefix() }, false},
+		{"active", activePrefix, true},
+		{"acked", groupAckedPrefix, true},
+		{"progress", progressPrefix, true},
+		{"result", func(g string) string { return resultKey(g, "") }, true},
+		{"attempts", func(g string) string { return attemptsKey(g, "") }, true},
+		{"started", func(g string) string { return startedKey(g, "") }, true},
+		{"dead", deadPrefix, true},
+		{"cursor", func(string) string { return cursorPrefix() }, false},
+		{"cron", func(string) string { return cronPrefix() }, false},
+		{"cronrun", func(string) string { return cronRunKey("") }, false},
+		{"config", func(string) string { return configKey() }, false},
+		{"worker", func(string) string { return workerPrefix() }, false},
+		{"dedup", func(string) string { return dedupPrefix() }, false},
+	}
+}
+
+// listKeys returns all queue keys in etcd with decoded kind, group and id
+func listKeys() (int, *[]InternalKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	result := []InternalKey{}
+
+	for _, single := range []struct{ kind, key string }{
+		{"state", stateKey()}, {"bucket", bucketKey()}, {"leader", leaderKey()}, {"paused", pausedKey()},
+	} {
+		resp, err := client.Get(ctx, single.key)
+		if err != nil {
+			return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to get keys")
+		}
+		for _, ev := range resp.Kvs {
+			result = append(result, InternalKey{Kind: single.kind, Value: string(ev.Value)})
+		}
+	}
+
+	for _, k := range keyKinds() {
+		for _, g := range groups() {
+			if !k.grouped && g != groups()[0] {
+				break
+			}
+			// plain queue have no acked markers
+			if k.name == "acked" && g == "" {
+				break
+			}
+			prefix := k.prefix(g)
+			resp, err := client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+			if err != nil {
+				return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to get keys")
+			}
+			for _, ev := range resp.Kvs {
+				key := InternalKey{Kind: k.name, ID: strings.TrimPrefix(string(ev.Key), prefix), Value: string(ev.Value)}
+				if k.grouped {
+					key.Group = g
+				}
+				if k.name == "cursor" { // cursor key is a group name
+					key.Group, key.ID = key.ID, ""
+				}
+				result = append(result, key)
+			}
+		}
+	}
+	return http.StatusOK, &result, nil
+}
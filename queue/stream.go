@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/v3"
+	"golang.org/x/net/websocket"
+)
+
+// streaming consumer: tasks pushed over websocket (or grpc Consume) up to prefetch count,
+// all tasks held under one lease released when connection dropped
+
+// StreamMsg is a message in stream, Op is `task` from server and `ack`/`renew` from client.
+// server replies to ack/renew with same Op and Code, renew also sends 410 with ID of task released by limits
+type StreamMsg struct {
+	Op    string
+	ID    string `json:",omitempty"`
+	Value string `json:",omitempty"`
+	Trace string `json:",omitempty"`
+	Token int64  `json:",omitempty"` // fencing token in task, on ack token of sent task used if not set
+	Code  int    `json:",omitempty"`
+}
+
+// how often to look for tasks if no etcd events
+const streamPoll = time.Second
+
+type stream struct {
+	send     func(StreamMsg) error
+	clientID string
+	group    *string
+	prefetch int
+	timeout  int64
+	lease    clientv3.LeaseID
+	held     map[string]int64 // task id -> token
+	log      *log.Entry
+}
+
+// AddStreamRoute registers streaming consumer in router
+func AddStreamRoute(r *mux.Router) {
+	r.Path("/api/v1/stream").Methods("get").Handler(websocket.Server{Handler: streamTasks})
+}
+
+func streamTasks(ws *websocket.Conn) {
+	defer ws.Close()
+	s, err := wsStream(ws.Request().URL.Query())
+	if err != nil {
+		logger.WithField("method", "/stream").Warn(err)
+		websocket.JSON.Send(ws, StreamMsg{Op: "error", Value: err.Error(), Code: http.StatusBadRequest})
+		return
+	}
+	// server timeouts are for plain requests, stream lives until client go away
+	ws.SetDeadline(time.Time{})
+	s.send = func(m StreamMsg) error {
+		return websocket.JSON.Send(ws, m)
+	}
+
+	incoming := make(chan StreamMsg)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(incoming)
+		for {
+			var m StreamMsg
+			if err := websocket.JSON.Receive(ws, &m); err != nil {
+				return
+			}
+			select {
+			case incoming <- m:
+			case <-done:
+				return
+			}
+		}
+	}()
+	s.run(incoming)
+}
+
+// run sends tasks and handles client messages until incoming closed
+func (s *stream) run(incoming <-chan StreamMsg) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// released all together
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+		client.Revoke(ctx, s.lease)
+		cancel()
+		s.log.Debug("stream closed")
+	}()
+	s.log.Debug("stream opened")
+
+	// wake up on new tasks and released leases
+	events := client.Watch(ctx, cfg.Queue+":", clientv3.WithPrefix(), clientv3.WithFilterDelete())
+	released := client.Watch(ctx, activePrefix(groupName(s.group)), clientv3.WithPrefix(), clientv3.WithFilterPut())
+	resumed := client.Watch(ctx, pausedKey(), clientv3.WithFilterPut())
+	ticker := time.NewTicker(streamPoll)
+	defer ticker.Stop()
+
+	for {
+		if err := s.fill(); err != nil {
+			s.log.Warn(err)
+			return
+		}
+		select {
+		case m, ok := <-incoming:
+			if !ok {
+				return
+			}
+			if err := s.handle(m); err != nil {
+				s.log.Warn(err)
+				return
+			}
+		case _, ok := <-events:
+			if !ok {
+				events = nil
+			}
+		case _, ok := <-released:
+			if !ok {
+				released = nil
+			}
+		case _, ok := <-resumed:
+			if !ok {
+				resumed = nil
+			}
+		case <-ticker.C:
+			if len(s.held) == 0 {
+				continue
+			}
+			if err := s.sync(); err != nil {
+				s.log.Warn(err)
+				return
+			}
+		}
+	}
+}
+
+// wsStream opens stream with params from websocket url
+func wsStream(q url.Values) (*stream, error) {
+	timeout, err := strconv.ParseInt(q.Get("timeout"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad param timeout")
+	}
+	prefetch := 1
+	if _, ok := q["prefetch"]; ok {
+		if prefetch, err = strconv.Atoi(q.Get("prefetch")); err != nil {
+			return nil, fmt.Errorf("bad param prefetch")
+		}
+	}
+	var group *string
+	if _, ok := q["group"]; ok {
+		g := q.Get("group")
+		group = &g
+	}
+	return newStream(q.Get("client_id"), timeout, prefetch, group)
+}
+
+// newStream creates lease for stream tasks, send must be set before run
+func newStream(clientID string, timeout int64, prefetch int, group *string) (*stream, error) {
+	s := &stream{clientID: clientID, group: group, prefetch: prefetch, timeout: timeout, held: make(map[string]int64)}
+	if s.clientID == "" {
+		return nil, fmt.Errorf("no required param client_id")
+	}
+	if timeout < 1 {
+		return nil, fmt.Errorf("bad param timeout")
+	}
+	if s.prefetch < 1 {
+		return nil, fmt.Errorf("bad param prefetch")
+	}
+	if _, err := checkGroup(s.group); err != nil {
+		return nil, err
+	}
+	s.log = logger.WithFields(log.Fields{"client": s.clientID, "group": groupName(s.group)})
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	lease, err := client.Grant(ctx, timeout)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("fail to create a lease")
+	}
+	s.lease = lease.ID
+	return s, nil
+}
+
+// sync drops tasks released not by stream: lease expired after missed renew, task released by
+// deadline or purged. new lease granted if old one expired
+func (s *stream) sync() error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	ttl, err := client.TimeToLive(ctx, s.lease)
+	if err != nil {
+		return fmt.Errorf("fail to check lease: %v", err)
+	}
+	if ttl.TTL <= 0 {
+		lease, err := client.Grant(ctx, s.timeout)
+		if err != nil {
+			return fmt.Errorf("fail to create a lease")
+		}
+		s.log.WithField("tasks", len(s.held)).Warn("stream lease expired, tasks released")
+		s.lease = lease.ID
+		s.held = make(map[string]int64)
+		return nil
+	}
+
+	prefix := activePrefix(groupName(s.group))
+	resp, err := client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return fmt.Errorf("fail to get running tasks")
+	}
+	held := make(map[string]int64, len(s.held))
+	for _, ev := range resp.Kvs {
+		if clientv3.LeaseID(ev.Lease) == s.lease {
+			held[string(ev.Key)[len(prefix):]] = ev.CreateRevision
+		}
+	}
+	s.held = held
+	return nil
+}
+
+// fill sends tasks to client until prefetch reached or queue is empty
+func (s *stream) fill() error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	conflicts := 0
+	for len(s.held) < s.prefetch {
+		code, t, err := findTask(ctx, s.clientID, groupName(s.group), s.prefetch)
+		if t == nil {
+			if code == http.StatusInternalServerError {
+				return err
+			}
+			return nil
+		}
+		code, err = lockTask(ctx, s.clientID, groupName(s.group), t, s.lease)
+		if code == http.StatusConflict {
+			// leased by someone else, try next. or try on next wake up if other clients are faster
+			if conflicts++; conflicts == lockAttempts {
+				return nil
+			}
+			continue
+		}
+		if code != http.StatusOK {
+			return err
+		}
+		if err := s.send(StreamMsg{Op: "task", ID: t.ID, Value: t.Value, Trace: t.Trace, Token: t.Token}); err != nil {
+			return err
+		}
+		s.held[t.ID] = t.Token
+		s.log.WithField("task", t.ID).Debug("task sent")
+	}
+	return nil
+}
+
+func (s *stream) handle(m StreamMsg) error {
+	switch m.Op {
+	case "ack":
+		token, ok := s.held[m.ID]
+		if !ok {
+			// not sent by this stream or released already
+			return s.send(StreamMsg{Op: m.Op, ID: m.ID, Code: http.StatusNotFound})
+		}
+		if m.Token != 0 {
+			token = m.Token
+		}
+		code, _, err := ackTask(&s.clientID, &m.ID, s.group, nil, nil, &token, nil, nil)
+		if err == nil {
+			delete(s.held, m.ID)
+		} else {
+			s.log.WithField("task", m.ID).Warn(err)
+			// task may be released already
+			if err := s.sync(); err != nil {
+				return err
+			}
+		}
+		return s.send(StreamMsg{Op: m.Op, ID: m.ID, Code: code})
+	case "renew":
+		// limits checked as by renew of task
+		for id, token := range s.held {
+			ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+			code, err := countRenew(ctx, s.clientID, groupName(s.group), id, token)
+			cancel()
+			if err != nil {
+				s.log.WithField("task", id).Warn(err)
+			}
+			if code == http.StatusGone {
+				delete(s.held, id)
+				if err := s.send(StreamMsg{Op: m.Op, ID: id, Code: code}); err != nil {
+					return err
+				}
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+		_, err := client.KeepAliveOnce(ctx, s.lease)
+		cancel()
+		if err == rpctypes.ErrLeaseNotFound {
+			// renew missed, tasks released. stream goes on with new lease
+			if err := s.sync(); err != nil {
+				return err
+			}
+			return s.send(StreamMsg{Op: m.Op, Code: http.StatusNotFound})
+		}
+		if err != nil {
+			s.send(StreamMsg{Op: m.Op, Code: http.StatusInternalServerError})
+			return fmt.Errorf("fail to refresh: %v", err)
+		}
+		return s.send(StreamMsg{Op: m.Op, Code: http.StatusOK})
+	}
+	return s.send(StreamMsg{Op: m.Op, ID: m.ID, Code: http.StatusBadRequest})
+}
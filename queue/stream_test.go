@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// testStream runs stream, messages sent by server are returned by recv
+func testStream(t *testing.T, s *stream) (chan<- StreamMsg, func() StreamMsg, <-chan struct{}) {
+	sent := make(chan StreamMsg, 10)
+	s.send = func(m StreamMsg) error {
+		sent <- m
+		return nil
+	}
+	incoming := make(chan StreamMsg)
+	done := make(chan struct{})
+	go func() {
+		s.run(incoming)
+		close(done)
+	}()
+	recv := func() StreamMsg {
+		t.Helper()
+		select {
+		case m := <-sent:
+			return m
+		case <-time.After(5 * time.Second):
+			t.Fatal("no message from stream")
+		}
+		return StreamMsg{}
+	}
+	return incoming, recv, done
+}
+
+func TestStream(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	id1, id2 := addTask(t, "1"), addTask(t, "2")
+
+	s, err := newStream("w", 5, 1, nil)
+	assert.Nil(err)
+	lease := s.lease
+	incoming, recv, done := testStream(t, s)
+
+	m := recv()
+	assert.Equal(StreamMsg{Op: "task", ID: id1, Value: "1", Token: m.Token}, m)
+	incoming <- StreamMsg{Op: "renew"}
+	assert.Equal(StreamMsg{Op: "renew", Code: http.StatusOK}, recv())
+
+	// failed ack keeps held task, no more tasks sent over prefetch
+	incoming <- StreamMsg{Op: "ack", ID: id2}
+	assert.Equal(StreamMsg{Op: "ack", ID: id2, Code: http.StatusNotFound}, recv())
+	incoming <- StreamMsg{Op: "ack", ID: id1, Token: m.Token + 1}
+	assert.Equal(StreamMsg{Op: "ack", ID: id1, Code: http.StatusConflict}, recv())
+
+	// lease expired: task released and sent again under new lease
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	_, err = client.Revoke(ctx, lease)
+	assert.Nil(err)
+	m2 := recv()
+	assert.Equal(id1, m2.ID)
+	assert.NotEqual(m.Token, m2.Token)
+
+	// token of sent task used if not set
+	incoming <- StreamMsg{Op: "ack", ID: id1}
+	assert.Equal(StreamMsg{Op: "ack", ID: id1, Code: http.StatusOK}, recv())
+	assert.Equal(id2, recv().ID)
+
+	// tasks released when stream closed
+	close(incoming)
+	<-done
+	resp, err := client.Get(ctx, activePrefix(""), clientv3.WithPrefix())
+	assert.Nil(err)
+	assert.Empty(resp.Kvs)
+	assert.Equal([]string{id2}, taskKeys(t))
+}
+
+func TestStreamSendError(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	addTask(t, "1")
+
+	s, err := newStream("w", 5, 1, nil)
+	assert.Nil(err)
+	s.send = func(StreamMsg) error { return fmt.Errorf("connection closed") }
+	done := make(chan struct{})
+	go func() {
+		s.run(make(chan StreamMsg))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream not closed on send error")
+	}
+
+	_, err = newStream("w", 0, 1, nil)
+	assert.EqualError(err, "bad param timeout")
+	_, err = newStream("w", 5, 1, new(string))
+	assert.EqualError(err, "queue is not a topic")
+}
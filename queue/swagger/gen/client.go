@@ -0,0 +1,350 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// model is go struct generated from object schema
+type model struct {
+	Name   string
+	Schema *Schema
+}
+
+// fieldType returns go type of property, inline objects added to models as Parent+Field
+func fieldType(parent, name string, s *Schema, models *[]model) (string, error) {
+	switch {
+	case s.Ref != "":
+		return schemaType(s)
+	case s.Type == "object":
+		n := parent + goName(name)
+		*models = append(*models, model{Name: n, Schema: s})
+		return n, nil
+	case s.Type == "array":
+		if s.Items == nil {
+			return "", fmt.Errorf("%v.%v: array without items", parent, name)
+		}
+		t, err := fieldType(parent, name+"_item", s.Items, models)
+		return "[]" + t, err
+	}
+	return scalarType(s.Type)
+}
+
+func (p *printer) model(m model, models *[]model) error {
+	names, props, err := m.Schema.properties()
+	if err != nil {
+		return fmt.Errorf("%v: %v", m.Name, err)
+	}
+	if m.Schema.Description != "" {
+		p.line("// %s %s", m.Name, m.Schema.Description)
+	} else {
+		p.line("// %s is defined by spec", m.Name)
+	}
+	p.line("type %s struct {", m.Name)
+	for _, n := range names {
+		t, err := fieldType(m.Name, n, props[n], models)
+		if err != nil {
+			return fmt.Errorf("%v: %v", m.Name, err)
+		}
+		tag := n
+		if !m.Schema.required(n) {
+			tag += ",omitempty"
+			if !strings.HasPrefix(t, "[]") && formatValue(t, "x") == "x" && t != "string" {
+				t = "*" + t // optional object
+			}
+		}
+		if d := props[n].Description; d != "" {
+			p.line("// %s", d)
+		}
+		p.line("%s %s `json:%q`", goName(n), t, tag)
+	}
+	p.line("}")
+	p.line("")
+	return nil
+}
+
+// formatValue returns expression converting value of go type t to string
+func formatValue(t, v string) string {
+	switch t {
+	case "int64":
+		return fmt.Sprintf("strconv.FormatInt(%s, 10)", v)
+	case "float64":
+		return fmt.Sprintf("strconv.FormatFloat(%s, 'f', -1, 64)", v)
+	case "bool":
+		return fmt.Sprintf("strconv.FormatBool(%s)", v)
+	}
+	return v
+}
+
+// clientParams returns method arguments: required values, optional pointers, arrays as slices
+func clientParams(op *operation) string {
+	params := []string{"ctx context.Context"}
+	for _, pr := range op.Params {
+		switch {
+		case pr.In == "body":
+			params = append(params, pr.Var+" *"+pr.Type)
+		case strings.HasPrefix(pr.Type, "[]"), pr.Required:
+			params = append(params, pr.Var+" "+pr.Type)
+		default:
+			params = append(params, pr.Var+" *"+pr.Type)
+		}
+	}
+	return strings.Join(params, ", ")
+}
+
+func (p *printer) clientParam(pr *param) {
+	var target string
+	switch pr.In {
+	case "body":
+		p.line("req.body = %s", pr.Var)
+		return
+	case "path":
+		p.line("req.path = strings.Replace(req.path, %q, url.PathEscape(%s), 1)", "{"+pr.Name+"}", formatValue(pr.Type, pr.Var))
+		return
+	case "header":
+		target = fmt.Sprintf("req.header.Add(%q, %%s)", canonicalHeader(pr.Name))
+	case "formData":
+		target = fmt.Sprintf("req.form.Add(%q, %%s)", pr.Name)
+	default:
+		target = fmt.Sprintf("req.query.Add(%q, %%s)", pr.Name)
+	}
+	switch {
+	case strings.HasPrefix(pr.Type, "[]"):
+		p.line("for _, x := range %s {", pr.Var)
+		p.line(target, formatValue(pr.Type[2:], "x"))
+		p.line("}")
+	case pr.Required:
+		p.line(target, formatValue(pr.Type, pr.Var))
+	default:
+		p.line("if %s != nil {", pr.Var)
+		p.line(target, formatValue(pr.Type, "*"+pr.Var))
+		p.line("}")
+	}
+}
+
+func (p *printer) clientMethod(s *Spec, rt *Route, op *operation) error {
+	responses, err := rt.responses()
+	if err != nil {
+		return err
+	}
+	result := "error"
+	switch {
+	case strings.HasPrefix(op.Response, "[]"):
+		result = fmt.Sprintf("(%s, error)", op.Response)
+	case op.Response != "":
+		result = fmt.Sprintf("(*%s, error)", op.Response)
+	}
+
+	p.line("// %s %s", op.Method, op.Summary)
+	p.line("func (c *Client) %s(%s) %s {", op.Method, clientParams(op), result)
+	p.line("req := request{method: %q, path: %q, query: url.Values{}, header: http.Header{}}", strings.ToUpper(rt.Method), s.basePath()+rt.Path)
+	for _, pr := range op.Params {
+		if pr.In == "formData" {
+			p.line("req.form = url.Values{}")
+			break
+		}
+	}
+	for i := range op.Params {
+		p.clientParam(&op.Params[i])
+	}
+	var errs []string
+	for _, r := range responses {
+		rs, err := r.schema()
+		if err != nil {
+			return fmt.Errorf("%v: %v", op.ID, err)
+		}
+		if r.Code < 300 || rs == nil {
+			continue
+		}
+		t, err := schemaType(rs)
+		if err != nil {
+			return fmt.Errorf("%v: %v", op.ID, err)
+		}
+		if strings.HasPrefix(t, "[]") {
+			errs = append(errs, fmt.Sprintf("%d: &%s{}", r.Code, t))
+		} else {
+			errs = append(errs, fmt.Sprintf("%d: new(%s)", r.Code, t))
+		}
+	}
+	if len(errs) > 0 {
+		p.line("req.errs = map[int]interface{}{%s}", strings.Join(errs, ", "))
+	}
+
+	switch {
+	case op.Response == "":
+		p.line("_, err := c.do(ctx, &req)")
+		p.line("return err")
+	case strings.HasPrefix(op.Response, "[]"):
+		p.line("var resp %s", op.Response)
+		p.line("req.out = &resp")
+		p.line("_, err := c.do(ctx, &req)")
+		p.line("return resp, err")
+	default:
+		p.line("var resp %s", op.Response)
+		p.line("req.out = &resp")
+		p.line("found, err := c.do(ctx, &req)")
+		p.line("if err != nil || !found {")
+		p.line("return nil, err")
+		p.line("}")
+		p.line("return &resp, nil")
+	}
+	p.line("}")
+	p.line("")
+	return nil
+}
+
+// client runtime, same for any spec
+const clientRuntime = `// Client calls api server
+type Client struct {
+	URL  string // server address, like http://localhost:2080
+	HTTP *http.Client
+}
+
+// New creates client with default http client
+func New(url string) *Client {
+	return &Client{URL: strings.TrimSuffix(url, "/"), HTTP: http.DefaultClient}
+}
+
+// StatusError is returned if server responds with error code
+type StatusError struct {
+	Code   int
+	Header http.Header
+	Body   []byte
+	Value  interface{} // decoded body if response schema defined for code
+}
+
+func (e *StatusError) Error() string {
+	if len(e.Body) > 0 {
+		return fmt.Sprintf("status %d: %s", e.Code, bytes.TrimSpace(e.Body))
+	}
+	return fmt.Sprintf("status %d", e.Code)
+}
+
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	form   url.Values
+	body   interface{}
+	out    interface{}
+	errs   map[int]interface{}
+}
+
+// do sends request and decodes response, returns true if response body decoded into out
+func (c *Client) do(ctx context.Context, req *request) (bool, error) {
+	var body io.Reader
+	contentType := ""
+	switch {
+	case req.body != nil:
+		b, err := json.Marshal(req.body)
+		if err != nil {
+			return false, err
+		}
+		body, contentType = bytes.NewReader(b), "application/json"
+	case req.form != nil:
+		body, contentType = strings.NewReader(req.form.Encode()), "application/x-www-form-urlencoded"
+	}
+	u := c.URL + req.path
+	if len(req.query) > 0 {
+		u += "?" + req.query.Encode()
+	}
+	r, err := http.NewRequest(req.method, u, body)
+	if err != nil {
+		return false, err
+	}
+	r = r.WithContext(ctx)
+	r.Header = req.header
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.HTTP.Do(r)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode >= 300 {
+		e := &StatusError{Code: resp.StatusCode, Header: resp.Header, Body: data}
+		if v, ok := req.errs[resp.StatusCode]; ok && len(data) > 0 && json.Unmarshal(data, v) == nil {
+			e.Value = v
+		}
+		return false, e
+	}
+	if req.out == nil || len(data) == 0 {
+		return false, nil
+	}
+	if err = json.Unmarshal(data, req.out); err != nil {
+		return false, fmt.Errorf("bad response: %v", err)
+	}
+	return true, nil
+}
+`
+
+// genClient generates models from definitions and client with method per operation
+func genClient(s *Spec, pkg string, source string) ([]byte, error) {
+	routes, err := s.routes()
+	if err != nil {
+		return nil, err
+	}
+	names, defs, err := s.schemas()
+	if err != nil {
+		return nil, err
+	}
+
+	var p printer
+	p.line("// Code generated by swagger/gen from %s. DO NOT EDIT.", source)
+	p.line("")
+	p.line("// Package %s is a client for %s", pkg, s.Info.Title)
+	p.line("package %s", pkg)
+	p.line("")
+
+	var body printer
+	var models []model
+	for _, n := range names {
+		models = append(models, model{Name: n, Schema: defs[n]})
+	}
+	for i := 0; i < len(models); i++ { // nested objects appended while printing
+		if err := body.model(models[i], &models); err != nil {
+			return nil, err
+		}
+	}
+	body.WriteString(clientRuntime)
+	body.line("")
+
+	conv := false
+	seen := make(map[string]bool)
+	for i := range routes {
+		op, err := newOperation(&routes[i].Operation)
+		if err != nil {
+			return nil, err
+		}
+		if seen[op.ID] {
+			continue
+		}
+		seen[op.ID] = true
+		for _, pr := range op.Params {
+			t := strings.TrimPrefix(pr.Type, "[]")
+			conv = conv || pr.In != "body" && formatValue(t, "x") != "x"
+		}
+		if err := body.clientMethod(s, &routes[i], op); err != nil {
+			return nil, err
+		}
+	}
+
+	p.line("import (")
+	for _, i := range []string{"bytes", "context", "encoding/json", "fmt", "io", "io/ioutil", "net/http", "net/url"} {
+		p.line("%q", i)
+	}
+	if conv {
+		p.line("\"strconv\"")
+	}
+	p.line("\"strings\"")
+	p.line(")")
+	p.line("")
+	p.Write(body.Bytes())
+	return p.Bytes(), nil
+}
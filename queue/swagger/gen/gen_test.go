@@ -0,0 +1,81 @@
+package main
+
+import (
+	"go/format"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNames(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("ClientID", goName("client_id"))
+	assert.Equal("XToken", goName("x-token"))
+	assert.Equal("clientID", varName("client_id"))
+	assert.Equal("idList", varName("id_list"))
+	assert.Equal("typeParam", varName("type"))
+	assert.Equal("X-Token", canonicalHeader("x-token"))
+}
+
+func generate(t *testing.T, filename string) (string, string) {
+	s, err := loadSpec(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := genServer(s, "main", filename, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server, err = format.Source(server); err != nil {
+		t.Fatal(err)
+	}
+	client, err := genClient(s, "client", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client, err = format.Source(client); err != nil {
+		t.Fatal(err)
+	}
+	return string(server), string(client)
+}
+
+func TestQueueSpec(t *testing.T) {
+	assert := assert.New(t)
+	server, client := generate(t, "../urykhy1-queue-1.0.0-openapi.yaml")
+	assert.Contains(server, "GetTask(clientID *string, timeout *int64, group *string, session *string, xRequestID *string) (int, *KV, error)")
+	assert.Contains(server, "return getTask(clientID, timeout, group, session, xRequestID)")
+	assert.Contains(client, "func (c *Client) Dump(ctx context.Context) ([]KV, error)")
+}
+
+func TestFeatures(t *testing.T) {
+	assert := assert.New(t)
+	server, client := generate(t, "testdata/items.yaml")
+
+	// path, header and enum array params
+	assert.Contains(server, "GetItem(itemID *int64, xToken *string, tags *[]string) (int, *Item, error)")
+	assert.Contains(server, `mux.Vars(r)["item_id"]`)
+	assert.Contains(server, `r.Header["X-Token"]`)
+	assert.Contains(server, `x != "a" && x != "b"`)
+	// json body
+	assert.Contains(server, "UpdateItem(itemID *int64, item *Item) (int, error)")
+	assert.Contains(server, "json.NewDecoder(r.Body).Decode(&val)")
+	// form with constraints
+	assert.Contains(server, "len(val) > 10")
+	assert.Contains(server, "CreateItems(name *string, weight *[]float64) (int, *[]Item, error)")
+
+	// nested objects get own types
+	assert.Contains(client, "Size   *ItemSize")
+	assert.Contains(client, "type ItemSize struct")
+	assert.Contains(client, "Labels []ItemLabelsItem")
+	// error response decoded by schema
+	assert.Contains(client, "func (c *Client) GetItem(ctx context.Context, itemID int64, xToken string, tags []string) (*Item, error)")
+	assert.Contains(client, "req.errs = map[int]interface{}{404: new(Error)}")
+	assert.Contains(client, "func (c *Client) CreateItems(ctx context.Context, name string, weight []float64) ([]Item, error)")
+}
+
+func TestSpecJSON(t *testing.T) {
+	assert := assert.New(t)
+	server, _ := generate(t, "testdata/items.yaml")
+	assert.Contains(server, `r.Path("/v1/openapi.json")`)
+	assert.Contains(server, `"x-name": "item"`)
+}
@@ -0,0 +1,60 @@
+// gen generates api server stub and client from swagger spec
+//
+//	go run ./swagger/gen -spec swagger/api.yaml -server api.go -funcs -client client/client.go
+package main
+
+import (
+	"flag"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+func write(filename string, src []byte) {
+	out, err := format.Source(src)
+	if err != nil {
+		log.Fatalf("%v: generated code is broken: %v", filename, err)
+	}
+	if err = os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		log.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filename, out, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func main() {
+	specFile := flag.String("spec", "", "swagger spec file")
+	server := flag.String("server", "", "server file to generate")
+	pkg := flag.String("package", "main", "server package")
+	funcs := flag.Bool("funcs", false, "generate Handler implementation calling package functions named by operationId")
+	client := flag.String("client", "", "client file to generate")
+	clientPkg := flag.String("client-package", "client", "client package")
+	flag.Parse()
+	if *specFile == "" || (*server == "" && *client == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	s, err := loadSpec(*specFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	source := filepath.Base(*specFile)
+	if *server != "" {
+		src, err := genServer(s, *pkg, source, *funcs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		write(*server, src)
+	}
+	if *client != "" {
+		src, err := genClient(s, *clientPkg, source)
+		if err != nil {
+			log.Fatal(err)
+		}
+		write(*client, src)
+	}
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// names used by generated handler and client code, params renamed if clash
+var reserved = map[string]bool{"w": true, "r": true, "l": true, "q": true, "h": true,
+	"v": true, "ok": true, "val": true, "err": true, "code": true, "resp": true, "log": true,
+	"c": true, "x": true, "ctx": true, "req": true, "found": true}
+
+// param is parameter with go names
+type param struct {
+	Parameter
+	Var  string // go variable
+	Type string // go type of value
+}
+
+// operation is deduplicated operationId with its params
+type operation struct {
+	ID       string // operationId, name of package function
+	Method   string // exported method name
+	Summary  string
+	Params   []param
+	Response string // go type of success response, empty if none
+}
+
+// printer collects go source
+type printer struct {
+	bytes.Buffer
+}
+
+func (p *printer) line(format string, args ...interface{}) {
+	fmt.Fprintf(&p.Buffer, format, args...)
+	p.WriteByte('\n')
+}
+
+// add appends param with go names and types
+func (op *operation) add(p Parameter) error {
+	var t string
+	var err error
+	if p.In == "body" {
+		t, err = schemaType(p.Schema)
+	} else {
+		t, err = paramType(&p)
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %v", op.ID, err)
+	}
+	v := varName(p.Name)
+	if reserved[v] {
+		v += "Param"
+	}
+	op.Params = append(op.Params, param{Parameter: p, Var: v, Type: t})
+	return nil
+}
+
+func newOperation(o *Operation) (*operation, error) {
+	op := &operation{ID: o.OperationID, Method: goName(o.OperationID), Summary: o.Summary}
+	op.Method = strings.ToUpper(op.Method[:1]) + op.Method[1:]
+	for _, p := range o.Parameters {
+		switch p.In {
+		case "query", "header", "path":
+		default:
+			return nil, fmt.Errorf("%v: unsupported param location %v", o.OperationID, p.In)
+		}
+		if p.Schema != nil && p.Schema.Type == "array" &&
+			(p.In != "query" || p.Style != "" && p.Style != "form" || p.Explode != nil && !*p.Explode) {
+			return nil, fmt.Errorf("%v: only exploded form arrays in query supported", o.OperationID)
+		}
+		if err := op.add(p); err != nil {
+			return nil, err
+		}
+	}
+
+	if b := o.RequestBody; b != nil {
+		if len(b.Content) != 1 {
+			return nil, fmt.Errorf("%v: request body must have one content type", o.OperationID)
+		}
+		for ct, m := range b.Content {
+			if m.Schema == nil {
+				return nil, fmt.Errorf("%v: request body without schema", o.OperationID)
+			}
+			switch ct {
+			case jsonContent:
+				name := b.Name
+				if name == "" {
+					name = "body"
+				}
+				if err := op.add(Parameter{In: "body", Name: name, Required: b.Required, Schema: m.Schema}); err != nil {
+					return nil, err
+				}
+			case formContent:
+				// form fields are params, arrays sent as repeated fields
+				names, props, err := m.Schema.properties()
+				if err != nil {
+					return nil, fmt.Errorf("%v: %v", o.OperationID, err)
+				}
+				for _, n := range names {
+					p := Parameter{In: "formData", Name: n, Required: m.Schema.required(n), Schema: props[n]}
+					if err := op.add(p); err != nil {
+						return nil, err
+					}
+				}
+			default:
+				return nil, fmt.Errorf("%v: unsupported request content %v", o.OperationID, ct)
+			}
+		}
+	}
+
+	s, err := o.success()
+	if err != nil {
+		return nil, err
+	}
+	if s != nil {
+		if op.Response, err = schemaType(s); err != nil {
+			return nil, fmt.Errorf("%v: %v", o.OperationID, err)
+		}
+	}
+	return op, nil
+}
+
+// signature returns handler method params and results
+func (op *operation) signature() string {
+	var params []string
+	for _, p := range op.Params {
+		params = append(params, p.Var+" *"+p.Type)
+	}
+	if op.Response == "" {
+		return fmt.Sprintf("(%s) (int, error)", strings.Join(params, ", "))
+	}
+	return fmt.Sprintf("(%s) (int, *%s, error)", strings.Join(params, ", "), op.Response)
+}
+
+func (op *operation) args() string {
+	var args []string
+	for _, p := range op.Params {
+		args = append(args, p.Var)
+	}
+	return strings.Join(args, ", ")
+}
+
+// operations returns operations once per operationId in spec order
+func operations(routes []Route) ([]*operation, error) {
+	var result []*operation
+	seen := make(map[string]*operation)
+	for i := range routes {
+		op, err := newOperation(&routes[i].Operation)
+		if err != nil {
+			return nil, err
+		}
+		if prev, ok := seen[op.ID]; ok {
+			if prev.signature() != op.signature() {
+				return nil, fmt.Errorf("%v: same operationId with different params", op.ID)
+			}
+			continue
+		}
+		seen[op.ID] = op
+		result = append(result, op)
+	}
+	return result, nil
+}
+
+// conversion returns code to convert v ([]string) to val of type t
+func conversion(t string) string {
+	switch t {
+	case "string":
+		return "val := v[0]"
+	case "[]string":
+		return "val := v"
+	case "int64":
+		return "val, err := strconv.ParseInt(v[0], 10, 64)"
+	case "float64":
+		return "val, err := strconv.ParseFloat(v[0], 64)"
+	case "bool":
+		return "val, err := strconv.ParseBool(v[0])"
+	case "[]int64":
+		return "val := make([]int64, len(v))\nvar err error\nfor i := range v {\nif val[i], err = strconv.ParseInt(v[i], 10, 64); err != nil {\nbreak\n}\n}"
+	case "[]float64":
+		return "val := make([]float64, len(v))\nvar err error\nfor i := range v {\nif val[i], err = strconv.ParseFloat(v[i], 64); err != nil {\nbreak\n}\n}"
+	case "[]bool":
+		return "val := make([]bool, len(v))\nvar err error\nfor i := range v {\nif val[i], err = strconv.ParseBool(v[i]); err != nil {\nbreak\n}\n}"
+	}
+	return ""
+}
+
+// checks returns conditions which make param value invalid
+func checks(p *param) []string {
+	var result []string
+	item, s := "val", p.Schema
+	if strings.HasPrefix(p.Type, "[]") {
+		item, s = "x", s.Items
+	}
+	if len(s.Enum) > 0 {
+		var cmp []string
+		for _, e := range s.Enum {
+			cmp = append(cmp, fmt.Sprintf("%s != %q", item, e))
+		}
+		result = append(result, strings.Join(cmp, " && "))
+	}
+	if s.Minimum != nil {
+		result = append(result, fmt.Sprintf("%s < %s", item, strconv.FormatFloat(*s.Minimum, 'f', -1, 64)))
+	}
+	if s.Maximum != nil {
+		result = append(result, fmt.Sprintf("%s > %s", item, strconv.FormatFloat(*s.Maximum, 'f', -1, 64)))
+	}
+	if s.MaxLength != nil {
+		result = append(result, fmt.Sprintf("len(%s) > %d", item, *s.MaxLength))
+	}
+	return result
+}
+
+func (p *printer) badRequest(msg string) {
+	p.line("l.Warn(%q)", msg)
+	p.line("w.WriteHeader(http.StatusBadRequest)")
+	p.line("return")
+}
+
+// source returns expression giving v []string and ok
+func source(p *param) string {
+	switch p.In {
+	case "header":
+		return fmt.Sprintf("r.Header[%q]", canonicalHeader(p.Name))
+	case "path":
+		return fmt.Sprintf("[]string{mux.Vars(r)[%q]}, true", p.Name)
+	case "formData":
+		return fmt.Sprintf("r.Form[%q]", p.Name)
+	}
+	return fmt.Sprintf("q[%q]", p.Name)
+}
+
+func (p *printer) param(pr *param) {
+	p.line("var %s *%s", pr.Var, pr.Type)
+	if pr.In == "body" {
+		p.line("if r.Body != nil && r.ContentLength != 0 {")
+		p.line("var val %s", pr.Type)
+		p.line("if err := json.NewDecoder(r.Body).Decode(&val); err != nil {")
+		p.badRequest("bad body " + pr.Name)
+		p.line("}")
+		p.line("%s = &val", pr.Var)
+		if pr.Required {
+			p.line("} else {")
+			p.badRequest("no required body " + pr.Name)
+		}
+		p.line("}")
+		return
+	}
+
+	p.line("if v, ok := %s; ok {", source(pr))
+	p.line(conversion(pr.Type))
+	if strings.Contains(conversion(pr.Type), "err") {
+		p.line("if err != nil {")
+		p.badRequest("bad param " + pr.Name)
+		p.line("}")
+	}
+	if c := checks(pr); len(c) > 0 {
+		if strings.HasPrefix(pr.Type, "[]") {
+			p.line("for _, x := range val {")
+		}
+		p.line("if %s {", strings.Join(c, " || "))
+		p.badRequest("bad param " + pr.Name)
+		p.line("}")
+		if strings.HasPrefix(pr.Type, "[]") {
+			p.line("}")
+		}
+	}
+	p.line("%s = &val", pr.Var)
+	if pr.Required {
+		p.line("} else {")
+		p.badRequest("no required param " + pr.Name)
+	}
+	p.line("}")
+}
+
+func (p *printer) route(s *Spec, rt *Route, op *operation) {
+	p.line("r.Path(%q).Methods(%q).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {", s.basePath()+rt.Path, rt.Method)
+	p.line("// %s", rt.Summary)
+	p.line("l := log.WithField(\"method\", %q).WithField(\"request_id\", r.Header.Get(\"X-Request-ID\"))", rt.Path)
+
+	form, query := false, false
+	for _, pr := range op.Params {
+		form = form || pr.In == "formData"
+		query = query || pr.In == "query"
+	}
+	if form || (query && rt.Method != "get") {
+		p.line("if err := r.ParseForm(); err != nil {")
+		p.line("l.Warn(\"bad form: \", err)")
+		p.line("w.WriteHeader(http.StatusBadRequest)")
+		p.line("return")
+		p.line("}")
+	}
+	if query {
+		if rt.Method == "get" {
+			p.line("q := r.URL.Query()")
+		} else {
+			p.line("q := r.Form")
+		}
+	}
+	for i := range op.Params {
+		p.param(&op.Params[i])
+	}
+
+	if op.Response == "" {
+		p.line("code, err := h.%s(%s)", op.Method, op.args())
+	} else {
+		p.line("code, resp, err := h.%s(%s)", op.Method, op.args())
+	}
+	p.line("if err != nil {")
+	p.line("writeAPIError(w, l, code, err)")
+	p.line("return")
+	p.line("}")
+	if op.Response != "" {
+		p.line("if resp != nil {")
+		p.line("writeAPIResponse(w, l, code, resp)")
+		p.line("return")
+		p.line("}")
+	}
+	p.line("w.WriteHeader(code)")
+	p.line("})")
+	p.line("")
+}
+
+// genServer generates handler interface, router and optionally adapter to package functions
+func genServer(s *Spec, pkg string, source string, funcs bool) ([]byte, error) {
+	routes, err := s.routes()
+	if err != nil {
+		return nil, err
+	}
+	ops, err := operations(routes)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*operation)
+	conv := false
+	for _, op := range ops {
+		byID[op.ID] = op
+		for _, pr := range op.Params {
+			conv = conv || pr.Type != "string" && pr.Type != "[]string" && pr.In != "body"
+		}
+	}
+
+	var p printer
+	p.line("// Code generated by swagger/gen from %s. DO NOT EDIT.", source)
+	p.line("")
+	p.line("/*")
+	p.line("%s", s.Info.Title)
+	p.line("")
+	p.line("%s*/", s.Info.Description)
+	p.line("")
+	p.line("package %s", pkg)
+	p.line("")
+	p.line("import (")
+	p.line("\"encoding/json\"")
+	p.line("\"net/http\"")
+	if conv {
+		p.line("\"strconv\"")
+	}
+	p.line("")
+	p.line("\"github.com/gorilla/mux\"")
+	p.line("log \"github.com/sirupsen/logrus\"")
+	p.line(")")
+	p.line("")
+	spec, err := s.json()
+	if err != nil {
+		return nil, err
+	}
+	p.line("// openAPISpec is %s as json", source)
+	p.line("const openAPISpec = `%s`", bytes.Replace(spec, []byte("`"), []byte("` + \"`\" + `"), -1))
+	p.line("")
+	p.line("// HeaderError is an error with headers to add to response")
+	p.line("type HeaderError interface {")
+	p.line("error")
+	p.line("Header() http.Header")
+	p.line("}")
+	p.line("")
+	p.line("// BodyError is an error with response body, sent as json")
+	p.line("type BodyError interface {")
+	p.line("error")
+	p.line("Body() interface{}")
+	p.line("}")
+	p.line("")
+	p.line("// Handler implements api operations")
+	p.line("type Handler interface {")
+	for _, op := range ops {
+		p.line("// %s %s", op.Method, op.Summary)
+		p.line("%s%s", op.Method, op.signature())
+	}
+	p.line("}")
+	p.line("")
+	p.line("func writeAPIError(w http.ResponseWriter, l *log.Entry, code int, err error) {")
+	p.line("if e, ok := err.(HeaderError); ok {")
+	p.line("for k, v := range e.Header() {")
+	p.line("w.Header()[k] = v")
+	p.line("}")
+	p.line("}")
+	p.line("l.Error(err)")
+	p.line("if e, ok := err.(BodyError); ok {")
+	p.line("writeAPIResponse(w, l, code, e.Body())")
+	p.line("return")
+	p.line("}")
+	p.line("w.WriteHeader(code)")
+	p.line("}")
+	p.line("")
+	p.line("func writeAPIResponse(w http.ResponseWriter, l *log.Entry, code int, resp interface{}) {")
+	p.line("jresp, err := json.Marshal(resp)")
+	p.line("if err != nil {")
+	p.line("l.Error(\"fail to format result\")")
+	p.line("w.WriteHeader(http.StatusInternalServerError)")
+	p.line("return")
+	p.line("}")
+	p.line("w.Header().Set(\"Content-Type\", \"application/json\")")
+	p.line("w.WriteHeader(code)")
+	p.line("w.Write(jresp)")
+	p.line("}")
+	p.line("")
+	p.line("// CreateRouter creates swagger api router")
+	p.line("func CreateRouter(log *log.Logger, h Handler) *mux.Router {")
+	p.line("r := mux.NewRouter()")
+	p.line("r.Path(%q).Methods(\"get\").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {", s.basePath()+"/openapi.json")
+	p.line("w.Header().Set(\"Content-Type\", \"application/json\")")
+	p.line("w.Write([]byte(openAPISpec))")
+	p.line("})")
+	p.line("")
+	for i := range routes {
+		p.route(s, &routes[i], byID[routes[i].OperationID])
+	}
+	p.line("return r")
+	p.line("}")
+
+	if funcs {
+		p.line("")
+		p.line("// funcHandler implements Handler with package functions named by operationId")
+		p.line("type funcHandler struct{}")
+		for _, op := range ops {
+			p.line("")
+			p.line("func (funcHandler) %s%s {", op.Method, op.signature())
+			p.line("return %s(%s)", op.ID, op.args())
+			p.line("}")
+		}
+	}
+	return p.Bytes(), nil
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// openapi 3.0 subset used by queue api
+
+// Spec is an openapi document
+type Spec struct {
+	OpenAPI string `yaml:"openapi"`
+	Info    struct {
+		Title       string
+		Description string
+		Version     string
+	}
+	Servers []struct {
+		URL string
+	}
+	Paths      yaml.MapSlice // path -> method -> Operation, order kept for stable output
+	Components struct {
+		Schemas yaml.MapSlice // name -> Schema
+	}
+	raw []byte
+}
+
+// Operation is one method of path
+type Operation struct {
+	Summary     string
+	OperationID string `yaml:"operationId"`
+	Parameters  []Parameter
+	RequestBody *RequestBody  `yaml:"requestBody"`
+	Responses   yaml.MapSlice // code -> Response
+}
+
+// Parameter is operation input
+type Parameter struct {
+	In          string
+	Name        string
+	Description string
+	Required    bool
+	Schema      *Schema
+	Style       string // only form supported for arrays
+	Explode     *bool
+}
+
+// RequestBody is json or form operation input
+type RequestBody struct {
+	Description string
+	Required    bool
+	Content     map[string]MediaType
+	Name        string `yaml:"x-name"` // go name of json body param, body if not set
+}
+
+// MediaType is content of request or response
+type MediaType struct {
+	Schema *Schema
+}
+
+// Response is a response for one status code
+type Response struct {
+	Description string
+	Headers     map[string]interface{}
+	Content     map[string]MediaType
+}
+
+// Schema is a type definition
+type Schema struct {
+	Ref         string `yaml:"$ref"`
+	Type        string
+	Format      string
+	Description string
+	Items       *Schema
+	Properties  yaml.MapSlice // name -> Schema
+	Required    []string
+	Enum        []string
+	Minimum     *float64
+	Maximum     *float64
+	MaxLength   *int `yaml:"maxLength"`
+}
+
+// content types
+const (
+	jsonContent = "application/json"
+	formContent = "application/x-www-form-urlencoded"
+)
+
+// Route is operation bound to path and method
+type Route struct {
+	Path   string
+	Method string
+	Operation
+}
+
+// Response with status code
+type codeResponse struct {
+	Code int
+	Response
+}
+
+// convert decodes generic yaml value into typed one
+func convert(in interface{}, out interface{}) error {
+	b, err := yaml.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return yaml.UnmarshalStrict(b, out)
+}
+
+func loadSpec(filename string) (*Spec, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	s := Spec{raw: b}
+	if err = yaml.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(s.OpenAPI, "3.0") {
+		return nil, fmt.Errorf("openapi 3.0 expected, got %q", s.OpenAPI)
+	}
+	return &s, nil
+}
+
+// basePath returns path prefix of first server
+func (s *Spec) basePath() string {
+	if len(s.Servers) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(s.Servers[0].URL, "/")
+}
+
+// jsonValue converts yaml maps to json compatible ones
+func jsonValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			m[fmt.Sprint(k)] = jsonValue(v)
+		}
+		return m
+	case []interface{}:
+		for i := range x {
+			x[i] = jsonValue(x[i])
+		}
+	}
+	return v
+}
+
+// json returns spec as indented json
+func (s *Spec) json() ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(s.raw, &v); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(jsonValue(v), "", "  ")
+}
+
+// routes returns all operations in spec order
+func (s *Spec) routes() ([]Route, error) {
+	var result []Route
+	for _, p := range s.Paths {
+		var methods yaml.MapSlice
+		if err := convert(p.Value, &methods); err != nil {
+			return nil, fmt.Errorf("path %v: %v", p.Key, err)
+		}
+		for _, m := range methods {
+			r := Route{Path: fmt.Sprint(p.Key), Method: fmt.Sprint(m.Key)}
+			if err := convert(m.Value, &r.Operation); err != nil {
+				return nil, fmt.Errorf("%v %v: %v", r.Method, r.Path, err)
+			}
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+// schemas returns named schemas in spec order
+func (s *Spec) schemas() ([]string, map[string]*Schema, error) {
+	var names []string
+	result := make(map[string]*Schema)
+	for _, d := range s.Components.Schemas {
+		var schema Schema
+		if err := convert(d.Value, &schema); err != nil {
+			return nil, nil, fmt.Errorf("schema %v: %v", d.Key, err)
+		}
+		names = append(names, fmt.Sprint(d.Key))
+		result[fmt.Sprint(d.Key)] = &schema
+	}
+	return names, result, nil
+}
+
+// responses returns responses sorted as in spec
+func (o *Operation) responses() ([]codeResponse, error) {
+	var result []codeResponse
+	for _, r := range o.Responses {
+		var code int
+		if _, err := fmt.Sscan(fmt.Sprint(r.Key), &code); err != nil {
+			return nil, fmt.Errorf("%v: bad response code %v", o.OperationID, r.Key)
+		}
+		cr := codeResponse{Code: code}
+		if err := convert(r.Value, &cr.Response); err != nil {
+			return nil, fmt.Errorf("%v: response %v: %v", o.OperationID, code, err)
+		}
+		result = append(result, cr)
+	}
+	return result, nil
+}
+
+// success returns schema of successful response, only one is allowed
+func (o *Operation) success() (*Schema, error) {
+	responses, err := o.responses()
+	if err != nil {
+		return nil, err
+	}
+	var result *Schema
+	for _, r := range responses {
+		s, err := r.schema()
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", o.OperationID, err)
+		}
+		if r.Code >= 300 || s == nil {
+			continue
+		}
+		if result != nil {
+			return nil, fmt.Errorf("%v: only one success response schema supported", o.OperationID)
+		}
+		result = s
+	}
+	return result, nil
+}
+
+// schema returns json schema of response, nil if response has no content
+func (r *Response) schema() (*Schema, error) {
+	for t, m := range r.Content {
+		if t != jsonContent {
+			return nil, fmt.Errorf("unsupported response content %v", t)
+		}
+		return m.Schema, nil
+	}
+	return nil, nil
+}
+
+// properties returns object properties in spec order
+func (s *Schema) properties() ([]string, map[string]*Schema, error) {
+	var names []string
+	result := make(map[string]*Schema)
+	for _, p := range s.Properties {
+		var schema Schema
+		if err := convert(p.Value, &schema); err != nil {
+			return nil, nil, fmt.Errorf("property %v: %v", p.Key, err)
+		}
+		names = append(names, fmt.Sprint(p.Key))
+		result[fmt.Sprint(p.Key)] = &schema
+	}
+	return names, result, nil
+}
+
+func (s *Schema) required(name string) bool {
+	for _, r := range s.Required {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+// goName converts snake_case or dash-case to exported go name
+func goName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == '-' }) {
+		if strings.EqualFold(part, "id") {
+			b.WriteString("ID")
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+// varName converts name to unexported go name
+func varName(name string) string {
+	n := goName(name)
+	if strings.HasPrefix(n, "ID") {
+		n = "id" + n[2:]
+	} else {
+		n = strings.ToLower(n[:1]) + n[1:]
+	}
+	if token.Lookup(n).IsKeyword() {
+		n += "Param"
+	}
+	return n
+}
+
+// scalarType returns go type for swagger primitive
+func scalarType(t string) (string, error) {
+	switch t {
+	case "string", "":
+		return "string", nil
+	case "integer":
+		return "int64", nil
+	case "number":
+		return "float64", nil
+	case "boolean":
+		return "bool", nil
+	}
+	return "", fmt.Errorf("unsupported type %v", t)
+}
+
+// schemaType returns go type for schema, inline objects are not supported here
+func schemaType(s *Schema) (string, error) {
+	if s.Ref != "" {
+		return strings.TrimPrefix(s.Ref, "#/components/schemas/"), nil
+	}
+	switch s.Type {
+	case "array":
+		if s.Items == nil {
+			return "", fmt.Errorf("array without items")
+		}
+		t, err := schemaType(s.Items)
+		return "[]" + t, err
+	case "object":
+		return "", fmt.Errorf("inline object, use components")
+	}
+	return scalarType(s.Type)
+}
+
+// paramType returns go type for parameter value
+func paramType(p *Parameter) (string, error) {
+	if p.Schema == nil {
+		return "", fmt.Errorf("param %v without schema", p.Name)
+	}
+	if p.Schema.Type == "array" {
+		if p.Schema.Items == nil {
+			return "", fmt.Errorf("array param %v without items", p.Name)
+		}
+		t, err := scalarType(p.Schema.Items.Type)
+		return "[]" + t, err
+	}
+	return scalarType(p.Schema.Type)
+}
+
+// pathParams returns names from /path/{name}
+func pathParams(path string) []string {
+	var result []string
+	for _, part := range strings.Split(path, "/") {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			result = append(result, part[1:len(part)-1])
+		}
+	}
+	return result
+}
+
+// canonicalHeader returns header name as stored in http.Header
+func canonicalHeader(name string) string {
+	parts := strings.Split(name, "-")
+	for i, p := range parts {
+		if p != "" {
+			parts[i] = strings.ToUpper(p[:1]) + strings.ToLower(p[1:])
+		}
+	}
+	return strings.Join(parts, "-")
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// topic mode: every consumer group gets every task, in commit order (create revision of task key):
+// task id is chosen before put commits, so task with smaller id may be committed later.
+// group keeps a cursor (create revision, all tasks up to it are acked by group),
+// leases and acks for tasks after cursor live in own keys, ack marker value is create revision of task.
+// task removed when all cursors moved past it or retention expired
+
+func isTopic() bool {
+	return len(cfg.Groups) > 0
+}
+
+func cursorPrefix() string {
+	return "__cursor:" + cfg.Queue + ":"
+}
+
+func cursorKey(group string) string {
+	return cursorPrefix() + group
+}
+
+func groupActivePrefix(group string) string {
+	return "__gactive:" + cfg.Queue + ":" + group + ":"
+}
+
+func groupAckedPrefix(group string) string {
+	return "__gacked:" + cfg.Queue + ":" + group + ":"
+}
+
+// ackedInTxn is marker of task acked by put in same txn (requeue, import), create revision not known yet.
+// cursor passes it, marker kept until task removed
+var ackedInTxn = strconv.FormatInt(math.MaxInt64, 10)
+
+// tasksAfter returns key and options to get page of pending tasks after last read task (nil for first page).
+// plain queue goes in id order, topic in commit order after cursor, see pageTasks
+func tasksAfter(last *mvccpb.KeyValue, cursor int64) (string, []clientv3.OpOption) {
+	prefix := cfg.Queue + ":"
+	opts := []clientv3.OpOption{clientv3.WithRange(clientv3.GetPrefixRangeEnd(prefix))}
+	if !isTopic() {
+		start := prefix
+		if last != nil {
+			start = string(last.Key) + "\x00"
+		}
+		return start, append(opts, clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend), clientv3.WithLimit(current().Limit))
+	}
+	if last != nil {
+		cursor = last.CreateRevision
+	}
+	// one txn puts up to maxTxnOps tasks, so full page have more than one revision
+	limit := current().Limit
+	if limit <= maxTxnOps {
+		limit = maxTxnOps + 1
+	}
+	return prefix, append(opts, clientv3.WithMinCreateRev(cursor+1),
+		clientv3.WithSort(clientv3.SortByCreateRevision, clientv3.SortAscend), clientv3.WithLimit(limit))
+}
+
+// pageTasks returns tasks of page in order. topic tasks of one revision go in id order,
+// last revision of page dropped if page is cut in it, so next page starts after complete revision
+func pageTasks(resp *clientv3.GetResponse) []*mvccpb.KeyValue {
+	kvs := resp.Kvs
+	if !isTopic() {
+		return kvs
+	}
+	sort.SliceStable(kvs, func(i, j int) bool {
+		if kvs[i].CreateRevision != kvs[j].CreateRevision {
+			return kvs[i].CreateRevision < kvs[j].CreateRevision
+		}
+		return string(kvs[i].Key) < string(kvs[j].Key)
+	})
+	if resp.More {
+		last := kvs[len(kvs)-1].CreateRevision
+		for len(kvs) > 0 && kvs[len(kvs)-1].CreateRevision == last {
+			kvs = kvs[:len(kvs)-1]
+		}
+	}
+	return kvs
+}
+
+// parseRevision returns revision saved in cursor or ack marker, 0 if empty
+func parseRevision(value []byte) int64 {
+	rev, _ := strconv.ParseInt(string(value), 10, 64)
+	return rev
+}
+
+// taskRevision returns create revision of task to save in ack marker, 0 if task removed
+func taskRevision(ctx context.Context, id string) (int64, error) {
+	resp, err := client.Get(ctx, cfg.Queue+":"+id, clientv3.WithKeysOnly())
+	if err != nil || len(resp.Kvs) == 0 {
+		return 0, err
+	}
+	return resp.Kvs[0].CreateRevision, nil
+}
+
+// createGroups registers configured groups, new group starts from the queue head
+func createGroups(ctx context.Context) error {
+	for _, g := range cfg.Groups {
+		resp, err := client.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(cursorKey(g)), "=", 0)).
+			Then(clientv3.OpPut(cursorKey(g), "")).
+			Else(clientv3.OpGet(cursorKey(g))).
+			Commit()
+		if err != nil {
+			return err
+		}
+		if !resp.Succeeded {
+			if err = migrateCursor(ctx, g, resp.Responses[0].GetResponseRange().Kvs[0]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// migrateCursor converts cursor of older version holding task id, it never exceeds etcd revision:
+// tasks up to that id marked acked and cursor reset, next ack moves it in commit order
+func migrateCursor(ctx context.Context, group string, cursor *mvccpb.KeyValue) error {
+	id := string(cursor.Value)
+	if rev := parseRevision(cursor.Value); rev <= cursor.ModRevision {
+		return nil
+	}
+	prefix := cfg.Queue + ":"
+	resp, err := client.Get(ctx, prefix, clientv3.WithRange(prefix+id+"\x00"), clientv3.WithKeysOnly())
+	if err != nil {
+		return err
+	}
+	ops := make([]clientv3.Op, 0, len(resp.Kvs)+1)
+	for _, ev := range resp.Kvs {
+		ops = append(ops, clientv3.OpPut(groupAckedPrefix(group)+string(ev.Key)[len(prefix):], strconv.FormatInt(ev.CreateRevision, 10)))
+	}
+	if err = commitOps(ctx, ops); err != nil {
+		return err
+	}
+	_, err = client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(cursorKey(group)), "=", cursor.ModRevision)).
+		Then(clientv3.OpPut(cursorKey(group), "")).
+		Commit()
+	logger.WithFields(log.Fields{"group": group, "tasks": len(resp.Kvs)}).Info("cursor by task id converted to acked markers")
+	return err
+}
+
+func ackGroupTask(clientID *string, taskID *string, group string, next *[]string, result *string, token *int64, traceparent *string, requestID *string) (int, *[]KV, error) {
+	f := withRequest(log.Fields{"client": *clientID, "group": group, "task": *taskID}, requestID)
+	key := activePrefix(group) + *taskID
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	rops, err := resultOps(ctx, group, *taskID, result)
+	if err != nil {
+		return http.StatusInternalServerError, nil, fmt.Errorf("fail to create a lease")
+	}
+	created, err := taskRevision(ctx, *taskID)
+	if err != nil {
+		return http.StatusInternalServerError, nil, fmt.Errorf("fail to get task %v", *taskID)
+	}
+	// acked task stays in queue until all groups ack it
+	var added []KV
+	n, size := nextSize(next)
+	code, err := putPlanned(ctx, n, size, "", func(plan *putPlan) (int, error) {
+		var ops []clientv3.Op
+		var err error
+		if added, ops, err = nextTasksOps(plan.queue, next, validTrace(traceparent)); err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("fail to add follow-up tasks")
+		}
+		resp, err := client.Txn(ctx).
+			If(append(ownerCmps(key, *clientID, token), plan.cmps...)...).
+			Then(append(append(append(append(ops, plan.ops...), rops...), releaseOps(group, *taskID)...),
+				clientv3.OpDelete(attemptsKey(group, *taskID)),
+				clientv3.OpDelete(startedKey(group, *taskID)),
+				clientv3.OpPut(groupAckedPrefix(group)+*taskID, strconv.FormatInt(created, 10)))...).
+			Else(clientv3.OpGet(key)).
+			Commit()
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("fail to ack task %v", *taskID)
+		}
+		if !resp.Succeeded {
+			kvs := resp.Responses[0].GetResponseRange().Kvs
+			if owned(kvs, *clientID, token) {
+				return 0, errQueueChanged
+			}
+			return notOwned(kvs, *taskID, token)
+		}
+		plan.added(len(added), f)
+		return http.StatusOK, nil
+	})
+	if err != nil {
+		return code, nil, err
+	}
+	logger.WithFields(f).Debug("task completed")
+	counter("acked").Inc()
+
+	// task acked, cursor and cleanup can be done by next ack if we fail here
+	if err = advanceCursor(ctx, group); err != nil {
+		logger.WithFields(f).Warnf("fail to advance cursor: %v", err)
+	} else if err = removeConsumed(ctx); err != nil {
+		logger.WithFields(f).Warnf("fail to remove consumed tasks: %v", err)
+	}
+	if next != nil {
+		return http.StatusOK, &added, nil
+	}
+	return http.StatusOK, nil, nil
+}
+
+// advanceCursor moves group cursor over acked tasks, revision passed when all its tasks acked,
+// and drops markers of tasks before cursor
+func advanceCursor(ctx context.Context, group string) error {
+	cursor, err := client.Get(ctx, cursorKey(group))
+	if err != nil {
+		return err
+	}
+	if len(cursor.Kvs) == 0 {
+		return fmt.Errorf("unknown group %v", group)
+	}
+
+	prefix := groupAckedPrefix(group)
+	markers, err := client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+	acked := make(map[string]struct{})
+	for _, ev := range markers.Kvs {
+		acked[string(ev.Key)[len(prefix):]] = struct{}{}
+	}
+
+	current := parseRevision(cursor.Kvs[0].Value)
+	start, opts := tasksAfter(nil, current)
+	all, err := client.Get(ctx, start, append(opts, clientv3.WithKeysOnly())...)
+	if err != nil {
+		return err
+	}
+	next := current
+	prefixLen := len(cfg.Queue) + 1 // to skip `:`
+	kvs := pageTasks(all)
+	for i := 0; i < len(kvs); {
+		// revision passed only if all its tasks acked
+		j := i
+		for j < len(kvs) && kvs[j].CreateRevision == kvs[i].CreateRevision {
+			if _, ok := acked[string(kvs[j].Key)[prefixLen:]]; !ok {
+				break
+			}
+			j++
+		}
+		if j < len(kvs) && kvs[j].CreateRevision == kvs[i].CreateRevision {
+			break
+		}
+		next, i = kvs[i].CreateRevision, j
+	}
+	if next != current {
+		// concurrent ack may move cursor as well, so it's ok to fail here
+		resp, err := client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(cursorKey(group)), "=", cursor.Kvs[0].ModRevision)).
+			Then(clientv3.OpPut(cursorKey(group), strconv.FormatInt(next, 10))).
+			Commit()
+		if err != nil || !resp.Succeeded {
+			return err
+		}
+	}
+
+	var ops []clientv3.Op
+	for _, ev := range markers.Kvs {
+		if parseRevision(ev.Value) <= next {
+			ops = append(ops, clientv3.OpDelete(string(ev.Key)))
+		}
+	}
+	return commitOps(ctx, ops)
+}
+
+// removeConsumed deletes tasks acked by all groups, cursors of groups removed from config deleted
+func removeConsumed(ctx context.Context) error {
+	resp, err := client.Get(ctx, cursorPrefix(), clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+	last, stale := consumed(resp.Kvs)
+	var ops []clientv3.Op
+	if last > 0 {
+		prefix := cfg.Queue + ":"
+		done, err := client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly(), clientv3.WithMaxCreateRev(last))
+		if err != nil {
+			return err
+		}
+		for _, ev := range done.Kvs {
+			ops = append(ops, dropTaskOps(string(ev.Key)[len(prefix):])...)
+		}
+	}
+	for _, g := range stale {
+		ops = append(ops, clientv3.OpDelete(cursorKey(g)), clientv3.OpDelete(groupAckedPrefix(g), clientv3.WithPrefix()))
+	}
+	if err = commitOps(ctx, ops); err != nil {
+		return err
+	}
+	if len(stale) > 0 {
+		logger.WithField("groups", stale).Info("cursors of removed groups deleted")
+	}
+	return nil
+}
+
+// consumed returns last revision acked by all groups (0 if none) and groups with cursor not in config
+func consumed(cursors []*mvccpb.KeyValue) (int64, []string) {
+	known := make(map[string]int64, len(cfg.Groups))
+	var stale []string
+	for _, ev := range cursors {
+		g := string(ev.Key)[len(cursorPrefix()):]
+		if !knownGroup(g) {
+			stale = append(stale, g)
+			continue
+		}
+		known[g] = parseRevision(ev.Value)
+	}
+	var last int64
+	for i, g := range cfg.Groups {
+		cursor, ok := known[g]
+		if !ok {
+			return 0, stale // group not created yet
+		}
+		if i == 0 || cursor < last {
+			last = cursor
+		}
+	}
+	return last, stale
+}
+
+// expireTasks deletes tasks older than retention period with their keys of every group,
+// even if not acked by all groups
+func expireTasks() {
+	retention := current().Retention
+	if retention == 0 {
+		return
+	}
+	prefix := cfg.Queue + ":"
+	cutoff := fmt.Sprintf("%v", time.Now().Add(-retention).UnixNano())
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	resp, err := client.Get(ctx, prefix, clientv3.WithRange(prefix+cutoff), clientv3.WithKeysOnly())
+	if err != nil {
+		logger.Warnf("fail to expire tasks: %v", err)
+		return
+	}
+	var ops []clientv3.Op
+	for _, ev := range resp.Kvs {
+		ops = append(ops, dropTaskOps(string(ev.Key)[len(prefix):])...)
+	}
+	if err = commitOps(ctx, ops); err != nil {
+		logger.Warnf("fail to expire tasks: %v", err)
+		return
+	}
+	if len(resp.Kvs) > 0 {
+		logger.WithField("count", len(resp.Kvs)).Info("expired tasks removed")
+	}
+}
+
+func retentionLoop() {
+	for range time.Tick(time.Minute) {
+		expireTasks()
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestTopicCursors(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t, "a", "b")
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	cursor := func(group string) string {
+		resp, err := client.Get(ctx, cursorKey(group))
+		assert.Nil(err)
+		if len(resp.Kvs) == 0 {
+			return "<none>"
+		}
+		return string(resp.Kvs[0].Value)
+	}
+
+	created := func(id string) string {
+		rev, err := taskRevision(ctx, id)
+		assert.Nil(err)
+		return strconv.FormatInt(rev, 10)
+	}
+
+	workers := []string{"w1", "w2", "w3"}
+	id1, id2, id3 := addTask(t, "1"), addTask(t, "2"), addTask(t, "3")
+	for i, id := range []string{id1, id2, id3} {
+		assert.Equal(id, lease(t, workers[i], "a").ID)
+	}
+	// out of order ack kept as marker, cursor moves over acked tasks only
+	ack(t, "w2", "a", id2)
+	assert.Equal("", cursor("a"))
+	ack(t, "w1", "a", id1)
+	assert.Equal(created(id2), cursor("a"))
+	ack(t, "w3", "a", id3)
+	assert.Equal(created(id3), cursor("a"))
+	resp, err := client.Get(ctx, groupAckedPrefix("a"), clientv3.WithPrefix())
+	assert.Nil(err)
+	assert.Empty(resp.Kvs)
+
+	// task removed after all groups acked it
+	assert.Len(taskKeys(t), 3)
+	assert.Equal(id1, lease(t, "w", "b").ID)
+	ack(t, "w", "b", id1)
+	assert.Equal([]string{id2, id3}, taskKeys(t))
+
+	// group removed from config does not keep tasks
+	cfg.Groups = []string{"a"}
+	assert.Nil(removeConsumed(ctx))
+	assert.Empty(taskKeys(t))
+	assert.Equal("<none>", cursor("b"))
+}
+
+// putWithID puts task with given id, as put of other replica committed late
+func putWithID(t *testing.T, id string, data string) {
+	t.Helper()
+	ops, err := putTaskOps(id, data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	if _, err = client.Txn(ctx).Then(ops...).Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTopicCommitOrder(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t, "a", "b")
+	id := addTask(t, "first")
+	ack(t, "w", "a", lease(t, "w", "a").ID)
+	ack(t, "w", "b", lease(t, "w", "b").ID)
+	assert.Empty(taskKeys(t))
+
+	// smaller id committed after cursors passed first task
+	putWithID(t, "1", "late")
+	assert.Less("1", id)
+	assert.Equal("1", lease(t, "w", "a").ID)
+	ack(t, "w", "a", "1")
+	assert.Equal([]string{"1"}, taskKeys(t))
+	assert.Equal("1", lease(t, "w", "b").ID)
+	ack(t, "w", "b", "1")
+	assert.Empty(taskKeys(t))
+}
+
+func TestTopicPages(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t, "a")
+	// more tasks than one page
+	for i := 0; i < maxTxnOps+5; i++ {
+		addTask(t, strconv.Itoa(i))
+	}
+	ids := taskKeys(t)
+	for i := 0; i < maxTxnOps+5; i++ {
+		task := lease(t, "w", "a")
+		assert.Equal(ids[i], task.ID)
+		ack(t, "w", "a", task.ID)
+	}
+	assert.Empty(taskKeys(t))
+}
+
+func TestTopicMigrateCursor(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t, "a")
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	id1, id2 := addTask(t, "1"), addTask(t, "2")
+
+	// cursor of older version is task id
+	_, err := client.Put(ctx, cursorKey("a"), id1)
+	assert.Nil(err)
+	assert.Nil(createGroups(ctx))
+	resp, err := client.Get(ctx, cursorKey("a"))
+	assert.Nil(err)
+	assert.Equal("", string(resp.Kvs[0].Value))
+	assert.Equal(id2, lease(t, "w", "a").ID)
+	ack(t, "w", "a", id2)
+	assert.Empty(taskKeys(t))
+}
+
+func TestTopicRetention(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t, "a")
+	id := addTask(t, "old")
+	expireTasks()
+	assert.Equal([]string{id}, taskKeys(t)) // retention not set
+
+	useSettings(t, settings{LogLevel: "info", Limit: 100, Retention: time.Minute})
+	expireTasks()
+	assert.Equal([]string{id}, taskKeys(t))
+	time.Sleep(time.Millisecond)
+	useSettings(t, settings{LogLevel: "info", Limit: 100, Retention: time.Millisecond})
+	// group keys of expired task removed with it
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	keys := []string{groupAckedPrefix("a") + id, attemptsKey("a", id), startedKey("a", id), progressPrefix("a") + id}
+	for _, k := range keys {
+		_, err := client.Put(ctx, k, "1")
+		assert.Nil(err)
+	}
+	expireTasks()
+	assert.Empty(taskKeys(t))
+	for _, k := range keys {
+		resp, err := client.Get(ctx, k, clientv3.WithCountOnly())
+		assert.Nil(err)
+		assert.Zero(resp.Count, k)
+	}
+}
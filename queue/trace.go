@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// every request gets X-Request-ID (kept from client if set) and access log line.
+// W3C traceparent from producer stored with task and returned to worker,
+// so worker continues same trace. tracestate is not stored on purpose: it is vendor
+// specific and unbounded, trace continues by traceparent alone
+
+const requestIDHeader = "X-Request-ID"
+
+// response bytes kept to find task id in put/get result
+const sniffLen = 1024
+
+var traceparentRe = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// traceID returns trace id from traceparent or empty string if traceparent is invalid
+func traceID(traceparent string) string {
+	m := traceparentRe.FindStringSubmatch(traceparent)
+	if m == nil || m[1] == "00000000000000000000000000000000" {
+		return ""
+	}
+	return m[1]
+}
+
+// validTrace returns traceparent to store with task, invalid one ignored as W3C requires
+func validTrace(traceparent *string) string {
+	if traceparent == nil || traceID(*traceparent) == "" {
+		return ""
+	}
+	return *traceparent
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// recorder keeps status and start of body for access log
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (r *recorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if n := sniffLen - len(r.body); n > 0 {
+		if n > len(b) {
+			n = len(b)
+		}
+		r.body = append(r.body, b[:n]...)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Hijack is required by websocket stream
+func (r *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.status = http.StatusSwitchingProtocols
+	return r.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+// Unwrap gives access to original writer, export clears server deadlines with it
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// taskOf returns task id and trace from request or from put/get response
+func (r *recorder) taskOf(req *http.Request) (string, string) {
+	var t struct{ ID, Trace string }
+	if r.status == http.StatusOK && len(r.body) > 0 && r.body[0] == '{' {
+		json.Unmarshal(r.body, &t)
+	}
+	if id := req.URL.Query().Get("task_id"); id != "" {
+		t.ID = id
+	}
+	if tp := req.Header.Get("traceparent"); tp != "" {
+		t.Trace = tp
+	}
+	return t.ID, t.Trace
+}
+
+// withRequest adds request id to log fields of operation, id is nil if not passed (stream, grpc without metadata)
+func withRequest(f log.Fields, requestID *string) log.Fields {
+	if requestID != nil && *requestID != "" {
+		f["request_id"] = *requestID
+	}
+	return f
+}
+
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			id = newRequestID()
+			r.Header.Set(requestIDHeader, id)
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		rec := &recorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		f := log.Fields{
+			"request_id": id,
+			"method":     r.URL.Path,
+			"status":     rec.status,
+			"latency":    time.Since(start).Seconds(),
+			"remote":     r.RemoteAddr,
+		}
+		if c := r.URL.Query().Get("client_id"); c != "" {
+			f["client"] = c
+		}
+		task, trace := rec.taskOf(r)
+		if task != "" {
+			f["task"] = task
+		}
+		if t := traceID(trace); t != "" {
+			f["trace_id"] = t
+		}
+		logger.WithFields(f).Info("access")
+	})
+}
+
+// AddAccessLog adds request id and access log to all routes
+func AddAccessLog(r *mux.Router) {
+	r.Use(accessLog)
+}
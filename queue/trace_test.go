@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceID(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("4bf92f3577b34da6a3ce929d0e0e4736", traceID("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+	assert.Equal("", traceID("00-00000000000000000000000000000000-00f067aa0ba902b7-01"))
+	assert.Equal("", traceID("00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"))
+	assert.Equal("", traceID("garbage"))
+
+	bad := "00-xyz"
+	assert.Equal("", validTrace(&bad))
+	assert.Equal("", validTrace(nil))
+}
+
+func TestRequestLogs(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	saved := logger
+	defer func() { logger = saved }()
+	var hook *test.Hook
+	logger, hook = test.NewNullLogger()
+	logger.SetLevel(log.DebugLevel)
+
+	data, w, timeout := "task", "w", int64(10)
+	put, get, ack := "req-put", "req-get", "req-ack"
+	_, task, err := putTask(&data, nil, nil, nil, nil, nil, nil, nil, nil, nil, &put)
+	assert.Nil(err)
+	_, _, err = getTask(&w, &timeout, nil, nil, &get)
+	assert.Nil(err)
+	_, _, err = ackTask(&w, &task.ID, nil, nil, nil, nil, nil, &ack)
+	assert.Nil(err)
+
+	ids := map[string]bool{}
+	for _, e := range hook.AllEntries() {
+		if id, ok := e.Data["request_id"]; ok {
+			ids[id.(string)] = true
+		}
+	}
+	assert.Equal(map[string]bool{put: true, get: true, ack: true}, ids)
+}
+
+func TestTracePropagation(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	r := CreateRouter(logger, funcHandler{})
+	AddAccessLog(r)
+	saved := logger
+	defer func() { logger = saved }()
+	var hook *test.Hook
+	logger, hook = test.NewNullLogger()
+
+	trace := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	call := func(target string, traceparent string) KV {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/v1/"+target, nil)
+		if traceparent != "" {
+			req.Header.Set("traceparent", traceparent)
+			req.Header.Set("tracestate", "vendor=x")
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(http.StatusOK, w.Code, target)
+		assert.NotContains(w.Body.String(), "vendor")
+		var task KV
+		json.Unmarshal(w.Body.Bytes(), &task)
+		return task
+	}
+
+	// put trace returned to worker, tracestate not stored
+	put := call("put?data=x", trace)
+	assert.Equal(trace, put.Trace)
+	got := call("get?client_id=w&timeout=10", "")
+	assert.Equal(KV{ID: put.ID, Value: "x", Trace: trace, Token: got.Token}, got)
+	assert.Equal("4bf92f3577b34da6a3ce929d0e0e4736", hook.LastEntry().Data["trace_id"])
+
+	// next task continues trace of ack
+	next := "01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	call("ack?client_id=w&task_id="+put.ID+"&next=y", next)
+	assert.Equal(next, call("get?client_id=w&timeout=10", "").Trace)
+
+	// invalid traceparent ignored
+	assert.Empty(call("put?data=z", "00-xyz").Trace)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// webhooks: leader posts events to configured urls as json, one event per request,
+// signed with hmac-sha256 of body. failed posts retried with backoff, every url has own
+// ordered queue, events dropped if queue full (catch up with /events)
+
+// Webhook is a receiver of events from settings
+type Webhook struct {
+	URL    string   `yaml:"url"`
+	Secret secret   `yaml:"secret"`
+	Events []string `yaml:"events"` // all if empty
+}
+
+// secret is not shown when settings logged
+type secret string
+
+func (secret) String() string { return "***" }
+
+const (
+	webhookAttempts = 5
+	webhookBackoff  = time.Second // doubled after every failed attempt
+	webhookQueue    = 1000
+)
+
+var webhookClient = &http.Client{Timeout: 5 * time.Second}
+
+func (h *Webhook) validate() error {
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("bad url %q", h.URL)
+	}
+	for _, t := range h.Events {
+		if !knownEvent(t) {
+			return fmt.Errorf("unknown event %q, one of %v expected", t, eventTypes)
+		}
+	}
+	return nil
+}
+
+func knownEvent(t string) bool {
+	for _, k := range eventTypes {
+		if k == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Webhook) wants(t string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// signature is value of X-Queue-Signature header
+func signature(key secret, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// queues of webhooks by url
+var webhookQueues struct {
+	sync.Mutex
+	m map[string]chan Event
+}
+
+// sendEvents queues events for webhooks interested in them
+func sendEvents(events []Event) {
+	hooks := current().Webhooks
+	webhookQueues.Lock()
+	defer webhookQueues.Unlock()
+	if webhookQueues.m == nil {
+		webhookQueues.m = make(map[string]chan Event)
+	}
+	for i := range hooks {
+		ch, ok := webhookQueues.m[hooks[i].URL]
+		if !ok {
+			ch = make(chan Event, webhookQueue)
+			webhookQueues.m[hooks[i].URL] = ch
+			go sendLoop(hooks[i].URL, ch)
+		}
+		for _, e := range events {
+			if !hooks[i].wants(e.Type) {
+				continue
+			}
+			select {
+			case ch <- e:
+			default:
+				logger.WithField("url", hooks[i].URL).WithField("revision", e.Revision).Warn("webhook queue full, event dropped")
+			}
+		}
+	}
+}
+
+// webhook returns current settings of webhook, nil if removed
+func webhook(u string) *Webhook {
+	hooks := current().Webhooks
+	for i := range hooks {
+		if hooks[i].URL == u {
+			return &hooks[i]
+		}
+	}
+	return nil
+}
+
+func sendLoop(u string, ch <-chan Event) {
+	for e := range ch {
+		body, err := json.Marshal(e)
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		wait := webhookBackoff
+		for i := 1; ; i++ {
+			h := webhook(u)
+			if h == nil {
+				break
+			}
+			if err = postEvent(h, &e, body); err == nil {
+				break
+			}
+			f := logger.WithField("url", u).WithField("revision", e.Revision).WithField("attempt", i)
+			if i == webhookAttempts {
+				f.Errorf("event not delivered: %v", err)
+				break
+			}
+			f.Warnf("fail to deliver event: %v", err)
+			time.Sleep(wait)
+			wait *= 2
+		}
+	}
+}
+
+func postEvent(h *Webhook, e *Event, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Queue-Event", e.Type)
+	req.Header.Set("X-Queue-Revision", strconv.FormatInt(e.Revision, 10))
+	if h.Secret != "" {
+		req.Header.Set("X-Queue-Signature", signature(h.Secret, body))
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returns %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/client/v3"
+)
+
+// worker registry: worker key kept with heartbeat lease, value is tags and last seen time.
+// task put with `require` tags leased only by registered worker having all of them,
+// tasks without requirements go to any client
+
+// Worker is a registered worker or client holding tasks
+type Worker struct {
+	ID         string
+	Registered bool
+	Tags       []string `json:",omitempty"`
+	LastSeen   string   `json:",omitempty"`
+	TTL        int64    `json:",omitempty"` // seconds left
+	Tasks      []string `json:",omitempty"` // held, `group:` prefixed in topic mode
+}
+
+// workerInfo is value of worker key
+type workerInfo struct {
+	Tags     []string `json:",omitempty"`
+	LastSeen time.Time
+}
+
+func workerPrefix() string {
+	return "__worker:" + cfg.Queue + ":"
+}
+
+func workerKey(clientID string) string {
+	return workerPrefix() + clientID
+}
+
+// checkTags ensures tags are `key=value`
+func checkTags(tags *[]string) ([]string, error) {
+	if tags == nil {
+		return nil, nil
+	}
+	for _, t := range *tags {
+		if i := strings.Index(t, "="); i < 1 {
+			return nil, fmt.Errorf("bad tag %q, key=value expected", t)
+		}
+	}
+	result := append([]string(nil), *tags...)
+	sort.Strings(result)
+	return result, nil
+}
+
+// hasTags returns true if worker have all required tags
+func hasTags(w *workerInfo, required []string) bool {
+	if w == nil {
+		return len(required) == 0
+	}
+	have := make(map[string]bool, len(w.Tags))
+	for _, t := range w.Tags {
+		have[t] = true
+	}
+	for _, t := range required {
+		if !have[t] {
+			return false
+		}
+	}
+	return true
+}
+
+// loadWorker returns worker info, nil if client not registered
+func loadWorker(ctx context.Context, clientID string) (*workerInfo, error) {
+	resp, err := client.Get(ctx, workerKey(clientID))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+	var w workerInfo
+	if err = json.Unmarshal(resp.Kvs[0].Value, &w); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// registerWorker puts worker with new lease, old registration replaced
+func registerWorker(clientID *string, ttl *int64, tags *[]string) (int, *Worker, error) {
+	info := workerInfo{LastSeen: time.Now().UTC()}
+	var err error
+	if info.Tags, err = checkTags(tags); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	value, err := json.Marshal(info)
+	if err != nil {
+		return http.StatusInternalServerError, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	lease, err := client.Grant(ctx, *ttl)
+	if err != nil {
+		return http.StatusInternalServerError, nil, fmt.Errorf("fail to create a lease")
+	}
+	resp, err := client.Put(ctx, workerKey(*clientID), string(value), clientv3.WithLease(lease.ID), clientv3.WithPrevKV())
+	if err != nil {
+		client.Revoke(ctx, lease.ID)
+		return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to register worker")
+	}
+	if resp.PrevKv != nil && resp.PrevKv.Lease != 0 {
+		client.Revoke(ctx, clientv3.LeaseID(resp.PrevKv.Lease))
+	}
+	logger.WithField("client", *clientID).WithField("tags", info.Tags).Info("worker registered")
+	return http.StatusOK, &Worker{ID: *clientID, Registered: true, Tags: info.Tags,
+		LastSeen: info.LastSeen.Format(time.RFC3339), TTL: *ttl}, nil
+}
+
+// heartbeatWorker refreshes worker lease and last seen time
+func heartbeatWorker(clientID *string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	resp, err := client.Get(ctx, workerKey(*clientID))
+	if err != nil {
+		return http.StatusInternalServerError, errors.Wrap(err, "fail to get worker")
+	}
+	if len(resp.Kvs) == 0 {
+		return http.StatusNotFound, fmt.Errorf("worker %v not registered", *clientID)
+	}
+	kv := resp.Kvs[0]
+	if _, err = client.KeepAliveOnce(ctx, clientv3.LeaseID(kv.Lease)); err != nil {
+		return http.StatusNotFound, fmt.Errorf("worker %v lease expired", *clientID)
+	}
+	var info workerInfo
+	if err = json.Unmarshal(kv.Value, &info); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	info.LastSeen = time.Now().UTC()
+	value, err := json.Marshal(info)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	// registration not replaced meanwhile
+	_, err = client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(workerKey(*clientID)), "=", kv.ModRevision)).
+		Then(clientv3.OpPut(workerKey(*clientID), string(value), clientv3.WithIgnoreLease())).
+		Commit()
+	if err != nil {
+		return http.StatusInternalServerError, errors.Wrap(err, "fail to update worker")
+	}
+	return http.StatusOK, nil
+}
+
+// unregisterWorker removes worker, held tasks kept until lease expire or ack
+func unregisterWorker(clientID *string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	resp, err := client.Delete(ctx, workerKey(*clientID), clientv3.WithPrevKV())
+	if err != nil {
+		return http.StatusInternalServerError, errors.Wrap(err, "fail to unregister worker")
+	}
+	if len(resp.PrevKvs) == 0 {
+		return http.StatusNotFound, fmt.Errorf("worker %v not registered", *clientID)
+	}
+	client.Revoke(ctx, clientv3.LeaseID(resp.PrevKvs[0].Lease))
+	logger.WithField("client", *clientID).Info("worker unregistered")
+	return http.StatusOK, nil
+}
+
+// listWorkers returns registered workers and clients holding tasks without registration
+func listWorkers() (int, *[]Worker, error) {
+	code, leases, err := listLeases()
+	if err != nil {
+		return code, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	resp, err := client.Get(ctx, workerPrefix(), clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to get workers")
+	}
+
+	result := []Worker{}
+	index := make(map[string]int)
+	for _, ev := range resp.Kvs {
+		var info workerInfo
+		if err = json.Unmarshal(ev.Value, &info); err != nil {
+			return http.StatusInternalServerError, nil, errors.Wrapf(err, "bad worker %s", ev.Key)
+		}
+		l, err := client.TimeToLive(ctx, clientv3.LeaseID(ev.Lease))
+		if err != nil {
+			return http.StatusInternalServerError, nil, errors.Wrap(err, "fail to get lease ttl")
+		}
+		w := Worker{ID: string(ev.Key)[len(workerPrefix()):], Registered: true, Tags: info.Tags,
+			LastSeen: info.LastSeen.Format(time.RFC3339), TTL: l.TTL}
+		index[w.ID] = len(result)
+		result = append(result, w)
+	}
+	for _, l := range *leases {
+		i, ok := index[l.Client]
+		if !ok {
+			i = len(result)
+			index[l.Client] = i
+			result = append(result, Worker{ID: l.Client})
+		}
+		task := l.ID
+		if l.Group != "" {
+			task = l.Group + ":" + task
+		}
+		result[i].Tasks = append(result[i].Tasks, task)
+	}
+	return http.StatusOK, &result, nil
+}
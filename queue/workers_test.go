@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/client/v3"
+)
+
+func TestTags(t *testing.T) {
+	assert := assert.New(t)
+
+	tags, err := checkTags(&[]string{"region=eu", "gpu=false"})
+	assert.Nil(err)
+	assert.Equal([]string{"gpu=false", "region=eu"}, tags)
+	_, err = checkTags(&[]string{"gpu"})
+	assert.NotNil(err)
+	_, err = checkTags(&[]string{"=x"})
+	assert.NotNil(err)
+
+	w := &workerInfo{Tags: tags}
+	assert.True(hasTags(w, nil))
+	assert.True(hasTags(w, []string{"region=eu"}))
+	assert.False(hasTags(w, []string{"gpu=true"}))
+	assert.True(hasTags(nil, nil))
+	assert.False(hasTags(nil, []string{"region=eu"})) // not registered
+}
+
+// register registers worker with tags
+func register(t *testing.T, clientID string, ttl int64, tags ...string) *Worker {
+	t.Helper()
+	code, w, err := registerWorker(&clientID, &ttl, &tags)
+	if err != nil {
+		t.Fatalf("register: %v %v", code, err)
+	}
+	return w
+}
+
+func TestRegisterWorker(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	w := register(t, "w", 60, "region=eu", "gpu=true")
+	assert.Equal([]string{"gpu=true", "region=eu"}, w.Tags)
+	info, err := loadWorker(context.Background(), "w")
+	assert.Nil(err)
+	assert.Equal(w.Tags, info.Tags)
+
+	// registration replaced, old lease revoked
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	resp, err := client.Get(ctx, workerKey("w"))
+	assert.Nil(err)
+	old := clientv3.LeaseID(resp.Kvs[0].Lease)
+	register(t, "w", 60, "region=us")
+	info, err = loadWorker(ctx, "w")
+	assert.Nil(err)
+	assert.Equal([]string{"region=us"}, info.Tags)
+	l, err := client.TimeToLive(ctx, old)
+	assert.Nil(err)
+	assert.Equal(int64(-1), l.TTL)
+
+	id, ttl, tags := "w", int64(60), []string{"gpu"}
+	code, _, err := registerWorker(&id, &ttl, &tags)
+	assert.NotNil(err)
+	assert.Equal(http.StatusBadRequest, code)
+}
+
+func TestHeartbeatWorker(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	register(t, "w", 60)
+	before, err := loadWorker(ctx, "w")
+	assert.Nil(err)
+	resp, err := client.Get(ctx, workerKey("w"))
+	assert.Nil(err)
+	leaseID := resp.Kvs[0].Lease
+
+	id := "w"
+	code, err := heartbeatWorker(&id)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	after, err := loadWorker(ctx, "w")
+	assert.Nil(err)
+	assert.True(after.LastSeen.After(before.LastSeen))
+	// key stays attached to lease
+	resp, err = client.Get(ctx, workerKey("w"))
+	assert.Nil(err)
+	assert.Equal(leaseID, resp.Kvs[0].Lease)
+
+	unknown := "x"
+	code, err = heartbeatWorker(&unknown)
+	assert.NotNil(err)
+	assert.Equal(http.StatusNotFound, code)
+}
+
+func TestWorkerExpiry(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	register(t, "w", 1)
+	// etcd may round ttl up to its minimum
+	var info *workerInfo
+	var err error
+	for i := 0; i < 100; i++ {
+		if info, err = loadWorker(context.Background(), "w"); err != nil || info == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Nil(err)
+	assert.Nil(info)
+
+	id := "w"
+	code, err := heartbeatWorker(&id)
+	assert.NotNil(err)
+	assert.Equal(http.StatusNotFound, code)
+}
+
+func TestUnregisterWorker(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	register(t, "w", 60, "gpu=true")
+	addTask(t, "task")
+	task := lease(t, "w", "")
+
+	id := "w"
+	code, err := unregisterWorker(&id)
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	info, err := loadWorker(context.Background(), "w")
+	assert.Nil(err)
+	assert.Nil(info)
+	// held task kept
+	ack(t, "w", "", task.ID)
+
+	code, err = unregisterWorker(&id)
+	assert.NotNil(err)
+	assert.Equal(http.StatusNotFound, code)
+}
+
+func TestListWorkers(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t, "a")
+	register(t, "w1", 60, "gpu=true")
+	register(t, "w2", 60)
+	addTask(t, "task")
+	task := lease(t, "c", "a")
+
+	code, workers, err := listWorkers()
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, code)
+	assert.Len(*workers, 3)
+	w1, w2, c := (*workers)[0], (*workers)[1], (*workers)[2]
+	assert.Equal("w1", w1.ID)
+	assert.True(w1.Registered)
+	assert.Equal([]string{"gpu=true"}, w1.Tags)
+	assert.True(w1.TTL > 0 && w1.TTL <= 60, w1.TTL)
+	assert.Equal("w2", w2.ID)
+	assert.Empty(w2.Tags)
+	// client holding task without registration
+	assert.Equal(Worker{ID: "c", Tasks: []string{"a:" + task.ID}}, c)
+}
+
+func TestRequireTags(t *testing.T) {
+	assert := assert.New(t)
+	withEtcd(t)
+	register(t, "cpu", 60, "gpu=false")
+	register(t, "gpu", 60, "gpu=true", "region=eu")
+	data, require := "render", []string{"gpu=true"}
+	code, put, err := putTask(&data, nil, nil, nil, nil, &require, nil, nil, nil, nil, nil)
+	assert.Nil(err, code)
+	plain := addTask(t, "plain")
+
+	// task without requirements goes to any client
+	assert.Equal(plain, lease(t, "anonymous", "").ID)
+	timeout := int64(10)
+	for _, id := range []string{"other", "cpu"} {
+		code, _, err = getTask(&id, &timeout, nil, nil, nil)
+		assert.Nil(err)
+		assert.Equal(http.StatusNoContent, code, id)
+	}
+	assert.Equal(put.ID, lease(t, "gpu", "").ID)
+
+	bad := []string{"gpu"}
+	code, _, err = putTask(&data, nil, nil, nil, nil, &bad, nil, nil, nil, nil, nil)
+	assert.NotNil(err)
+	assert.Equal(http.StatusBadRequest, code)
+}